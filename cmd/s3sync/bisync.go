@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3sync"
+)
+
+type bisyncCommand struct {
+	Conflict string `long:"conflict" description:"What to do with a file changed on both sides: newer-wins, source-wins or keep-both." default:"newer-wins"`
+	State    string `long:"state" description:"The file the state of the last bisync is kept in. Defaults to a file per pair of paths under the user cache directory."`
+	Args     struct {
+		A string `positional-arg-name:"A" description:"The first side, either absolute local path or s3://<bucket>/<path>"`
+		B string `positional-arg-name:"B" description:"The second side, either absolute local path or s3://<bucket>/<path>"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute syncs A and B in both directions
+func (c *bisyncCommand) Execute(args []string) error {
+	var (
+		err    error
+		state  string
+		syncer s3sync.Syncer
+	)
+
+	opts.Source = c.Args.A
+	opts.Destination = c.Args.B
+
+	syncer, err = newSyncer()
+	if err != nil {
+		return err
+	}
+
+	state = c.State
+	if state == "" {
+		state, err = defaultBisyncState(c.Args.A, c.Args.B)
+		if err != nil {
+			return err
+		}
+	}
+
+	return syncer.Bisync(state, c.Conflict)
+}
+
+// defaultBisyncState returns the state file used for a pair of paths when --state is not given
+func defaultBisyncState(a string, b string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum([]byte(a + "\n" + b))
+	return filepath.Join(dir, "s3sync", "bisync-"+hex.EncodeToString(sum[:8])+".json"), nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+
+	flags "github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+)
+
+// jobsConfig is the file of named sync jobs read with --config. The keys of defaults and of each
+// job are the long option names, e.g. max-threads, and jobs are applied on top of the defaults.
+// schedule is the only other key, a cron expression used by the daemon command.
+type jobsConfig struct {
+	Defaults map[string]interface{}            `yaml:"defaults"`
+	Jobs     map[string]map[string]interface{} `yaml:"jobs"`
+}
+
+// scheduleKey holds the cron expression of a job
+const scheduleKey = "schedule"
+
+// envPattern matches the ${NAME} references replaced in config values
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// readJobsConfig reads and checks a jobs config file
+func readJobsConfig(path string) (*jobsConfig, error) {
+	var config jobsConfig
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.UnmarshalStrict(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the config %s: %s", path, err)
+	}
+	if len(config.Jobs) == 0 {
+		return nil, fmt.Errorf("the config %s has no jobs", path)
+	}
+
+	return &config, nil
+}
+
+// names returns the job names in order
+func (c *jobsConfig) names() []string {
+	names := make([]string, 0, len(c.Jobs))
+	for name := range c.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// schedule returns the cron expression a job runs on, which can be left to the defaults
+func (c *jobsConfig) schedule(name string) string {
+	value, ok := c.Jobs[name][scheduleKey]
+	if ok == false {
+		value = c.Defaults[scheduleKey]
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// options parses the options of a job, followed by overrides, as if they were given on the
+// command line
+func (c *jobsConfig) options(name string, overrides []string) (Options, error) {
+	var (
+		args    []string
+		options Options
+		values  map[string]interface{}
+	)
+
+	job, ok := c.Jobs[name]
+	if ok == false {
+		return options, fmt.Errorf("there is no job %q in the config", name)
+	}
+
+	values = make(map[string]interface{})
+	for key, value := range c.Defaults {
+		values[key] = value
+	}
+	for key, value := range job {
+		values[key] = value
+	}
+	delete(values, scheduleKey)
+
+	args, err := optionArgs(values)
+	if err != nil {
+		return options, err
+	}
+
+	_, err = flags.NewParser(&options, flags.None).ParseArgs(append(args, overrides...))
+	if err != nil {
+		return options, err
+	}
+
+	return options, nil
+}
+
+// optionArgs turns config values into command line arguments. true adds a flag and false leaves
+// it out, lists repeat the option and maps become repeated key=value options.
+func optionArgs(values map[string]interface{}) ([]string, error) {
+	var (
+		args []string
+		keys []string
+	)
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var items []string
+
+		switch value := values[key].(type) {
+		case nil:
+		case bool:
+			if value == true {
+				args = append(args, "--"+key)
+			}
+			continue
+		case []interface{}:
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+		case map[interface{}]interface{}:
+			for k, v := range value {
+				items = append(items, fmt.Sprintf("%v=%v", k, v))
+			}
+			sort.Strings(items)
+		default:
+			items = []string{fmt.Sprint(value)}
+		}
+
+		for _, item := range items {
+			item, err := interpolate(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			args = append(args, "--"+key+"="+item)
+		}
+	}
+
+	return args, nil
+}
+
+// interpolate replaces each ${NAME} in value with the environment variable NAME, which must be set
+func interpolate(value string) (string, error) {
+	var err error
+
+	value = envPattern.ReplaceAllStringFunc(value, func(match string) string {
+		name := envPattern.FindStringSubmatch(match)[1]
+		env, ok := os.LookupEnv(name)
+		if ok == false && err == nil {
+			err = fmt.Errorf("the environment variable %s is not set", name)
+		}
+		return env
+	})
+
+	return value, err
+}
+
+// commandLineArgs returns the options given on the command line, other than --config, as
+// arguments that can be parsed again after the ones from a job
+func commandLineArgs(parser *flags.Parser) []string {
+	var args []string
+
+	for _, group := range parser.Groups() {
+		for _, option := range group.Options() {
+			if option.IsSet() == false || option.IsSetDefault() == true || option.LongName == "config" {
+				continue
+			}
+
+			switch value := option.Value().(type) {
+			case bool:
+				args = append(args, "--"+option.LongName)
+			case []string:
+				for _, item := range value {
+					args = append(args, "--"+option.LongName+"="+item)
+				}
+			default:
+				args = append(args, "--"+option.LongName+"="+fmt.Sprint(value))
+			}
+		}
+	}
+
+	return args
+}
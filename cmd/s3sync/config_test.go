@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	os.Setenv("S3SYNC_TEST_BUCKET", "my-bucket")
+	os.Setenv("S3SYNC_TEST_EMPTY", "")
+	os.Unsetenv("S3SYNC_TEST_UNSET")
+	defer os.Unsetenv("S3SYNC_TEST_BUCKET")
+	defer os.Unsetenv("S3SYNC_TEST_EMPTY")
+
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{value: "s3://${S3SYNC_TEST_BUCKET}/logs", want: "s3://my-bucket/logs"},
+		{value: "${S3SYNC_TEST_BUCKET}-${S3SYNC_TEST_BUCKET}", want: "my-bucket-my-bucket"},
+		{value: "a${S3SYNC_TEST_EMPTY}b", want: "ab"},
+		{value: "$S3SYNC_TEST_BUCKET and ${not a name}", want: "$S3SYNC_TEST_BUCKET and ${not a name}"},
+		{value: "${S3SYNC_TEST_UNSET}", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := interpolate(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("interpolate(%q) = %q, want an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("interpolate(%q) = %q, %v, want %q", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestJobOptions(t *testing.T) {
+	os.Setenv("S3SYNC_TEST_BUCKET", "my-bucket")
+	defer os.Unsetenv("S3SYNC_TEST_BUCKET")
+
+	config := &jobsConfig{
+		Defaults: map[string]interface{}{
+			"region":      "us-east-1",
+			"max-threads": 4,
+			"delete":      true,
+			"schedule":    "@hourly",
+		},
+		Jobs: map[string]map[string]interface{}{
+			"logs": {
+				"source":      "/var/log",
+				"destination": "s3://${S3SYNC_TEST_BUCKET}/logs",
+				"delete":      false,
+				"exclude":     []interface{}{"*.tmp", "*.swp"},
+				"tag":         map[interface{}]interface{}{"team": "ops", "env": "prod"},
+				"lock-ttl":    "10m",
+			},
+			"missing": {
+				"source": "/data",
+				"tag":    []interface{}{"owner=${S3SYNC_TEST_UNSET}"},
+			},
+		},
+	}
+
+	options, err := config.options("logs", []string{"--max-threads=8", "--dryrun"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Source != "/var/log" || options.Destination != "s3://my-bucket/logs" || options.Region != "us-east-1" {
+		t.Errorf("options = %s to %s in %s, want /var/log to s3://my-bucket/logs in us-east-1", options.Source, options.Destination, options.Region)
+	}
+	if options.Delete == true {
+		t.Errorf("delete: false in the job did not override the defaults")
+	}
+	if options.MaxThreads != 8 || options.Dryrun == false {
+		t.Errorf("max-threads = %d and dryrun = %t, want the overrides 8 and true", options.MaxThreads, options.Dryrun)
+	}
+	if reflect.DeepEqual(options.Exclude, []string{"*.tmp", "*.swp"}) == false {
+		t.Errorf("exclude = %v, want both patterns in order", options.Exclude)
+	}
+	if reflect.DeepEqual(options.Tags, []string{"env=prod", "team=ops"}) == false {
+		t.Errorf("tag = %v, want env=prod and team=ops", options.Tags)
+	}
+	if options.LockTTL.Minutes() != 10 {
+		t.Errorf("lock-ttl = %s, want 10m", options.LockTTL)
+	}
+	if schedule := config.schedule("logs"); schedule != "@hourly" {
+		t.Errorf("schedule = %q, want the default @hourly", schedule)
+	}
+
+	_, err = config.options("missing", nil)
+	if err == nil {
+		t.Errorf("a job using an unset environment variable was accepted")
+	}
+
+	_, err = config.options("other", nil)
+	if err == nil {
+		t.Errorf("a job that isn't in the config was accepted")
+	}
+}
+
+func TestJobOptionsUnknownOption(t *testing.T) {
+	config := &jobsConfig{Jobs: map[string]map[string]interface{}{"job": {"no-such-option": 1}}}
+
+	_, err := config.options("job", nil)
+	if err == nil {
+		t.Errorf("a job with an unknown option was accepted")
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3sync"
+	flags "github.com/jessevdk/go-flags"
+	"github.com/robfig/cron/v3"
+)
+
+type daemonCommand struct {
+	History  int    `long:"history" description:"How many runs of each job to keep in the state." default:"100"`
+	Listen   string `long:"listen" description:"The address of the HTTP status endpoint. Empty disables it." default:"127.0.0.1:8421"`
+	StateDir string `long:"state-dir" description:"The directory the run history is kept in. (default: <user cache dir>/s3sync/daemon)"`
+
+	parser *flags.Parser
+}
+
+// The values of jobRun.Status
+const (
+	runFailed  = "failed"
+	runOK      = "ok"
+	runSkipped = "skipped"
+)
+
+// jobRun is one run of a job as kept in the daemon state
+type jobRun struct {
+	Started  time.Time          `json:"started"`
+	Finished time.Time          `json:"finished"`
+	Status   string             `json:"status"`
+	Error    string             `json:"error,omitempty"`
+	Result   *s3sync.SyncResult `json:"result,omitempty"`
+}
+
+// jobStatus is what the status endpoint reports for a job
+type jobStatus struct {
+	Schedule    string     `json:"schedule"`
+	Running     bool       `json:"running"`
+	Next        time.Time  `json:"next"`
+	Last        *jobRun    `json:"last,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// daemon runs the scheduled jobs of a config and keeps the history of their runs. runner syncs a
+// single job.
+type daemon struct {
+	config    *jobsConfig
+	cron      *cron.Cron
+	entries   map[string]cron.EntryID
+	history   int
+	lock      sync.Mutex
+	overrides []string
+	runner    func(name string) (s3sync.SyncResult, error)
+	running   map[string]bool
+	runs      map[string][]jobRun
+	statePath string
+}
+
+// Execute runs every job with a schedule until interrupted
+func (c *daemonCommand) Execute(args []string) error {
+	var (
+		config  *jobsConfig
+		d       *daemon
+		err     error
+		server  *http.Server
+		signals chan os.Signal
+	)
+
+	if opts.Config == "" {
+		return fmt.Errorf("the daemon command needs a --config file")
+	}
+	if c.History < 1 {
+		return fmt.Errorf("--history must be at least 1")
+	}
+
+	config, err = readJobsConfig(opts.Config)
+	if err != nil {
+		return err
+	}
+
+	if c.StateDir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return fmt.Errorf("unable to find the user cache directory, use --state-dir: %s", err)
+		}
+		c.StateDir = filepath.Join(cacheDir, "s3sync", "daemon")
+	}
+
+	d = &daemon{
+		config:    config,
+		cron:      cron.New(),
+		entries:   make(map[string]cron.EntryID),
+		history:   c.History,
+		overrides: commandLineArgs(c.parser),
+		running:   make(map[string]bool),
+		statePath: filepath.Join(c.StateDir, "state.json"),
+	}
+	d.runner = func(name string) (s3sync.SyncResult, error) {
+		return runJob(d.config, name, d.overrides)
+	}
+
+	d.runs, err = readDaemonState(d.statePath)
+	if err != nil {
+		return err
+	}
+
+	err = d.schedule()
+	if err != nil {
+		return err
+	}
+
+	if c.Listen != "" {
+		server = &http.Server{Addr: c.Listen, Handler: d.handler()}
+		go func() {
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				fmt.Printf("unable to serve the status endpoint: %s\n", err)
+			}
+		}()
+	}
+
+	d.cron.Start()
+	fmt.Printf("running %d scheduled job(s)\n", len(d.entries))
+
+	signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	fmt.Println("stopping, waiting for running jobs to finish")
+	<-d.cron.Stop().Done()
+	if server != nil {
+		server.Shutdown(context.Background())
+	}
+
+	return nil
+}
+
+// schedule adds every job with a schedule to the cron. Every job is checked before the daemon
+// starts so a typo doesn't wait for the schedule to show up.
+func (d *daemon) schedule() error {
+	var err error
+
+	for _, name := range d.config.names() {
+		expression := d.config.schedule(name)
+		if expression == "" {
+			fmt.Printf("job %s has no schedule and will not run\n", name)
+			continue
+		}
+
+		_, err = d.config.options(name, d.overrides)
+		if err != nil {
+			return fmt.Errorf("job %s: %s", name, err)
+		}
+
+		name := name
+		d.entries[name], err = d.cron.AddFunc(expression, func() { d.run(name) })
+		if err != nil {
+			return fmt.Errorf("job %s: invalid schedule %q: %s", name, expression, err)
+		}
+	}
+	if len(d.entries) == 0 {
+		return fmt.Errorf("no job in %s has a schedule", opts.Config)
+	}
+
+	return nil
+}
+
+// run runs a job unless the previous run of it is still going, and records the run
+func (d *daemon) run(name string) {
+	var run jobRun
+
+	d.lock.Lock()
+	if d.running[name] == true {
+		d.lock.Unlock()
+		fmt.Printf("job %s is still running, skipping this run\n", name)
+		now := time.Now().UTC()
+		d.record(name, jobRun{Started: now, Finished: now, Status: runSkipped, Error: "the previous run was still running"})
+		return
+	}
+	d.running[name] = true
+	d.lock.Unlock()
+
+	fmt.Printf("running job %s\n", name)
+	run.Started = time.Now().UTC()
+	result, err := d.runner(name)
+	run.Finished = time.Now().UTC()
+	run.Result = &result
+	run.Status = runOK
+	if err != nil {
+		fmt.Printf("job %s failed: %s\n", name, err)
+		run.Status = runFailed
+		run.Error = err.Error()
+	}
+
+	d.lock.Lock()
+	d.running[name] = false
+	d.lock.Unlock()
+
+	d.record(name, run)
+}
+
+// record adds a run to the history of a job, keeping the most recent history runs, and saves the state
+func (d *daemon) record(name string, run jobRun) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	runs := append(d.runs[name], run)
+	if len(runs) > d.history {
+		runs = runs[len(runs)-d.history:]
+	}
+	d.runs[name] = runs
+
+	err := s3sync.WriteJSONFile(d.statePath, d.runs)
+	if err != nil {
+		fmt.Printf("unable to save the daemon state: %s\n", err)
+	}
+}
+
+// status returns the status of every scheduled job and whether the last run of each succeeded
+func (d *daemon) status() (map[string]jobStatus, bool) {
+	var (
+		healthy  bool
+		statuses map[string]jobStatus
+	)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	healthy = true
+	statuses = make(map[string]jobStatus)
+
+	for name, id := range d.entries {
+		status := jobStatus{
+			Schedule: d.config.schedule(name),
+			Running:  d.running[name],
+			Next:     d.cron.Entry(id).Next,
+		}
+
+		runs := d.runs[name]
+		for i := len(runs) - 1; i >= 0; i-- {
+			if runs[i].Status == runSkipped {
+				continue
+			}
+			if status.Last == nil {
+				last := runs[i]
+				status.Last = &last
+				if last.Status == runFailed {
+					healthy = false
+				}
+			}
+			if runs[i].Status == runOK {
+				finished := runs[i].Finished
+				status.LastSuccess = &finished
+				break
+			}
+		}
+
+		statuses[name] = status
+	}
+
+	return statuses, healthy
+}
+
+// handler serves the health, status and history of the jobs as JSON. /health answers 503 while
+// the last run of any job failed.
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		var failing []string
+
+		statuses, healthy := d.status()
+		for name, status := range statuses {
+			if status.Last != nil && status.Last.Status == runFailed {
+				failing = append(failing, name)
+			}
+		}
+
+		if healthy == false {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "failing", "failing": failing})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		statuses, _ := d.status()
+		writeJSON(w, http.StatusOK, statuses)
+	})
+
+	mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("job")
+
+		d.lock.Lock()
+		runs, ok := d.runs[name]
+		runs = append([]jobRun{}, runs...)
+		d.lock.Unlock()
+
+		if ok == false {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no runs of job %q", name)})
+			return
+		}
+		writeJSON(w, http.StatusOK, runs)
+	})
+
+	return mux
+}
+
+// writeJSON writes v as the JSON response
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// readDaemonState reads the run history saved by an earlier daemon, or returns an empty one
+func readDaemonState(path string) (map[string][]jobRun, error) {
+	var runs map[string][]jobRun
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(map[string][]jobRun), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &runs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the daemon state %s: %s", path, err)
+	}
+	if runs == nil {
+		runs = make(map[string][]jobRun)
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3sync"
+	"github.com/robfig/cron/v3"
+)
+
+// newTestDaemon returns a daemon for config that keeps its state in a temp directory
+func newTestDaemon(t *testing.T, config *jobsConfig, history int) (*daemon, func()) {
+	dir, err := ioutil.TempDir("", "s3sync-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &daemon{
+		config:    config,
+		cron:      cron.New(),
+		entries:   make(map[string]cron.EntryID),
+		history:   history,
+		running:   make(map[string]bool),
+		runs:      make(map[string][]jobRun),
+		statePath: filepath.Join(dir, "state.json"),
+	}
+
+	return d, func() { os.RemoveAll(dir) }
+}
+
+func TestDaemonSchedule(t *testing.T) {
+	config := &jobsConfig{
+		Jobs: map[string]map[string]interface{}{
+			"nightly": {"source": "/data", "destination": "s3://bucket/data", "schedule": "0 3 * * *"},
+			"manual":  {"source": "/data", "destination": "s3://bucket/manual"},
+		},
+	}
+	d, cleanup := newTestDaemon(t, config, 10)
+	defer cleanup()
+
+	err := d.schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.entries["manual"]; ok == true {
+		t.Errorf("a job without a schedule was scheduled")
+	}
+	id, ok := d.entries["nightly"]
+	if ok == false {
+		t.Fatalf("the nightly job was not scheduled")
+	}
+
+	from := time.Date(2026, 1, 1, 10, 0, 0, 0, time.Local)
+	want := time.Date(2026, 1, 2, 3, 0, 0, 0, time.Local)
+	if next := d.cron.Entry(id).Schedule.Next(from); next.Equal(want) == false {
+		t.Errorf("next run after %s = %s, want %s", from, next, want)
+	}
+
+	tests := []struct {
+		jobs map[string]map[string]interface{}
+		want string
+	}{
+		{
+			jobs: map[string]map[string]interface{}{"bad": {"source": "/data", "schedule": "61 * * * *"}},
+			want: "invalid schedule",
+		},
+		{
+			jobs: map[string]map[string]interface{}{"bad": {"source": "/data", "no-such-option": 1, "schedule": "@hourly"}},
+			want: "job bad",
+		},
+		{
+			jobs: map[string]map[string]interface{}{"manual": {"source": "/data"}},
+			want: "no job",
+		},
+	}
+
+	for _, test := range tests {
+		d, cleanup := newTestDaemon(t, &jobsConfig{Jobs: test.jobs}, 10)
+		err := d.schedule()
+		cleanup()
+		if err == nil || strings.Contains(err.Error(), test.want) == false {
+			t.Errorf("schedule(%v) = %v, want an error containing %q", test.jobs, err, test.want)
+		}
+	}
+}
+
+func TestDaemonSkipsOverlappingRuns(t *testing.T) {
+	var wg sync.WaitGroup
+
+	d, cleanup := newTestDaemon(t, &jobsConfig{}, 10)
+	defer cleanup()
+
+	started := make(chan bool)
+	release := make(chan bool)
+	d.runner = func(name string) (s3sync.SyncResult, error) {
+		started <- true
+		<-release
+		return s3sync.SyncResult{Transferred: 3, TransferredBytes: 300}, nil
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.run("job")
+	}()
+	<-started
+
+	// The first run is still going, so this one is skipped without calling the runner
+	d.run("job")
+	close(release)
+	wg.Wait()
+
+	runs, err := readDaemonState(d.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs["job"]) != 2 {
+		t.Fatalf("recorded %d run(s), want 2", len(runs["job"]))
+	}
+
+	skipped, ran := runs["job"][0], runs["job"][1]
+	if skipped.Status != runSkipped || skipped.Result != nil {
+		t.Errorf("overlapping run = %+v, want a skipped run without a result", skipped)
+	}
+	if ran.Status != runOK || ran.Result == nil || ran.Result.Transferred != 3 || ran.Result.TransferredBytes != 300 {
+		t.Errorf("first run = %+v, want an ok run with its result", ran)
+	}
+	if d.running["job"] == true {
+		t.Errorf("the job is still marked as running")
+	}
+}
+
+func TestDaemonStatus(t *testing.T) {
+	config := &jobsConfig{
+		Jobs: map[string]map[string]interface{}{
+			"job": {"source": "/data", "destination": "s3://bucket/data", "schedule": "@hourly"},
+		},
+	}
+	d, cleanup := newTestDaemon(t, config, 2)
+	defer cleanup()
+
+	err := d.schedule()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fail := false
+	d.runner = func(name string) (s3sync.SyncResult, error) {
+		if fail == true {
+			return s3sync.SyncResult{Failed: 1}, fmt.Errorf("1 of 1 transfer(s) failed")
+		}
+		return s3sync.SyncResult{}, nil
+	}
+
+	d.run("job")
+	d.run("job")
+	fail = true
+	d.run("job")
+
+	if len(d.runs["job"]) != 2 {
+		t.Errorf("kept %d run(s), want the last 2", len(d.runs["job"]))
+	}
+
+	statuses, healthy := d.status()
+	status := statuses["job"]
+	if healthy == true {
+		t.Errorf("healthy with a failed last run")
+	}
+	if status.Last == nil || status.Last.Status != runFailed || status.Last.Result == nil || status.Last.Result.Failed != 1 {
+		t.Errorf("last run = %+v, want the failed run with its result", status.Last)
+	}
+	if status.LastSuccess == nil || status.LastSuccess.Equal(d.runs["job"][0].Finished) == false {
+		t.Errorf("last success = %v, want %s", status.LastSuccess, d.runs["job"][0].Finished)
+	}
+
+	// A skipped run does not hide the failure before it
+	d.record("job", jobRun{Status: runSkipped})
+	fail = false
+	if _, healthy = d.status(); healthy == true {
+		t.Errorf("healthy after a skipped run following a failure")
+	}
+
+	d.run("job")
+	if _, healthy = d.status(); healthy == false {
+		t.Errorf("not healthy after a successful run")
+	}
+}
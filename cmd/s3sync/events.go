@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3sync"
+)
+
+type eventsCommand struct {
+	Queue       string        `long:"queue" description:"The URL of the SQS queue receiving the source bucket's event notifications."`
+	File        string        `long:"file" description:"Read event notifications from a file instead, one JSON message after another. - reads stdin."`
+	SQSEndpoint string        `long:"sqs-endpoint" description:"Use a different SQS endpoint, e.g. a local SQS compatible server."`
+	Reconcile   time.Duration `long:"reconcile" description:"Run a full sync at the start and then this often to catch missed events, e.g. 1h. 0 disables it."`
+}
+
+// Execute applies event notifications to the destination until interrupted or the events run out
+func (c *eventsCommand) Execute(args []string) error {
+	var (
+		err     error
+		events  s3sync.EventSource
+		signals chan os.Signal
+		stop    chan struct{}
+		syncer  s3sync.Syncer
+	)
+
+	if (c.Queue == "") == (c.File == "") {
+		return fmt.Errorf("one of --queue and --file is required")
+	}
+
+	syncer, err = newSyncer()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c.Queue != "":
+		events, err = syncer.NewSQSEventSource(c.Queue, c.SQSEndpoint)
+		if err != nil {
+			return err
+		}
+	case c.File == "-":
+		events = s3sync.NewReaderEventSource(os.Stdin)
+	default:
+		f, err := os.Open(c.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		events = s3sync.NewReaderEventSource(f)
+	}
+
+	signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stop = make(chan struct{})
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	return syncer.Events(events, c.Reconcile, stop)
+}
@@ -3,37 +3,98 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gdanko/golang-s3sync/pkg/s3sync"
 	flags "github.com/jessevdk/go-flags"
 )
 
 type Options struct {
-	Source      string   `short:"s" long:"source" description:"The source, either absolute local path or s3://<bucket>/<path>" required:"true"`
-	Destination string   `short:"d" long:"destination" description:"The destination, either absolute local path or s3://<bucket>/<path>" required:"true"`
-	Include     []string `short:"i" long:"include" description:"COMING SOON! Include <pattern>. Can be used more than once."`
-	Exclude     []string `short:"e" long:"exclude" description:"COMING SOON! Exclude <pattern>. Can be used more than once."`
-	MaxThreads  int      `short:"m" long:"max-threads" description:"The maximum number of threads to use while copying." default:"12"`
-	Profile     string   `short:"p" long:"profile" description:"The AWS profile to use." required:"true" default:"default"`
-	Region      string   `short:"r" long:"region" description:"The AWS region to use." required:"true"`
-	Delete      bool     `long:"delete" description:"Delete files on the destination side that do not exist on the source."`
-	Verify      bool     `short:"v" long:"verify" description:"Verify the files after copying."`
-	Debug       bool     `long:"debug" description:"Display debug output."`
-	Dryrun      bool     `short:"n" long:"dryrun" description:"Show what would be done but change nothing."`
-	Aram        bool     `short:"a" long:"aram" description:"Tell me about Aram." hidden:"true"`
+	Source               string        `short:"s" long:"source" description:"The source, either absolute local path or s3://<bucket>/<path>"`
+	Destination          string        `short:"d" long:"destination" description:"The destination, either absolute local path or s3://<bucket>/<path>"`
+	Include              []string      `short:"i" long:"include" description:"COMING SOON! Include <pattern>. Can be used more than once."`
+	Exclude              []string      `short:"e" long:"exclude" description:"COMING SOON! Exclude <pattern>. Can be used more than once."`
+	MaxThreads           int           `short:"m" long:"max-threads" description:"The maximum number of threads to use while copying." default:"12"`
+	Profile              string        `short:"p" long:"profile" description:"The AWS profile to use." required:"true" default:"default"`
+	Region               string        `short:"r" long:"region" description:"The AWS region to use. Required."`
+	Config               string        `long:"config" description:"A YAML file of named sync jobs to use with the run command."`
+	Delete               bool          `long:"delete" description:"Delete files on the destination side that do not exist on the source."`
+	DeleteBefore         bool          `long:"delete-before" description:"Delete before transferring, for destinations that are short on space (implies --delete)."`
+	DeleteDuring         bool          `long:"delete-during" description:"Delete in batches while transferring (implies --delete)."`
+	DeleteAfter          bool          `long:"delete-after" description:"Delete after every transfer has succeeded, the default (implies --delete)."`
+	MaxDelete            int           `long:"max-delete" description:"Abort the delete phase if more than this many files would be deleted. 0 means no limit."`
+	MaxDeletePercent     float64       `long:"max-delete-percent" description:"Abort the delete phase if more than this percentage of the destination would be deleted. 0 means no limit."`
+	Confirm              bool          `long:"confirm" description:"Show a summary of the files to be deleted and ask before deleting them."`
+	DetectRenames        bool          `long:"detect-renames" description:"Move destination files that match a new source file by size and md5 instead of deleting them and transferring the new file (requires --delete)."`
+	Backup               bool          `long:"backup" description:"Move overwritten and deleted destination files into the backup directory instead of destroying them."`
+	BackupDir            string        `long:"backup-dir" description:"The backup directory, an s3:// URL, an absolute path or a path relative to the destination (implies --backup). (default: .trash)"`
+	Suffix               string        `long:"suffix" description:"A suffix appended to the name of each backed up file."`
+	Verify               bool          `short:"v" long:"verify" description:"Verify the files after copying."`
+	Debug                bool          `long:"debug" description:"Display debug output."`
+	Dryrun               bool          `short:"n" long:"dryrun" description:"Show what would be done but change nothing."`
+	Itemize              bool          `long:"itemize-changes" description:"Print an rsync style change summary for each file instead of a message."`
+	Inplace              bool          `long:"inplace" description:"Download directly into the destination file instead of a temporary file."`
+	Dedup                bool          `long:"dedup" description:"Transfer each distinct content once and produce files with the same content from the first one, with a server side copy for s3 or a local copy."`
+	Hardlink             bool          `long:"hardlink" description:"Hard link local duplicates to the first download instead of copying them (requires --dedup)."`
+	Preserve             string        `long:"preserve" description:"Comma separated file attributes to restore on download: mode, times, owner."`
+	HashCache            string        `long:"hash-cache" description:"The file used to cache local md5 checksums between runs. (default: <user cache dir>/s3sync/hashes.db)"`
+	NoHashCache          bool          `long:"no-hash-cache" description:"Hash every local file instead of using the hash cache."`
+	StorageClass         string        `long:"storage-class" description:"The storage class of uploaded and copied objects, e.g. STANDARD_IA, INTELLIGENT_TIERING, GLACIER_IR or DEEP_ARCHIVE."`
+	CompareStorageClass  bool          `long:"compare-storage-class" description:"Move destination objects that are not in --storage-class (default STANDARD) into it."`
+	SSE                  string        `long:"sse" description:"Server side encryption for uploaded and copied objects: AES256 or aws:kms."`
+	SSEKMSKeyID          string        `long:"sse-kms-key-id" description:"The KMS key used with --sse aws:kms instead of the AWS managed key."`
+	SSECustomerKey       string        `long:"sse-c" description:"A base64 encoded 256 bit customer key to encrypt uploaded and copied objects with."`
+	SSECustomerSourceKey string        `long:"sse-c-source" description:"The base64 encoded 256 bit customer key the source objects are encrypted with."`
+	HeaderRules          string        `long:"header-rules" description:"A JSON file of rules setting Cache-Control, Content-Disposition, Content-Encoding, Content-Language and Expires by key pattern."`
+	ContentType          string        `long:"content-type" description:"Force the Content-Type of every uploaded and copied object."`
+	ContentTypeRules     []string      `long:"content-type-rule" description:"Set the Content-Type of keys matching a pattern, as <pattern>=<type>. Can be used more than once."`
+	MimeTypes            string        `long:"mime-types" description:"A mime.types file of extensions to use before the built in table."`
+	Tags                 []string      `long:"tag" description:"Add a key=value tag to uploaded and copied objects. Can be used more than once."`
+	Metadata             []string      `long:"metadata" description:"Add key=value user metadata to uploaded and copied objects. Can be used more than once."`
+	CompareTags          bool          `long:"compare-tags" description:"Update the tags of destination objects whose tags differ, without transferring them again."`
+	CompareMetadata      bool          `long:"compare-metadata" description:"Update the user metadata of destination objects whose metadata differs, without transferring them again."`
+	ACL                  string        `long:"acl" description:"The canned ACL of uploaded and copied objects: private, public-read, public-read-write, authenticated-read, aws-exec-read, bucket-owner-read or bucket-owner-full-control. (default: private)"`
+	GrantRead            string        `long:"grant-read" description:"Grant read access to uploaded and copied objects, e.g. id=<canonical user id> or uri=http://acs.amazonaws.com/groups/global/AllUsers."`
+	GrantFullControl     string        `long:"grant-full-control" description:"Grant full control of uploaded and copied objects, in the same form as --grant-read."`
+	PreserveACL          bool          `long:"preserve-acl" description:"Copy the grants of each source object to the destination object, s3 to s3 only."`
+	RestoreTier          string        `long:"restore-tier" description:"Restore GLACIER and DEEP_ARCHIVE objects before reading them, using the Bulk, Standard or Expedited tier."`
+	RestoreDays          int           `long:"restore-days" description:"How many days restored objects stay readable. (default: 1)"`
+	RestoreWait          time.Duration `long:"restore-wait" description:"How long to wait for restores before leaving them to a later run, e.g. 6h. 0 waits until they finish."`
+	SkipArchived         bool          `long:"skip-archived" description:"Skip GLACIER and DEEP_ARCHIVE objects instead of reading them."`
+	AsOf                 string        `long:"as-of" description:"Sync the version of each source object that was current at this RFC 3339 time, e.g. 2026-09-30T00:00:00Z. s3 sources only."`
+	AllVersions          bool          `long:"all-versions" description:"Replicate every version and delete marker of each source object, oldest first, s3 to s3 only. The destination bucket must be versioned."`
+	VersionManifest      string        `long:"version-manifest" description:"The JSON file mapping source versions to the destination versions created from them, required with --all-versions."`
+	Lock                 bool          `long:"lock" description:"Lock the destination for the length of the sync so concurrent syncs to it fail, with a lease object for s3 or a file lock for local paths."`
+	LockTTL              time.Duration `long:"lock-ttl" description:"How long an s3 lock lasts without a heartbeat before another sync can take it over. (default: 5m)"`
+	LockOwner            string        `long:"lock-owner" description:"Who holds the lock, e.g. a CI job URL. (default: <user>@<host> pid <pid>)"`
+	Aram                 bool          `short:"a" long:"aram" description:"Tell me about Aram." hidden:"true"`
 }
 
+var opts Options
+
 func main() {
 	var (
 		err      error
 		flagsErr *flags.Error
 		ok       bool
-		opts     Options
 		syncer   s3sync.Syncer
 	)
 
 	// Parse the options
 	parser1 := flags.NewParser(&opts, flags.Default)
+	parser1.SubcommandsOptional = true
+	parser1.AddCommand("plan", "Write a sync plan to review", "Diff the source and destination and write everything a sync would do as JSON.", &planCommand{})
+	parser1.AddCommand("prune-backups", "Remove old backups", "Remove backup runs under the backup directory that are older than --keep-days.", &pruneBackupsCommand{})
+	parser1.AddCommand("apply", "Apply a saved sync plan", "Execute a plan written by the plan command, refusing to run if either side changed since it was made.", &applyCommand{})
+	parser1.AddCommand("bisync", "Sync two paths in both directions", "Copy the creates, updates and deletes made on either side since the last bisync to the other side, resolving files changed on both sides with --conflict.", &bisyncCommand{})
+	parser1.AddCommand("daemon", "Run jobs on a schedule", "Run the jobs in the --config file on their cron schedules, without overlapping runs of a job, keeping their history in --state-dir and serving their status over HTTP.", &daemonCommand{parser: parser1})
+	parser1.AddCommand("events", "Sync s3 changes from event notifications", "Apply the keys named in S3 event notifications from an SQS queue or a file to a local destination, with an optional periodic full sync.", &eventsCommand{})
+	parser1.AddCommand("run", "Run jobs from a config file", "Run the named jobs, or every job with --all, from the --config file and print a summary. Options given on the command line apply to every job.", &runCommand{parser: parser1})
+	parser1.AddCommand("unlock", "Remove a stale lock", "Remove the lock left on a destination by a --lock sync that did not release it.", &unlockCommand{})
+	parser1.AddCommand("watch", "Keep syncing as files change", "Sync a local source to s3, then watch it and sync each batch of changed files as it happens.", &watchCommand{})
+
 	if _, err = parser1.Parse(); err != nil {
 		if flagsErr, ok = err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
@@ -42,31 +103,186 @@ func main() {
 		}
 	}
 
-	if opts.Aram {
-		fmt.Println("That Aram is a real bully.")
+	// Subcommands have already been run by the parser
+	if parser1.Active != nil {
+		return
+	}
+
+	syncer, err = newSyncer()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	if opts.MaxThreads < 1 {
-		fmt.Println("--max-threads cannot be less than 1.")
+	_, err = syncer.Sync()
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
+}
+
+// newSyncer builds a Syncer from the global options
+func newSyncer() (s3sync.Syncer, error) {
+	return newSyncerFrom(&opts)
+}
+
+// newSyncerFrom builds a Syncer from a set of options, such as a job from the config
+func newSyncerFrom(o *Options) (s3sync.Syncer, error) {
+	if o.Aram {
+		fmt.Println("That Aram is a real bully.")
+	}
+
+	if o.Region == "" {
+		return s3sync.Syncer{}, fmt.Errorf("the required flag `-r, --region' was not specified")
+	}
+
+	if o.MaxThreads < 1 {
+		return s3sync.Syncer{}, fmt.Errorf("--max-threads cannot be less than 1")
+	}
+
+	if o.HashCache == "" && o.NoHashCache == false {
+		if cacheDir, err := os.UserCacheDir(); err == nil {
+			o.HashCache = filepath.Join(cacheDir, "s3sync", "hashes.db")
+		}
+	}
+	if o.NoHashCache == true {
+		o.HashCache = ""
+	}
 
-	// Validate region
+	deleteMode := ""
+	for mode, set := range map[string]bool{
+		s3sync.DeleteAfter:  o.DeleteAfter,
+		s3sync.DeleteBefore: o.DeleteBefore,
+		s3sync.DeleteDuring: o.DeleteDuring,
+	} {
+		if set == false {
+			continue
+		}
+		if deleteMode != "" {
+			return s3sync.Syncer{}, fmt.Errorf("only one of --delete-before, --delete-during and --delete-after can be used")
+		}
+		deleteMode = mode
+		o.Delete = true
+	}
+
+	var headerRules []s3sync.HeaderRule
+	if o.HeaderRules != "" {
+		f, err := os.Open(o.HeaderRules)
+		if err != nil {
+			return s3sync.Syncer{}, err
+		}
+		defer f.Close()
+		headerRules, err = s3sync.ReadHeaderRules(f)
+		if err != nil {
+			return s3sync.Syncer{}, err
+		}
+	}
 
-	syncer = s3sync.Syncer{
-		Source:      opts.Source,
-		Destination: opts.Destination,
-		MaxThreads:  opts.MaxThreads,
-		Profile:     opts.Profile,
-		Region:      opts.Region,
-		Delete:      opts.Delete,
-		Verify:      opts.Verify,
-		Debug:       opts.Debug,
-		Dryrun:      opts.Dryrun,
+	var contentTypeRules []s3sync.ContentTypeRule
+	for _, rule := range o.ContentTypeRules {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return s3sync.Syncer{}, fmt.Errorf("invalid --content-type-rule %q, expected <pattern>=<type>", rule)
+		}
+		contentTypeRules = append(contentTypeRules, s3sync.ContentTypeRule{Pattern: parts[0], ContentType: parts[1]})
 	}
 
-	err = syncer.Sync()
+	tags, err := splitPairs("--tag", o.Tags)
 	if err != nil {
-		fmt.Println(err)
+		return s3sync.Syncer{}, err
+	}
+	metadata, err := splitPairs("--metadata", o.Metadata)
+	if err != nil {
+		return s3sync.Syncer{}, err
+	}
+
+	var asOf time.Time
+	if o.AsOf != "" {
+		asOf, err = time.Parse(time.RFC3339, o.AsOf)
+		if err != nil {
+			return s3sync.Syncer{}, fmt.Errorf("invalid --as-of %q, expected an RFC 3339 time such as 2026-09-30T00:00:00Z", o.AsOf)
+		}
+	}
+
+	return s3sync.Syncer{
+		Source:               o.Source,
+		Destination:          o.Destination,
+		HashCache:            o.HashCache,
+		MaxThreads:           o.MaxThreads,
+		Profile:              o.Profile,
+		Region:               o.Region,
+		MaxDelete:            o.MaxDelete,
+		MaxDeletePercent:     o.MaxDeletePercent,
+		Confirm:              o.Confirm,
+		Backup:               o.Backup,
+		BackupDir:            o.BackupDir,
+		Suffix:               o.Suffix,
+		DeleteMode:           deleteMode,
+		Delete:               o.Delete,
+		DetectRenames:        o.DetectRenames,
+		Dedup:                o.Dedup,
+		Hardlink:             o.Hardlink,
+		Verify:               o.Verify,
+		Debug:                o.Debug,
+		Dryrun:               o.Dryrun,
+		Inplace:              o.Inplace,
+		ItemizeChanges:       o.Itemize,
+		Preserve:             splitList(o.Preserve),
+		StorageClass:         o.StorageClass,
+		CompareStorageClass:  o.CompareStorageClass,
+		SSE:                  o.SSE,
+		SSEKMSKeyID:          o.SSEKMSKeyID,
+		SSECustomerKey:       o.SSECustomerKey,
+		SSECustomerSourceKey: o.SSECustomerSourceKey,
+		HeaderRules:          headerRules,
+		ContentType:          o.ContentType,
+		ContentTypeRules:     contentTypeRules,
+		MimeTypes:            o.MimeTypes,
+		Tags:                 tags,
+		Metadata:             metadata,
+		CompareTags:          o.CompareTags,
+		CompareMetadata:      o.CompareMetadata,
+		ACL:                  o.ACL,
+		GrantRead:            o.GrantRead,
+		GrantFullControl:     o.GrantFullControl,
+		PreserveACL:          o.PreserveACL,
+		RestoreTier:          o.RestoreTier,
+		RestoreDays:          o.RestoreDays,
+		RestoreWait:          o.RestoreWait,
+		SkipArchived:         o.SkipArchived,
+		AsOf:                 asOf,
+		AllVersions:          o.AllVersions,
+		VersionManifest:      o.VersionManifest,
+		Lock:                 o.Lock,
+		LockTTL:              o.LockTTL,
+		LockOwner:            o.LockOwner,
+	}, nil
+}
+
+// splitList splits a comma separated option value, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// splitPairs parses repeated key=value option values
+func splitPairs(option string, values []string) (map[string]string, error) {
+	var pairs map[string]string
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid %s %q, expected <key>=<value>", option, value)
+		}
+		if pairs == nil {
+			pairs = make(map[string]string)
+		}
+		pairs[parts[0]] = parts[1]
 	}
+	return pairs, nil
 }
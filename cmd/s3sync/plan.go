@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3sync"
+)
+
+type planCommand struct {
+	Output string `short:"o" long:"output" description:"The file to write the plan to." required:"true"`
+}
+
+// Execute diffs the source and destination and saves the plan
+func (c *planCommand) Execute(args []string) error {
+	var (
+		err    error
+		plan   *s3sync.Plan
+		syncer s3sync.Syncer
+	)
+
+	syncer, err = newSyncer()
+	if err != nil {
+		return err
+	}
+
+	plan, err = syncer.Plan()
+	if err != nil {
+		return err
+	}
+
+	return plan.Save(c.Output)
+}
+
+type applyCommand struct {
+	Args struct {
+		Plan string `positional-arg-name:"plan.json" description:"The plan written by the plan command."`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute runs a saved plan
+func (c *applyCommand) Execute(args []string) error {
+	var (
+		err    error
+		f      *os.File
+		plan   *s3sync.Plan
+		syncer s3sync.Syncer
+	)
+
+	f, err = os.Open(c.Args.Plan)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	plan, err = s3sync.ReadPlan(f)
+	if err != nil {
+		return err
+	}
+
+	syncer, err = newSyncer()
+	if err != nil {
+		return err
+	}
+
+	_, err = syncer.Apply(plan)
+	return err
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3sync"
+)
+
+type pruneBackupsCommand struct {
+	KeepDays int `long:"keep-days" description:"Keep backup runs newer than this many days." default:"30"`
+}
+
+// Execute removes the backup runs older than the retention period
+func (c *pruneBackupsCommand) Execute(args []string) error {
+	var (
+		err    error
+		syncer s3sync.Syncer
+	)
+
+	if c.KeepDays < 0 {
+		return fmt.Errorf("--keep-days cannot be negative")
+	}
+
+	syncer, err = newSyncer()
+	if err != nil {
+		return err
+	}
+
+	return syncer.PruneBackups(time.Duration(c.KeepDays) * 24 * time.Hour)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3sync"
+	flags "github.com/jessevdk/go-flags"
+)
+
+type runCommand struct {
+	All  bool `long:"all" description:"Run every job in the config."`
+	Args struct {
+		Jobs []string `positional-arg-name:"job" description:"The name of a job in the config. Can be given more than once."`
+	} `positional-args:"yes"`
+
+	parser *flags.Parser
+}
+
+// jobResult is the outcome of one job for the summary
+type jobResult struct {
+	duration time.Duration
+	err      error
+	name     string
+	result   s3sync.SyncResult
+}
+
+// Execute runs the named jobs from --config one after another and prints a summary. Options
+// given on the command line apply to every job, over what the config sets.
+func (c *runCommand) Execute(args []string) error {
+	var (
+		config    *jobsConfig
+		err       error
+		failed    int
+		names     []string
+		overrides []string
+		results   []jobResult
+	)
+
+	if opts.Config == "" {
+		return fmt.Errorf("the run command needs a --config file")
+	}
+
+	config, err = readJobsConfig(opts.Config)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c.All == true && len(c.Args.Jobs) > 0:
+		return fmt.Errorf("give either --all or job names, not both")
+	case c.All == true:
+		names = config.names()
+	case len(c.Args.Jobs) > 0:
+		names = c.Args.Jobs
+	default:
+		return fmt.Errorf("give the jobs to run or --all")
+	}
+
+	// Check every job before running any of them
+	for _, name := range names {
+		if _, ok := config.Jobs[name]; ok == false {
+			return fmt.Errorf("there is no job %q in %s", name, opts.Config)
+		}
+	}
+
+	overrides = commandLineArgs(c.parser)
+
+	for _, name := range names {
+		fmt.Printf("running job %s\n", name)
+		started := time.Now()
+		result, err := runJob(config, name, overrides)
+		if err != nil {
+			fmt.Printf("job %s failed: %s\n", name, err)
+			failed++
+		}
+		results = append(results, jobResult{duration: time.Since(started), err: err, name: name, result: result})
+	}
+
+	fmt.Println("summary:")
+	for _, result := range results {
+		counts := fmt.Sprintf("%d transferred, %d deleted, %d skipped", result.result.Transferred, result.result.Deleted, result.result.Skipped)
+		if result.err != nil {
+			fmt.Printf("  %-20s failed  %s  %s  %s\n", result.name, result.duration.Round(time.Second), counts, result.err)
+		} else {
+			fmt.Printf("  %-20s ok      %s  %s\n", result.name, result.duration.Round(time.Second), counts)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d job(s) failed", failed, len(results))
+	}
+
+	return nil
+}
+
+// runJob syncs a single job and returns what the sync did
+func runJob(config *jobsConfig, name string, overrides []string) (s3sync.SyncResult, error) {
+	jobOpts, err := config.options(name, overrides)
+	if err != nil {
+		return s3sync.SyncResult{}, err
+	}
+
+	syncer, err := newSyncerFrom(&jobOpts)
+	if err != nil {
+		return s3sync.SyncResult{}, err
+	}
+
+	return syncer.Sync()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3sync"
+)
+
+type unlockCommand struct {
+	Args struct {
+		Path string `positional-arg-name:"path" description:"The locked destination, either absolute local path or s3://<bucket>/<path>. Defaults to --destination."`
+	} `positional-args:"yes"`
+}
+
+// Execute removes the lock on a destination
+func (c *unlockCommand) Execute(args []string) error {
+	var (
+		err    error
+		held   *s3sync.LockInfo
+		syncer s3sync.Syncer
+	)
+
+	if c.Args.Path != "" {
+		opts.Destination = c.Args.Path
+	}
+
+	syncer, err = newSyncer()
+	if err != nil {
+		return err
+	}
+
+	held, err = syncer.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("removed the lock held by %s since %s\n", held.Owner, held.Acquired.Format(time.RFC3339))
+	return nil
+}
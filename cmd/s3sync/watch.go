@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3sync"
+)
+
+type watchCommand struct {
+	Debounce time.Duration `long:"debounce" description:"How long to wait for changes to stop before syncing them." default:"2s"`
+}
+
+// Execute syncs the source and keeps syncing it as files change until interrupted
+func (c *watchCommand) Execute(args []string) error {
+	var (
+		err     error
+		signals chan os.Signal
+		stop    chan struct{}
+		syncer  s3sync.Syncer
+	)
+
+	syncer, err = newSyncer()
+	if err != nil {
+		return err
+	}
+
+	signals = make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stop = make(chan struct{})
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	return syncer.Watch(c.Debounce, stop)
+}
@@ -4,9 +4,13 @@ go 1.14
 
 require (
 	github.com/aws/aws-sdk-go v1.33.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gabriel-vasile/mimetype v1.1.1
-	github.com/jessevdk/go-flags v1.4.0 // indirect
+	github.com/jessevdk/go-flags v1.4.0
 	github.com/kr/pretty v0.2.0
 	github.com/kylelemons/godebug v1.1.0
-	github.com/thoas/go-funk v0.7.0
+	github.com/robfig/cron/v3 v3.0.1
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/sys v0.7.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,35 @@
+package s3diff
+
+// maxCopyObjectSize is the largest object CopyObject can copy in a single request
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// markDuplicate records the first upload or download of each content and points every later one
+// with the same size and md5 at its destination through DuplicateOf. Items that only update an
+// object's metadata or storage class transfer nothing and are left alone.
+func (d *Differ) markDuplicate(item *SyncItem, obj FileInfo) {
+	if item.Action != ActionUpload && item.Action != ActionDownload {
+		return
+	}
+	if item.Reason == ReasonMetadataChanged || item.Reason == ReasonStorageClassChanged {
+		return
+	}
+
+	// Uploaded duplicates are copied from the original object
+	if item.Action == ActionUpload && obj.Size > maxCopyObjectSize {
+		return
+	}
+
+	key := d.contentKey(obj)
+	if key == "" {
+		return
+	}
+
+	if d.originals == nil {
+		d.originals = make(map[string]string)
+	}
+	if original, ok := d.originals[key]; ok {
+		item.DuplicateOf = original
+		return
+	}
+	d.originals[key] = item.Destination
+}
@@ -0,0 +1,130 @@
+package s3diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+// dedupTransfer is an item offered to markDuplicate along with the file it transfers
+type dedupTransfer struct {
+	action Action
+	reason Reason
+	name   string
+	size   int64
+	md5    string
+}
+
+func TestMarkDuplicate(t *testing.T) {
+	tests := []struct {
+		name      string
+		differ    Differ
+		transfers []dedupTransfer
+		// duplicateOf holds the DuplicateOf of each transfer
+		duplicateOf []string
+	}{
+		{
+			name: "duplicate uploads",
+			transfers: []dedupTransfer{
+				{action: ActionUpload, reason: ReasonNew, name: "a", size: 3, md5: "abc"},
+				{action: ActionUpload, reason: ReasonNew, name: "b", size: 3, md5: "abc"},
+				{action: ActionUpload, reason: ReasonContentChanged, name: "c", size: 3, md5: "abc"},
+			},
+			duplicateOf: []string{"", "dest/a", "dest/a"},
+		},
+		{
+			name: "duplicate downloads",
+			transfers: []dedupTransfer{
+				{action: ActionDownload, reason: ReasonNew, name: "a", size: 3, md5: "abc"},
+				{action: ActionDownload, reason: ReasonNew, name: "b", size: 3, md5: "abc"},
+			},
+			duplicateOf: []string{"", "dest/a"},
+		},
+		{
+			name: "different content",
+			transfers: []dedupTransfer{
+				{action: ActionUpload, reason: ReasonNew, name: "a", size: 3, md5: "abc"},
+				{action: ActionUpload, reason: ReasonNew, name: "b", size: 3, md5: "def"},
+				{action: ActionUpload, reason: ReasonNew, name: "c", size: 4, md5: "abc"},
+			},
+			duplicateOf: []string{"", "", ""},
+		},
+		{
+			name: "empty files",
+			transfers: []dedupTransfer{
+				{action: ActionUpload, reason: ReasonNew, name: "a", md5: "d41d8cd98f00b204e9800998ecf8427e"},
+				{action: ActionUpload, reason: ReasonNew, name: "b", md5: "d41d8cd98f00b204e9800998ecf8427e"},
+			},
+			duplicateOf: []string{"", ""},
+		},
+		{
+			name: "multipart etags",
+			transfers: []dedupTransfer{
+				{action: ActionDownload, reason: ReasonNew, name: "a", size: 3, md5: "abc-2"},
+				{action: ActionDownload, reason: ReasonNew, name: "b", size: 3, md5: "abc-2"},
+			},
+			duplicateOf: []string{"", ""},
+		},
+		{
+			name:   "opaque etags",
+			differ: Differ{OpaqueETags: true},
+			transfers: []dedupTransfer{
+				{action: ActionDownload, reason: ReasonNew, name: "a", size: 3, md5: "abc"},
+				{action: ActionDownload, reason: ReasonNew, name: "b", size: 3, md5: "abc"},
+			},
+			duplicateOf: []string{"", ""},
+		},
+		{
+			name: "metadata and storage class updates transfer nothing",
+			transfers: []dedupTransfer{
+				{action: ActionUpload, reason: ReasonMetadataChanged, name: "a", size: 3, md5: "abc"},
+				{action: ActionUpload, reason: ReasonStorageClassChanged, name: "b", size: 3, md5: "abc"},
+				{action: ActionUpload, reason: ReasonNew, name: "c", size: 3, md5: "abc"},
+				{action: ActionUpload, reason: ReasonMetadataChanged, name: "d", size: 3, md5: "abc"},
+			},
+			duplicateOf: []string{"", "", "", ""},
+		},
+		{
+			name: "copies, moves and deletes",
+			transfers: []dedupTransfer{
+				{action: ActionCopy, reason: ReasonNew, name: "a", size: 3, md5: "abc"},
+				{action: ActionMove, reason: ReasonRenamed, name: "b", size: 3, md5: "abc"},
+				{action: ActionDelete, reason: ReasonExtraneous, name: "c", size: 3, md5: "abc"},
+				{action: ActionUpload, reason: ReasonNew, name: "d", size: 3, md5: "abc"},
+			},
+			duplicateOf: []string{"", "", "", ""},
+		},
+		{
+			name: "uploads too large for CopyObject",
+			transfers: []dedupTransfer{
+				{action: ActionUpload, reason: ReasonNew, name: "a", size: maxCopyObjectSize + 1, md5: "abc"},
+				{action: ActionUpload, reason: ReasonNew, name: "b", size: maxCopyObjectSize + 1, md5: "abc"},
+				{action: ActionUpload, reason: ReasonNew, name: "c", size: maxCopyObjectSize, md5: "def"},
+				{action: ActionUpload, reason: ReasonNew, name: "d", size: maxCopyObjectSize, md5: "def"},
+			},
+			duplicateOf: []string{"", "", "", "dest/c"},
+		},
+		{
+			name: "large downloads",
+			transfers: []dedupTransfer{
+				{action: ActionDownload, reason: ReasonNew, name: "a", size: maxCopyObjectSize + 1, md5: "abc"},
+				{action: ActionDownload, reason: ReasonNew, name: "b", size: maxCopyObjectSize + 1, md5: "abc"},
+			},
+			duplicateOf: []string{"", "dest/a"},
+		},
+	}
+
+	for _, test := range tests {
+		var duplicateOf []string
+
+		d := test.differ
+		for _, transfer := range test.transfers {
+			item := SyncItem{Action: transfer.action, Reason: transfer.reason, Destination: "dest/" + transfer.name}
+			d.markDuplicate(&item, FileInfo{Name: transfer.name, Size: transfer.size, MD5: transfer.md5})
+			duplicateOf = append(duplicateOf, item.DuplicateOf)
+		}
+
+		if reflect.DeepEqual(duplicateOf, test.duplicateOf) == false {
+			t.Errorf("%s: DuplicateOf = %q, want %q", test.name, duplicateOf, test.duplicateOf)
+		}
+	}
+}
@@ -4,66 +4,112 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/thoas/go-funk"
 )
 
 // FileInfo represents the information about a given file in file lists
 type FileInfo struct {
-	Directory bool
-	Dirname   string
-	Filename  string
-	Key       string
-	MD5       string
-	Path      string
-	Size      int64
+	Directory    bool
+	Dirname      string
+	Filename     string
+	Key          string
+	MD5          string
+	ModTime      time.Time
+	Name         string
+	Path         string
+	Size         int64
+	StorageClass string
+	VersionID    string
+
+	// info is the local stat result, used to key the hash cache
+	info os.FileInfo
 }
 
 // SyncItem represents info about an item needing to be synced
 type SyncItem struct {
-	Action      string
-	Source      string
-	Bucket      string
-	Destination string
-	Key         string
-	MD5         string
-	Message     string
-	Path        string
-	Size        int64
+	Action           Action `json:"action"`
+	Source           string `json:"source,omitempty"`
+	Bucket           string `json:"bucket,omitempty"`
+	Destination      string `json:"destination"`
+	Key              string `json:"key,omitempty"`
+	MD5              string `json:"md5,omitempty"`
+	Message          string `json:"message"`
+	Path             string `json:"path,omitempty"`
+	Reason           Reason `json:"reason"`
+	Size             int64  `json:"size"`
+	StorageClass     string `json:"storage_class,omitempty"`
+	VersionID        string `json:"version_id,omitempty"`
+	DuplicateOf      string `json:"duplicate_of,omitempty"`
+	SourceState      *State `json:"source_state,omitempty"`
+	DestinationState *State `json:"destination_state,omitempty"`
+}
+
+// State records what a file looked like when a SyncItem was created so a saved plan can detect
+// that either side changed before it was applied. Objects are compared by ETag, local files by
+// size and mtime.
+type State struct {
+	Exists  bool      `json:"exists"`
+	ETag    string    `json:"etag,omitempty"`
+	ModTime time.Time `json:"mtime,omitempty"`
+	Size    int64     `json:"size"`
 }
 
 // Differ holds all the diff information
 type Differ struct {
-	Common                 map[string]FileInfo
-	Debug                  bool
+	// AsOf, when set on an s3 source, lists the version of each key that was current at that time
+	AsOf   time.Time
+	Common map[string]FileInfo
+	Debug  bool
+	// Dedup marks each transfer whose content matches an earlier transfer in the same run with
+	// the destination of that transfer, so it can be produced from it instead of sent again
+	Dedup                  bool
 	Delete                 bool
 	Destination            string
 	DestinationBucket      string
+	DestinationCount       int
+	DestinationIgnore      []string
 	DestinationList        map[string]FileInfo
 	DestinationMD5Mismatch map[string]FileInfo
 	DestinationOnly        map[string]FileInfo
 	DestinationPath        string
 	DestinationRoot        string
 	DestinationType        string
-	S3                     *s3.S3
-	Source                 string
-	SourceBucket           string
-	SourceList             map[string]FileInfo
-	SourceMD5Mismatch      map[string]FileInfo
-	SourceOnly             map[string]FileInfo
-	SourcePath             string
-	SourceType             string
-	SyncList               map[string]SyncItem
+	// DetectRenames, together with Delete, moves destination files that match a new source file
+	// by size and md5 instead of deleting them and transferring the new file
+	DetectRenames bool
+	HashCache     string
+	HashThreads   int
+	// MetadataChanged, when set, is asked whether a destination file whose content is in sync
+	// still needs its metadata updated
+	MetadataChanged   func(source *FileInfo, destination *FileInfo) bool
+	OpaqueETags       bool
+	S3                *s3.S3
+	Source            string
+	SourceBucket      string
+	SourceCount       int
+	SourceIgnore      []string
+	SourceList        map[string]FileInfo
+	SourceMD5Mismatch map[string]FileInfo
+	SourceOnly        map[string]FileInfo
+	// SourceMtime, when set, returns the modification time an s3 source object recorded when it
+	// was uploaded, to compare with in place of its LastModified. A download that preserves times
+	// sets the local mtime from it, which is older than the object.
+	SourceMtime  func(source *FileInfo) (time.Time, bool)
+	SourcePath   string
+	SourceType   string
+	StorageClass string
+	SyncList     map[string]SyncItem
+
+	// originals maps the contentKey of each transfer to its destination while Dedup is set
+	originals map[string]string
 }
 
-var (
-	err    error
-	md5sum string
-)
-
 // DetermineTypes determines whether the specified path is local or in s3 and configures parts of the Differ
 func (d *Differ) DetermineTypes() error {
 	var (
@@ -111,12 +157,9 @@ func (d *Differ) DetermineTypes() error {
 	return nil
 }
 
-// Diff looks at the files on both sides and populates several file lists, determining what to sync
-func (d *Differ) Diff() {
-	var (
-		name string
-		obj  FileInfo
-	)
+// Diff looks at the files on both sides and populates several file lists, determining what to sync.
+// It holds every key in memory, so large trees should be synced through Stream instead.
+func (d *Differ) Diff() error {
 	// Put this in a "New" func which returns s3diff.Differ?
 	d.Common = make(map[string]FileInfo)
 	d.DestinationList = make(map[string]FileInfo)
@@ -126,184 +169,364 @@ func (d *Differ) Diff() {
 	d.SourceMD5Mismatch = make(map[string]FileInfo)
 	d.SourceOnly = make(map[string]FileInfo)
 	d.SyncList = make(map[string]SyncItem)
-	d.buildFileLists()
 
-	for name, obj = range d.SourceList {
-		if funk.Contains(d.DestinationList, name) {
-			if d.DestinationList[name].MD5 == d.SourceList[name].MD5 {
-				d.Common[name] = obj
-			} else {
-				d.SourceMD5Mismatch[name] = obj
-			}
-		} else {
-			d.SourceOnly[name] = obj
+	return d.merge(func(name string, source *FileInfo, destination *FileInfo) error {
+		if source != nil {
+			d.SourceList[name] = *source
+		}
+		if destination != nil {
+			d.DestinationList[name] = *destination
+		}
+
+		switch {
+		case destination == nil:
+			d.SourceOnly[name] = *source
+		case source == nil:
+			d.DestinationOnly[name] = *destination
+		case d.compare(source, destination) == "":
+			d.Common[name] = *source
+		default:
+			d.SourceMD5Mismatch[name] = *source
+			d.DestinationMD5Mismatch[name] = *destination
 		}
-	}
 
-	for name, obj = range d.DestinationList {
-		if funk.Contains(d.SourceList, name) {
-			if d.SourceList[name].MD5 == d.DestinationList[name].MD5 {
-				d.Common[name] = obj
+		return nil
+	})
+}
+
+// Stream diffs both sides without holding either listing in memory and sends a SyncItem to items
+// for every key as soon as it is found, so transfers can start before the listing is finished.
+// items is closed when the diff is done. An error means the listing is incomplete. With
+// DetectRenames, new and extraneous files are held back until the listing is finished so they can
+// be matched against each other.
+func (d *Differ) Stream(items chan<- SyncItem) error {
+	defer close(items)
+
+	return d.stream(items, d.merge)
+}
+
+// StreamPaths works like Stream but only diffs the given names, relative to the source and the
+// destination, for callers that already know what changed. A name can be a single file or a
+// directory, which is diffed recursively. A name that no longer exists on the source is deleted
+// from the destination along with everything under it when Delete is set. SourceCount and
+// DestinationCount only count the files under names.
+func (d *Differ) StreamPaths(names []string, items chan<- SyncItem) error {
+	defer close(items)
+
+	return d.stream(items, func(fn func(name string, source *FileInfo, destination *FileInfo) error) error {
+		return d.mergePaths(names, fn)
+	})
+}
+
+// stream sends a SyncItem to items for every difference merge finds
+func (d *Differ) stream(items chan<- SyncItem, merge func(fn func(name string, source *FileInfo, destination *FileInfo) error) error) error {
+	var (
+		destinationOnly []FileInfo
+		err             error
+		renames         bool
+		sourceOnly      []FileInfo
+	)
+
+	renames = d.DetectRenames == true && d.Delete == true
+
+	err = merge(func(name string, source *FileInfo, destination *FileInfo) error {
+		switch {
+		case source != nil && destination == nil:
+			if renames && d.contentKey(*source) != "" {
+				sourceOnly = append(sourceOnly, *source)
+			} else {
+				items <- d.TransferItem(*source, nil, ReasonNew)
+			}
+		case source == nil && d.Delete == true:
+			if renames && d.contentKey(*destination) != "" {
+				destinationOnly = append(destinationOnly, *destination)
 			} else {
-				d.DestinationMD5Mismatch[name] = obj
+				items <- d.DeleteItem(*destination)
+			}
+		case source != nil && destination != nil:
+			if reason := d.compare(source, destination); reason != "" {
+				items <- d.TransferItem(*source, destination, reason)
 			}
-		} else {
-			d.DestinationOnly[name] = obj
 		}
+		return nil
+	})
+	if err != nil || renames == false {
+		return err
+	}
+
+	moves, sourceOnly, destinationOnly := d.matchRenames(sourceOnly, destinationOnly)
+	for _, item := range moves {
+		items <- item
+	}
+	for _, obj := range sourceOnly {
+		items <- d.TransferItem(obj, nil, ReasonNew)
+	}
+	for _, obj := range destinationOnly {
+		items <- d.DeleteItem(obj)
 	}
+
+	return nil
 }
 
-func (d *Differ) buildFileLists() {
+// merge walks both listings in key order, calling fn once per key with the file from each side.
+// Either side is nil when the key only exists on the other. SourceCount and DestinationCount are
+// updated as it goes.
+func (d *Differ) merge(fn func(name string, source *FileInfo, destination *FileInfo) error) error {
+	var sourceList fileLister
+
 	fmt.Println("building file list...")
-	switch d.SourceType {
-	case "local":
-		d.getLocalFiles(d.SourcePath, &d.SourceList)
-	case "s3":
-		d.getS3Files(d.SourcePath, d.SourceBucket, &d.SourceList)
-	}
 
-	switch d.DestinationType {
-	case "local":
-		d.getLocalFiles(d.DestinationPath, &d.DestinationList)
-	case "s3":
-		d.getS3Files(d.DestinationPath, d.DestinationBucket, &d.DestinationList)
+	d.SourceCount = 0
+	d.DestinationCount = 0
+	d.originals = make(map[string]string)
+
+	if d.SourceType == "s3" && d.AsOf.IsZero() == false {
+		sourceList = ignore(newS3VersionLister(d.S3, d.SourceBucket, d.SourcePath, d.AsOf), d.SourceIgnore)
+	} else {
+		sourceList = d.newSourceLister("")
 	}
-	fmt.Println("done")
+
+	return d.walk(sourceList, d.newDestinationLister(""), fn)
 }
 
-func (d *Differ) getLocalFiles(path string, fileList *map[string]FileInfo) {
-	err = filepath.Walk(path, func(item string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// mergePaths is merge restricted to the given names and everything under them. Names that are
+// under another name in the list are skipped since they are walked along with it.
+func (d *Differ) mergePaths(names []string, fn func(name string, source *FileInfo, destination *FileInfo) error) error {
+	var (
+		err      error
+		previous string
+	)
+
+	d.SourceCount = 0
+	d.DestinationCount = 0
+	d.originals = make(map[string]string)
+
+	names = append([]string{}, names...)
+	sort.Strings(names)
+
+	for _, name := range names {
+		name = strings.Trim(name, "/")
+		if name == "" || (previous != "" && (name == previous || strings.HasPrefix(name, previous+"/"))) {
+			continue
 		}
+		previous = name
 
-		info, err = validatePath(item)
-		if err == nil {
-			if info.IsDir() == false {
-				md5sum, err = md5checksum(item)
-				if err != nil {
-					panic(err) // Handle this error soon
-				}
-
-				p1 := item
-				p2 := filepath.Dir(path)
-				key, _ := filepath.Rel(p2, p1)
-				strippedKey := strings.Join(strings.Split(key, string(os.PathSeparator))[1:], string(os.PathSeparator))
-
-				(*fileList)[strippedKey] = FileInfo{
-					Key:       strippedKey,
-					Directory: info.IsDir(),
-					Path:      item,
-					Dirname:   filepath.Dir(path),
-					Filename:  filepath.Base(path),
-					Size:      info.Size(),
-					MD5:       md5sum,
-				}
-			}
+		err = d.walk(d.newSourceLister(name), d.newDestinationLister(name), fn)
+		if err != nil {
+			return err
 		}
+	}
 
-		return nil
-	})
+	return nil
 }
 
-func (d *Differ) getS3Files(path string, bucket string, fileList *map[string]FileInfo) {
-	prefix := fmt.Sprintf("%s/", path)
-	resp, err := d.S3.ListObjectsV2(&s3.ListObjectsV2Input{
-		Bucket: &bucket,
-		Prefix: &prefix,
-	})
+// walk merges an ordered listing of each side, calling fn once per name and closing both listers
+func (d *Differ) walk(sourceList fileLister, destinationList fileLister, fn func(name string, source *FileInfo, destination *FileInfo) error) error {
+	var (
+		destination   FileInfo
+		destinationOk bool
+		err           error
+		source        FileInfo
+		sourceOk      bool
+	)
+
+	defer sourceList.close()
+	defer destinationList.close()
 
+	source, sourceOk, err = sourceList.next()
 	if err != nil {
-		panic(err) // Handle this error soon
+		return err
+	}
+	destination, destinationOk, err = destinationList.next()
+	if err != nil {
+		return err
 	}
 
-	for _, fileObj := range resp.Contents {
-		key := *fileObj.Key
-		if !strings.HasSuffix(key, string(os.PathSeparator)) && int64(*fileObj.Size) != 0 {
-			key := filepath.Join(filepath.Dir(key), filepath.Base(key))
-			strippedKey := strings.Join(strings.Split(key, string(os.PathSeparator))[1:], string(os.PathSeparator))
-
-			(*fileList)[strippedKey] = FileInfo{
-				Key:      key,
-				Dirname:  filepath.Dir(key),
-				Filename: filepath.Base(key),
-				Size:     int64(*fileObj.Size),
-				MD5:      strings.ReplaceAll(*fileObj.ETag, "\"", ""),
+	for sourceOk || destinationOk {
+		switch {
+		case destinationOk == false || (sourceOk && source.Name < destination.Name):
+			d.SourceCount++
+			err = fn(source.Name, &source, nil)
+			if err == nil {
+				source, sourceOk, err = sourceList.next()
+			}
+		case sourceOk == false || destination.Name < source.Name:
+			d.DestinationCount++
+			err = fn(destination.Name, nil, &destination)
+			if err == nil {
+				destination, destinationOk, err = destinationList.next()
+			}
+		default:
+			d.SourceCount++
+			d.DestinationCount++
+			err = fn(source.Name, &source, &destination)
+			if err == nil {
+				source, sourceOk, err = sourceList.next()
+			}
+			if err == nil {
+				destination, destinationOk, err = destinationList.next()
 			}
 		}
+		if err != nil {
+			return err
+		}
 	}
+
+	return nil
+}
+
+// newLister returns an ordered listing of one side of the diff, or of a single name on it and
+// everything under it when name is set
+func (d *Differ) newLister(pathType string, path string, bucket string, name string) fileLister {
+	if pathType == "s3" {
+		return newS3PathLister(d.S3, bucket, path, name)
+	}
+	// There is nothing to compare a local md5 against when the ETags are opaque
+	if d.OpaqueETags == true {
+		return newLocalPathLister(path, name)
+	}
+	return d.newHashedLister(newLocalPathLister(path, name))
+}
+
+// newSourceLister returns newLister for the source, leaving out SourceIgnore
+func (d *Differ) newSourceLister(name string) fileLister {
+	return ignore(d.newLister(d.SourceType, d.SourcePath, d.SourceBucket, name), d.SourceIgnore)
 }
 
+// newDestinationLister returns newLister for the destination, leaving out DestinationIgnore
+func (d *Differ) newDestinationLister(name string) fileLister {
+	return ignore(d.newLister(d.DestinationType, d.DestinationPath, d.DestinationBucket, name), d.DestinationIgnore)
+}
+
+// GenerateSyncList builds SyncList from the file lists populated by Diff
 func (d *Differ) GenerateSyncList() {
 	var (
-		name       string
-		obj        FileInfo
+		destinationOnly []FileInfo
+		moves           []SyncItem
+		name            string
+		obj             FileInfo
+		sourceOnly      []FileInfo
+	)
+
+	for _, obj = range d.SourceOnly {
+		sourceOnly = append(sourceOnly, obj)
+	}
+	if d.Delete == true {
+		for _, obj = range d.DestinationOnly {
+			destinationOnly = append(destinationOnly, obj)
+		}
+	}
+
+	if d.DetectRenames == true && d.Delete == true {
+		sort.Slice(sourceOnly, func(i, j int) bool { return sourceOnly[i].Name < sourceOnly[j].Name })
+		sort.Slice(destinationOnly, func(i, j int) bool { return destinationOnly[i].Name < destinationOnly[j].Name })
+		moves, sourceOnly, destinationOnly = d.matchRenames(sourceOnly, destinationOnly)
+	}
+
+	// A move is listed under the name it is moved to, which getSyncItem appended to Destination
+	for _, item := range moves {
+		d.SyncList[strings.TrimPrefix(item.Destination, strings.TrimSuffix(d.Destination, "/")+"/")] = item
+	}
+
+	for _, obj = range sourceOnly {
+		d.SyncList[obj.Name] = d.TransferItem(obj, nil, ReasonNew)
+	}
+
+	for name, obj = range d.SourceMD5Mismatch {
+		destination := d.DestinationMD5Mismatch[name]
+		d.SyncList[name] = d.TransferItem(obj, &destination, d.compare(&obj, &destination))
+	}
+
+	for _, obj = range destinationOnly {
+		d.SyncList[obj.Name] = d.DeleteItem(obj)
+	}
+}
+
+// TransferItem returns the SyncItem that copies a source file to the destination, which is nil
+// when the file does not exist there yet
+func (d *Differ) TransferItem(obj FileInfo, destination *FileInfo, reason Reason) SyncItem {
+	var (
 		sourceFile string
 		syncItem   SyncItem
-		toSync     map[string]FileInfo
 	)
 
-	toSync = mergeFileLists(d.SourceOnly, d.SourceMD5Mismatch)
-
-	for name, obj = range toSync {
-		if d.SourceType == "s3" && d.DestinationType == "s3" {
-			sourceFile = filepath.Join(
-				filepath.Dir(d.Source),
-				obj.Key,
-			)
-			syncItem = d.getSyncItem(sourceFile)
-			syncItem.Action = "copy"
-			syncItem.Message = fmt.Sprintf("%s: %s to %s", syncItem.Action, syncItem.Source, syncItem.Destination)
-
-		} else if d.SourceType == "s3" && d.DestinationType == "local" {
-			// this is not ideal
-			sourceFile = "s3://" + filepath.Join(d.SourceBucket, obj.Key)
-			syncItem = d.getSyncItem(sourceFile)
-			syncItem.Action = "download"
-			syncItem.Message = fmt.Sprintf("%s: %s to %s", syncItem.Action, syncItem.Source, syncItem.Destination)
-
-		} else if d.SourceType == "local" && d.DestinationType == "s3" {
-			sourceFile = obj.Path
-			syncItem = d.getSyncItem(sourceFile)
-			syncItem.Action = "upload"
-			syncItem.Message = fmt.Sprintf("%s: %s to %s", syncItem.Action, syncItem.Source, syncItem.Destination)
-		}
+	if d.SourceType == "s3" && d.DestinationType == "s3" {
+		sourceFile = "s3://" + path.Join(d.SourceBucket, obj.Key)
+		syncItem = d.getSyncItem(sourceFile, obj.Name)
+		syncItem.Action = ActionCopy
+		syncItem.Message = fmt.Sprintf("%s: %s to %s", syncItem.Action, syncItem.Source, syncItem.Destination)
+
+	} else if d.SourceType == "s3" && d.DestinationType == "local" {
+		sourceFile = "s3://" + path.Join(d.SourceBucket, obj.Key)
+		syncItem = d.getSyncItem(sourceFile, obj.Name)
+		syncItem.Action = ActionDownload
+		syncItem.Message = fmt.Sprintf("%s: %s to %s", syncItem.Action, syncItem.Source, syncItem.Destination)
+
+	} else if d.SourceType == "local" && d.DestinationType == "s3" {
+		sourceFile = obj.Path
+		syncItem = d.getSyncItem(sourceFile, obj.Name)
+		syncItem.Action = ActionUpload
+		syncItem.Message = fmt.Sprintf("%s: %s to %s", syncItem.Action, syncItem.Source, syncItem.Destination)
+	}
+
+	if obj.MD5 != "" {
+		syncItem.MD5 = obj.MD5
+	}
+	syncItem.Size = obj.Size
+	syncItem.StorageClass = obj.StorageClass
+	syncItem.VersionID = obj.VersionID
+	syncItem.SourceState = FileState(d.SourceType, &obj)
+	syncItem.DestinationState = FileState(d.DestinationType, destination)
+	syncItem.Reason = reason
+
+	if d.Dedup == true {
+		d.markDuplicate(&syncItem, obj)
+	}
+
+	return syncItem
+}
 
-		if obj.MD5 != "" {
-			syncItem.MD5 = obj.MD5
+// DeleteItem returns the SyncItem that removes a file only found on the destination
+func (d *Differ) DeleteItem(obj FileInfo) SyncItem {
+	if d.DestinationType == "s3" {
+		return SyncItem{
+			Action:           ActionDelete,
+			Destination:      fmt.Sprintf("s3://%s/%s", d.DestinationBucket, obj.Key),
+			Message:          fmt.Sprintf("delete: s3://%s/%s", d.DestinationBucket, obj.Key),
+			Bucket:           d.DestinationBucket,
+			Key:              obj.Key,
+			Reason:           ReasonExtraneous,
+			Size:             obj.Size,
+			DestinationState: FileState(d.DestinationType, &obj),
 		}
-		syncItem.Size = obj.Size
+	}
 
-		d.SyncList[name] = syncItem
+	return SyncItem{
+		Action:           ActionDelete,
+		Destination:      obj.Path,
+		Message:          fmt.Sprintf("delete: %s", obj.Path),
+		Path:             obj.Path,
+		Reason:           ReasonExtraneous,
+		Size:             obj.Size,
+		DestinationState: FileState(d.DestinationType, &obj),
 	}
+}
 
-	if d.Delete == true {
-		for name, obj = range d.DestinationOnly {
-			if d.DestinationType == "s3" {
-				d.SyncList[name] = SyncItem{
-					Action:  "delete",
-					Message: fmt.Sprintf("delete: s3://%s/%s", d.DestinationBucket, obj.Key),
-					Bucket:  d.DestinationBucket,
-					Key:     obj.Key,
-				}
-			} else if d.DestinationType == "local" {
-				d.SyncList[name] = SyncItem{
-					Action:  "delete",
-					Message: fmt.Sprintf("delete: %s", obj.Path),
-					Path:    obj.Path,
-				}
-			}
-		}
+// FileState captures the State of a file on one side of the diff. A nil obj is recorded as absent
+func FileState(pathType string, obj *FileInfo) *State {
+	if obj == nil {
+		return &State{Exists: false}
+	}
+	if pathType == "s3" {
+		return &State{Exists: true, ETag: obj.MD5, Size: obj.Size}
 	}
+	return &State{Exists: true, ModTime: obj.ModTime, Size: obj.Size}
 }
 
-func (d *Differ) getSyncItem(sourceFile string) SyncItem {
-	relativePath, _ := filepath.Rel(d.Source, sourceFile)
+func (d *Differ) getSyncItem(sourceFile string, name string) SyncItem {
 	return SyncItem{
 		Source: sourceFile,
-		// Destination: filepath.Join(d.Destination, relativePath),
 		// filepath.Join converts s3:// to s3:/
-		// will fix later
-		Destination: d.Destination + "/" + relativePath,
+		Destination: strings.TrimSuffix(d.Destination, "/") + "/" + name,
 	}
 }
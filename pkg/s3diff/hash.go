@@ -0,0 +1,321 @@
+package s3diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// hashBucket is the bbolt bucket holding cached checksums
+var hashBucket = []byte("md5")
+
+// hashJob is a local file waiting to be hashed. The result is delivered on its own channel so
+// the files can be hashed in parallel while still being handed back in key order.
+type hashJob struct {
+	file   FileInfo
+	result chan hashResult
+}
+
+// hashResult is the outcome of hashing a single file
+type hashResult struct {
+	cached bool
+	err    error
+	file   FileInfo
+}
+
+// hashEntry is what the cache stores for each path. A cached checksum is only trusted while
+// the size, mtime and inode of the file are unchanged.
+type hashEntry struct {
+	Inode uint64 `json:"inode"`
+	MD5   string `json:"md5"`
+	Mtime int64  `json:"mtime"`
+	Size  int64  `json:"size"`
+}
+
+// hashCache is a persistent path -> checksum cache backed by a bbolt file. bbolt locks the file
+// for a single opener, so every lister in the process shares one handle per file and the last
+// one to close it closes the file.
+type hashCache struct {
+	db    *bolt.DB
+	path  string
+	users int
+}
+
+// openHashCaches holds the open caches by absolute path
+var (
+	openHashCaches     = make(map[string]*hashCache)
+	openHashCachesLock sync.Mutex
+)
+
+// openHashCache opens, creating if needed, the cache file at path, or returns the handle already
+// open on it
+func openHashCache(path string) (*hashCache, error) {
+	var (
+		cache *hashCache
+		db    *bolt.DB
+		err   error
+	)
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	openHashCachesLock.Lock()
+	defer openHashCachesLock.Unlock()
+
+	cache = openHashCaches[path]
+	if cache != nil {
+		cache.users++
+		return cache, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(hashBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	cache = &hashCache{db: db, path: path, users: 1}
+	openHashCaches[path] = cache
+	return cache, nil
+}
+
+// lookup returns the cached checksum for path if the file has not changed since it was stored
+func (c *hashCache) lookup(path string, info os.FileInfo) (string, bool) {
+	var entry hashEntry
+
+	err := c.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(hashBucket).Get([]byte(path))
+		if value == nil {
+			return fmt.Errorf("not cached")
+		}
+		return json.Unmarshal(value, &entry)
+	})
+	if err != nil {
+		return "", false
+	}
+
+	if entry.Size != info.Size() || entry.Mtime != info.ModTime().UnixNano() || entry.Inode != fileInode(info) {
+		return "", false
+	}
+
+	return entry.MD5, true
+}
+
+// store writes the checksums of freshly hashed files in a single transaction
+func (c *hashCache) store(results []hashResult) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(hashBucket)
+		for _, result := range results {
+			value, err := json.Marshal(hashEntry{
+				Inode: fileInode(result.file.info),
+				MD5:   result.file.MD5,
+				Mtime: result.file.info.ModTime().UnixNano(),
+				Size:  result.file.info.Size(),
+			})
+			if err != nil {
+				return err
+			}
+			err = bucket.Put([]byte(result.file.Path), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// close releases the handle, closing the file once no lister is using it
+func (c *hashCache) close() error {
+	openHashCachesLock.Lock()
+	defer openHashCachesLock.Unlock()
+
+	c.users--
+	if c.users > 0 {
+		return nil
+	}
+	delete(openHashCaches, c.path)
+	return c.db.Close()
+}
+
+// hashFlushSize is the number of fresh checksums buffered before they are written to the cache
+const hashFlushSize = 1000
+
+// hashedLister wraps a local listing and fills in the md5 of each file on a pool of HashThreads
+// workers, consulting the hash cache first when one is configured. Only a small window of files
+// is in flight at any time.
+type hashedLister struct {
+	cache   *hashCache
+	closed  bool
+	fresh   []hashResult
+	path    string
+	pending chan hashJob
+	stop    chan struct{}
+}
+
+func (d *Differ) newHashedLister(lister fileLister) *hashedLister {
+	var (
+		cache   *hashCache
+		err     error
+		jobs    chan hashJob
+		pending chan hashJob
+		stop    chan struct{}
+		threads int
+	)
+
+	if d.HashCache != "" {
+		cache, err = openHashCache(d.HashCache)
+		if err != nil {
+			fmt.Printf("unable to open the hash cache %s, hashing every file: %s\n", d.HashCache, err)
+			cache = nil
+		}
+	}
+
+	threads = d.HashThreads
+	if threads < 1 {
+		threads = 1
+	}
+
+	jobs = make(chan hashJob, threads)
+	pending = make(chan hashJob, threads*4)
+	stop = make(chan struct{})
+
+	for w := 1; w <= threads; w++ {
+		go hashWorker(w, cache, jobs)
+	}
+
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		defer lister.close()
+		for {
+			file, ok, err := lister.next()
+			if err != nil {
+				job := hashJob{result: make(chan hashResult, 1)}
+				job.result <- hashResult{err: err}
+				select {
+				case pending <- job:
+				case <-stop:
+				}
+				return
+			}
+			if !ok {
+				return
+			}
+			job := hashJob{file: file, result: make(chan hashResult, 1)}
+			select {
+			case jobs <- job:
+			case <-stop:
+				return
+			}
+			select {
+			case pending <- job:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return &hashedLister{
+		cache:   cache,
+		path:    d.HashCache,
+		pending: pending,
+		stop:    stop,
+	}
+}
+
+func (l *hashedLister) next() (FileInfo, bool, error) {
+	job, ok := <-l.pending
+	if !ok {
+		l.close()
+		return FileInfo{}, false, nil
+	}
+
+	result := <-job.result
+	if result.err != nil {
+		l.close()
+		return FileInfo{}, false, result.err
+	}
+
+	if l.cache != nil && result.cached == false {
+		l.fresh = append(l.fresh, result)
+		if len(l.fresh) >= hashFlushSize {
+			l.flush()
+		}
+	}
+
+	return result.file, true, nil
+}
+
+// flush writes the buffered checksums to the cache
+func (l *hashedLister) flush() {
+	if len(l.fresh) == 0 {
+		return
+	}
+	err := l.cache.store(l.fresh)
+	if err != nil {
+		fmt.Printf("unable to update the hash cache %s: %s\n", l.path, err)
+	}
+	l.fresh = nil
+}
+
+// close stops the producer, which may still be listing if the diff ended early, and releases
+// the hash cache
+func (l *hashedLister) close() {
+	if l.closed {
+		return
+	}
+	l.closed = true
+	close(l.stop)
+	if l.cache != nil {
+		l.flush()
+		l.cache.close()
+	}
+}
+
+func hashWorker(id int, cache *hashCache, jobs <-chan hashJob) {
+	var (
+		checksum string
+		err      error
+		job      hashJob
+		ok       bool
+	)
+
+	for job = range jobs {
+		if cache != nil {
+			checksum, ok = cache.lookup(job.file.Path, job.file.info)
+			if ok {
+				job.file.MD5 = checksum
+				job.result <- hashResult{cached: true, file: job.file}
+				continue
+			}
+		}
+
+		checksum, err = MD5Checksum(job.file.Path)
+		if err != nil {
+			job.result <- hashResult{err: err}
+			continue
+		}
+		job.file.MD5 = checksum
+		job.result <- hashResult{file: job.file}
+	}
+}
@@ -0,0 +1,72 @@
+package s3diff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashCacheShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3diff-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file.txt")
+	err = ioutil.WriteFile(file, []byte("hello"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second opener in the same process gets the same handle rather than waiting on the file lock
+	path := filepath.Join(dir, "cache", "hashes.db")
+	first, err := openHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := openHashCache(filepath.Join(dir, "cache", ".", "hashes.db"))
+	if err != nil {
+		t.Fatalf("opening the cache a second time: %s", err)
+	}
+	if first != second {
+		t.Errorf("the second open did not share the handle of the first")
+	}
+
+	err = first.store([]hashResult{{file: FileInfo{MD5: "5d41402abc4b2a76b9719d911017c592", Path: file, info: info}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = first.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md5, ok := second.lookup(file, info)
+	if ok == false || md5 != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("lookup after the first close = %q, %t, want the stored md5", md5, ok)
+	}
+
+	err = second.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := openHashCaches[path]; ok == true {
+		t.Errorf("the cache is still registered after its last close")
+	}
+
+	// The file is closed and unlocked once the last user is done with it
+	third, err := openHashCache(path)
+	if err != nil {
+		t.Fatalf("reopening the cache: %s", err)
+	}
+	defer third.close()
+	if md5, ok = third.lookup(file, info); ok == false {
+		t.Errorf("the stored md5 did not survive reopening the cache")
+	}
+}
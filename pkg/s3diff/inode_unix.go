@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package s3diff
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode number of a file
+func fileInode(info os.FileInfo) uint64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+	return uint64(stat.Ino)
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package s3diff
+
+import (
+	"os"
+)
+
+// fileInode is not available from os.FileInfo on windows so the cache relies on size and mtime
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,268 @@
+package s3diff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fileLister yields the files on one side of the diff in byte-wise key order. next returns
+// false once the listing is exhausted and close releases anything held by the lister.
+type fileLister interface {
+	close()
+	next() (FileInfo, bool, error)
+}
+
+// s3Lister pages through ListObjectsV2, which already returns keys in lexicographic order. When
+// name is set only the object with that name and the objects under it are listed.
+type s3Lister struct {
+	bucket string
+	client *s3.S3
+	done   bool
+	name   string
+	page   []*s3.Object
+	prefix string
+	token  *string
+}
+
+func newS3Lister(client *s3.S3, bucket string, path string) *s3Lister {
+	var prefix string
+	if path != "" {
+		prefix = strings.TrimSuffix(path, "/") + "/"
+	}
+	return &s3Lister{
+		bucket: bucket,
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// newS3PathLister lists a single name, relative to path, and everything under it. An empty name
+// lists all of path like newS3Lister.
+func newS3PathLister(client *s3.S3, bucket string, path string, name string) *s3Lister {
+	l := newS3Lister(client, bucket, path)
+	l.name = name
+	return l
+}
+
+func (l *s3Lister) next() (FileInfo, bool, error) {
+	for {
+		for len(l.page) > 0 {
+			fileObj := l.page[0]
+			l.page = l.page[1:]
+
+			key := *fileObj.Key
+			// Keys ending in / are directory placeholders, but empty objects are files like empty
+			// local files are
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			// Listing by prefix also finds siblings such as name.txt
+			if name := strings.TrimPrefix(key, l.prefix); l.name != "" && name != l.name && strings.HasPrefix(name, l.name+"/") == false {
+				continue
+			}
+
+			return FileInfo{
+				Key:          key,
+				Name:         strings.TrimPrefix(key, l.prefix),
+				Dirname:      filepath.Dir(key),
+				Filename:     filepath.Base(key),
+				Size:         *fileObj.Size,
+				MD5:          strings.ReplaceAll(*fileObj.ETag, "\"", ""),
+				ModTime:      aws.TimeValue(fileObj.LastModified),
+				StorageClass: aws.StringValue(fileObj.StorageClass),
+			}, true, nil
+		}
+
+		if l.done {
+			return FileInfo{}, false, nil
+		}
+
+		resp, err := l.client.ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:            &l.bucket,
+			ContinuationToken: l.token,
+			Prefix:            aws.String(l.prefix + l.name),
+		})
+		if err != nil {
+			return FileInfo{}, false, err
+		}
+
+		l.page = resp.Contents
+		l.token = resp.NextContinuationToken
+		l.done = resp.IsTruncated == nil || *resp.IsTruncated == false
+	}
+}
+
+func (l *s3Lister) close() {}
+
+// localEntry is a directory entry waiting to be visited. sortKey carries a trailing slash for
+// directories so that sorting each directory on its own yields a globally ordered walk.
+type localEntry struct {
+	info    os.FileInfo
+	name    string
+	path    string
+	sortKey string
+}
+
+// localLister walks a directory tree depth first, reading one directory at a time. When name is
+// set, root is that name under the tree being diffed, which can be a single file, and the names
+// listed are relative to the tree rather than to root.
+type localLister struct {
+	name  string
+	root  string
+	stack [][]localEntry
+}
+
+// newLocalPathLister lists a single name, relative to root, and everything under it. An empty
+// name lists all of root.
+func newLocalPathLister(root string, name string) *localLister {
+	return &localLister{name: name, root: filepath.Join(root, filepath.FromSlash(name))}
+}
+
+func (l *localLister) next() (FileInfo, bool, error) {
+	if l.stack == nil {
+		info, err := os.Stat(l.root)
+		if os.IsNotExist(err) {
+			l.stack = [][]localEntry{}
+			return FileInfo{}, false, nil
+		} else if err != nil {
+			return FileInfo{}, false, err
+		}
+
+		if l.name != "" && info.IsDir() == false {
+			l.stack = [][]localEntry{{{info: info, name: l.name, path: l.root, sortKey: l.name}}}
+		} else {
+			entries, err := readDirSorted(l.root, l.name)
+			if err != nil {
+				return FileInfo{}, false, err
+			}
+			l.stack = [][]localEntry{entries}
+		}
+	}
+
+	for len(l.stack) > 0 {
+		top := len(l.stack) - 1
+		if len(l.stack[top]) == 0 {
+			l.stack = l.stack[:top]
+			continue
+		}
+
+		entry := l.stack[top][0]
+		l.stack[top] = l.stack[top][1:]
+
+		if entry.info.IsDir() {
+			entries, err := readDirSorted(entry.path, entry.name)
+			if err != nil {
+				return FileInfo{}, false, err
+			}
+			l.stack = append(l.stack, entries)
+			continue
+		}
+
+		return FileInfo{
+			Key:       entry.name,
+			Name:      entry.name,
+			Directory: false,
+			Path:      entry.path,
+			Dirname:   filepath.Dir(entry.path),
+			Filename:  filepath.Base(entry.path),
+			Size:      entry.info.Size(),
+			ModTime:   entry.info.ModTime(),
+			info:      entry.info,
+		}, true, nil
+	}
+
+	return FileInfo{}, false, nil
+}
+
+func (l *localLister) close() {}
+
+// readDirSorted reads a directory and returns its entries in key order. Symlinks are followed
+// to files, while symlinked directories and broken links are skipped just like filepath.Walk.
+func readDirSorted(path string, name string) ([]localEntry, error) {
+	var (
+		entries []localEntry
+		err     error
+		infos   []os.FileInfo
+	)
+
+	infos, err = ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		entry := localEntry{
+			info: info,
+			name: info.Name(),
+			path: filepath.Join(path, info.Name()),
+		}
+		if name != "" {
+			entry.name = name + "/" + info.Name()
+		}
+		entry.sortKey = entry.name
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(entry.path)
+			if err != nil || target.IsDir() {
+				continue
+			}
+			entry.info = target
+		}
+
+		if entry.info.IsDir() {
+			entry.sortKey += "/"
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].sortKey < entries[j].sortKey
+	})
+
+	return entries, nil
+}
+
+// ignoreLister drops every file whose name starts with one of the prefixes
+type ignoreLister struct {
+	lister   fileLister
+	prefixes []string
+}
+
+// ignore wraps lister in an ignoreLister when there are prefixes to leave out
+func ignore(lister fileLister, prefixes []string) fileLister {
+	if len(prefixes) == 0 {
+		return lister
+	}
+	return &ignoreLister{lister: lister, prefixes: prefixes}
+}
+
+func (l *ignoreLister) next() (FileInfo, bool, error) {
+	for {
+		file, ok, err := l.lister.next()
+		if err != nil || !ok {
+			return file, ok, err
+		}
+
+		ignored := false
+		for _, prefix := range l.prefixes {
+			if strings.HasPrefix(file.Name, prefix) {
+				ignored = true
+				break
+			}
+		}
+		if !ignored {
+			return file, true, nil
+		}
+	}
+}
+
+func (l *ignoreLister) close() {
+	l.lister.close()
+}
@@ -0,0 +1,135 @@
+package s3diff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// makeTree creates a file for each name under a new temp directory
+func makeTree(t *testing.T, names []string) string {
+	root, err := ioutil.TempDir("", "s3diff-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(name), 0644)
+		}
+		if err != nil {
+			os.RemoveAll(root)
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+// listNames drains a lister and returns the names it yielded
+func listNames(t *testing.T, lister fileLister) []string {
+	var names []string
+
+	defer lister.close()
+	for {
+		file, ok, err := lister.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok == false {
+			return names
+		}
+		names = append(names, file.Name)
+	}
+}
+
+func TestLocalListerByteOrder(t *testing.T) {
+	// A directory's contents sort by the key with a / in it, which comes after - and . but before
+	// 0, so a plain walk of each directory in name order would get these wrong
+	names := []string{
+		"a/b",
+		"a/c/d",
+		"a-b",
+		"a.b",
+		"a0",
+		"ab",
+		"b/a/a",
+		"b/a-a",
+		"b/a.a",
+		"b/a0",
+		"Z",
+		"é",
+		"z/y",
+	}
+	root := makeTree(t, names)
+	defer os.RemoveAll(root)
+
+	want := append([]string{}, names...)
+	sort.Strings(want)
+
+	got := listNames(t, newLocalPathLister(root, ""))
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("local listing order\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestLocalPathLister(t *testing.T) {
+	root := makeTree(t, []string{"a/b", "a/c/d", "a-b", "ab"})
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "a", want: []string{"a/b", "a/c/d"}},
+		{name: "a/c", want: []string{"a/c/d"}},
+		{name: "a-b", want: []string{"a-b"}},
+		{name: "missing", want: nil},
+	}
+
+	for _, test := range tests {
+		got := listNames(t, newLocalPathLister(root, test.name))
+		if reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("listing %q = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestIgnoreLister(t *testing.T) {
+	root := makeTree(t, []string{".s3sync.lock", ".trash/x", "a", "b"})
+	defer os.RemoveAll(root)
+
+	got := listNames(t, ignore(newLocalPathLister(root, ""), []string{".s3sync.lock", ".trash/"}))
+	want := []string{"a", "b"}
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("ignored listing = %q, want %q", got, want)
+	}
+}
+
+func TestS3ListerEmptyObjects(t *testing.T) {
+	object := func(key string, size int64) *s3.Object {
+		return &s3.Object{ETag: aws.String(`"etag"`), Key: aws.String(key), Size: aws.Int64(size)}
+	}
+
+	lister := newS3Lister(nil, "bucket", "data")
+	lister.page = []*s3.Object{
+		object("data/dir/", 0),
+		object("data/dir/empty", 0),
+		object("data/file", 3),
+	}
+	lister.done = true
+
+	got := listNames(t, lister)
+	want := []string{"dir/empty", "file"}
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("s3 listing = %q, want %q", got, want)
+	}
+}
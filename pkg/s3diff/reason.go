@@ -0,0 +1,136 @@
+package s3diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Action is what a SyncItem does to the destination
+type Action string
+
+// The actions a SyncItem can carry
+const (
+	ActionCopy     Action = "copy"
+	ActionDelete   Action = "delete"
+	ActionDownload Action = "download"
+	ActionMove     Action = "move"
+	ActionRename   Action = "rename"
+	ActionUpload   Action = "upload"
+)
+
+// Reason explains why a SyncItem was chosen
+type Reason string
+
+// The reasons a file can be transferred or deleted
+const (
+	ReasonContentChanged  Reason = "content-changed"
+	ReasonExtraneous      Reason = "extraneous"
+	ReasonMetadataChanged Reason = "metadata-changed"
+	ReasonNew             Reason = "new"
+	ReasonNewerMtime      Reason = "newer-mtime"
+	// ReasonRenamed means a new source file matches an extraneous destination file by size and md5,
+	// so the destination file is moved instead of the new file being transferred
+	ReasonRenamed     Reason = "renamed"
+	ReasonSizeChanged Reason = "size-changed"
+	// ReasonStorageClassChanged means the content matches but the destination object is in the
+	// wrong storage class. The object is copied onto itself rather than transferred again.
+	ReasonStorageClassChanged Reason = "storage-class-changed"
+)
+
+// compare decides whether a file present on both sides needs to be transferred and why. An empty
+// Reason means the two are in sync. Multipart ETags and the ETags of encrypted objects are not an
+// md5 of the content, so when either side has one the files are compared by size and mtime instead.
+// When StorageClass is set, a destination object in any other class is reported as well, and
+// MetadataChanged is consulted last.
+func (d *Differ) compare(source *FileInfo, destination *FileInfo) Reason {
+	switch {
+	case source.Size != destination.Size:
+		return ReasonSizeChanged
+	case d.OpaqueETags || isMultipartETag(source.MD5) || isMultipartETag(destination.MD5):
+		if d.newerMtime(source, destination) {
+			return ReasonNewerMtime
+		}
+	case source.MD5 != destination.MD5:
+		return ReasonContentChanged
+	}
+
+	if d.StorageClass != "" && d.DestinationType == "s3" && storageClass(destination) != d.StorageClass {
+		return ReasonStorageClassChanged
+	}
+
+	if d.MetadataChanged != nil && d.MetadataChanged(source, destination) == true {
+		return ReasonMetadataChanged
+	}
+
+	return ""
+}
+
+// newerMtime reports whether the source was modified after the destination. SourceMtime is only
+// asked when the listed mtime says the source is newer, since that is when it can change the answer.
+func (d *Differ) newerMtime(source *FileInfo, destination *FileInfo) bool {
+	if source.ModTime.After(destination.ModTime) == false {
+		return false
+	}
+	if d.SourceMtime != nil {
+		if mtime, ok := d.SourceMtime(source); ok {
+			return mtime.After(destination.ModTime)
+		}
+	}
+	return true
+}
+
+// storageClass returns the storage class of an object, which listings leave out for STANDARD
+func storageClass(obj *FileInfo) string {
+	if obj.StorageClass == "" {
+		return "STANDARD"
+	}
+	return obj.StorageClass
+}
+
+// isMultipartETag reports whether an ETag came from a multipart upload
+func isMultipartETag(etag string) bool {
+	return strings.Contains(etag, "-")
+}
+
+// Itemize describes the item in the style of rsync --itemize-changes. The first character is
+// < for a file sent to s3, > for a file received locally and * for a deletion or a move.
+func (i SyncItem) Itemize() string {
+	var (
+		changes   []byte
+		direction byte
+		name      string
+	)
+
+	name = i.Destination
+	if i.Action == ActionDelete {
+		return fmt.Sprintf("*deleting   %s", name)
+	}
+	if i.Action == ActionMove || i.Action == ActionRename {
+		return fmt.Sprintf("*moving     %s => %s", i.Source, name)
+	}
+
+	direction = '<'
+	if i.Action == ActionDownload {
+		direction = '>'
+	}
+
+	if i.Reason == ReasonNew {
+		return fmt.Sprintf("%cf+++++++++ %s", direction, name)
+	}
+
+	// c = checksum, s = size, t = mtime, p = permissions, o = owner, g = group, u = unused, a = acl, x = metadata
+	changes = []byte(".........")
+	switch i.Reason {
+	case ReasonContentChanged:
+		changes[0] = 'c'
+	case ReasonSizeChanged:
+		changes[0] = 'c'
+		changes[1] = 's'
+	case ReasonNewerMtime:
+		changes[2] = 't'
+	case ReasonMetadataChanged, ReasonStorageClassChanged:
+		changes[8] = 'x'
+	}
+
+	return fmt.Sprintf("%cf%s %s", direction, changes, name)
+}
@@ -0,0 +1,208 @@
+package s3diff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	var (
+		earlier = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		later   = earlier.Add(time.Hour)
+	)
+
+	tests := []struct {
+		name        string
+		differ      Differ
+		source      FileInfo
+		destination FileInfo
+		want        Reason
+	}{
+		{
+			name:        "in sync",
+			source:      FileInfo{Size: 3, MD5: "abc", ModTime: later},
+			destination: FileInfo{Size: 3, MD5: "abc", ModTime: earlier},
+		},
+		{
+			name:        "size changed",
+			source:      FileInfo{Size: 3, MD5: "abc"},
+			destination: FileInfo{Size: 4, MD5: "abc"},
+			want:        ReasonSizeChanged,
+		},
+		{
+			name:        "content changed",
+			source:      FileInfo{Size: 3, MD5: "abc", ModTime: earlier},
+			destination: FileInfo{Size: 3, MD5: "def", ModTime: later},
+			want:        ReasonContentChanged,
+		},
+		{
+			name:        "multipart source newer",
+			source:      FileInfo{Size: 3, MD5: "abc-2", ModTime: later},
+			destination: FileInfo{Size: 3, MD5: "abc", ModTime: earlier},
+			want:        ReasonNewerMtime,
+		},
+		{
+			name:        "multipart destination newer",
+			source:      FileInfo{Size: 3, MD5: "abc", ModTime: earlier},
+			destination: FileInfo{Size: 3, MD5: "def-2", ModTime: later},
+		},
+		{
+			name:        "multipart same mtime",
+			source:      FileInfo{Size: 3, MD5: "abc-2", ModTime: earlier},
+			destination: FileInfo{Size: 3, MD5: "def-2", ModTime: earlier},
+		},
+		{
+			name:        "opaque source newer",
+			differ:      Differ{OpaqueETags: true},
+			source:      FileInfo{Size: 3, MD5: "abc", ModTime: later},
+			destination: FileInfo{Size: 3, MD5: "def", ModTime: earlier},
+			want:        ReasonNewerMtime,
+		},
+		{
+			name:        "opaque destination newer",
+			differ:      Differ{OpaqueETags: true},
+			source:      FileInfo{Size: 3, MD5: "abc", ModTime: earlier},
+			destination: FileInfo{Size: 3, MD5: "def", ModTime: later},
+		},
+		{
+			// A download that preserved times set the local mtime from the object's metadata
+			name:        "source mtime matches",
+			differ:      Differ{SourceMtime: func(*FileInfo) (time.Time, bool) { return earlier, true }},
+			source:      FileInfo{Size: 3, MD5: "abc-2", ModTime: later},
+			destination: FileInfo{Size: 3, ModTime: earlier},
+		},
+		{
+			name:        "source mtime newer",
+			differ:      Differ{SourceMtime: func(*FileInfo) (time.Time, bool) { return later, true }},
+			source:      FileInfo{Size: 3, MD5: "abc-2", ModTime: later},
+			destination: FileInfo{Size: 3, ModTime: earlier},
+			want:        ReasonNewerMtime,
+		},
+		{
+			name:        "source mtime unknown",
+			differ:      Differ{SourceMtime: func(*FileInfo) (time.Time, bool) { return time.Time{}, false }},
+			source:      FileInfo{Size: 3, MD5: "abc-2", ModTime: later},
+			destination: FileInfo{Size: 3, ModTime: earlier},
+			want:        ReasonNewerMtime,
+		},
+		{
+			name:        "storage class changed",
+			differ:      Differ{DestinationType: "s3", StorageClass: "STANDARD_IA"},
+			source:      FileInfo{Size: 3, MD5: "abc"},
+			destination: FileInfo{Size: 3, MD5: "abc"},
+			want:        ReasonStorageClassChanged,
+		},
+		{
+			name:        "listed without a storage class",
+			differ:      Differ{DestinationType: "s3", StorageClass: "STANDARD"},
+			source:      FileInfo{Size: 3, MD5: "abc"},
+			destination: FileInfo{Size: 3, MD5: "abc"},
+		},
+		{
+			name:        "storage class of a local destination",
+			differ:      Differ{DestinationType: "local", StorageClass: "STANDARD_IA"},
+			source:      FileInfo{Size: 3, MD5: "abc"},
+			destination: FileInfo{Size: 3, MD5: "abc"},
+		},
+		{
+			name:        "metadata changed",
+			differ:      Differ{MetadataChanged: func(*FileInfo, *FileInfo) bool { return true }},
+			source:      FileInfo{Size: 3, MD5: "abc"},
+			destination: FileInfo{Size: 3, MD5: "abc"},
+			want:        ReasonMetadataChanged,
+		},
+		{
+			name:        "metadata unchanged",
+			differ:      Differ{MetadataChanged: func(*FileInfo, *FileInfo) bool { return false }},
+			source:      FileInfo{Size: 3, MD5: "abc"},
+			destination: FileInfo{Size: 3, MD5: "abc"},
+		},
+		{
+			name:        "content before metadata",
+			differ:      Differ{MetadataChanged: func(*FileInfo, *FileInfo) bool { return true }},
+			source:      FileInfo{Size: 3, MD5: "abc"},
+			destination: FileInfo{Size: 3, MD5: "def"},
+			want:        ReasonContentChanged,
+		},
+	}
+
+	for _, test := range tests {
+		got := test.differ.compare(&test.source, &test.destination)
+		if got != test.want {
+			t.Errorf("%s: compare = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompareSourceMtimeOnlyWhenNewer(t *testing.T) {
+	var (
+		calls   int
+		earlier = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	d := Differ{SourceMtime: func(*FileInfo) (time.Time, bool) {
+		calls++
+		return earlier, true
+	}}
+
+	d.compare(&FileInfo{Size: 3, MD5: "abc-2", ModTime: earlier}, &FileInfo{Size: 3, ModTime: earlier.Add(time.Hour)})
+	d.compare(&FileInfo{Size: 3, MD5: "abc"}, &FileInfo{Size: 3, MD5: "abc"})
+	if calls != 0 {
+		t.Errorf("SourceMtime was called %d time(s) for files the listing already shows in sync", calls)
+	}
+}
+
+func TestItemize(t *testing.T) {
+	tests := []struct {
+		item SyncItem
+		want string
+	}{
+		{
+			item: SyncItem{Action: ActionUpload, Destination: "s3://b/a", Reason: ReasonNew},
+			want: "<f+++++++++ s3://b/a",
+		},
+		{
+			item: SyncItem{Action: ActionDownload, Destination: "/d/a", Reason: ReasonNew},
+			want: ">f+++++++++ /d/a",
+		},
+		{
+			item: SyncItem{Action: ActionCopy, Destination: "s3://b/a", Reason: ReasonContentChanged},
+			want: "<fc........ s3://b/a",
+		},
+		{
+			item: SyncItem{Action: ActionDownload, Destination: "/d/a", Reason: ReasonSizeChanged},
+			want: ">fcs....... /d/a",
+		},
+		{
+			item: SyncItem{Action: ActionUpload, Destination: "s3://b/a", Reason: ReasonNewerMtime},
+			want: "<f..t...... s3://b/a",
+		},
+		{
+			item: SyncItem{Action: ActionCopy, Destination: "s3://b/a", Reason: ReasonMetadataChanged},
+			want: "<f........x s3://b/a",
+		},
+		{
+			item: SyncItem{Action: ActionUpload, Destination: "s3://b/a", Reason: ReasonStorageClassChanged},
+			want: "<f........x s3://b/a",
+		},
+		{
+			item: SyncItem{Action: ActionDelete, Destination: "s3://b/a", Reason: ReasonExtraneous},
+			want: "*deleting   s3://b/a",
+		},
+		{
+			item: SyncItem{Action: ActionMove, Source: "s3://b/old", Destination: "s3://b/new", Reason: ReasonRenamed},
+			want: "*moving     s3://b/old => s3://b/new",
+		},
+		{
+			item: SyncItem{Action: ActionRename, Source: "/d/old", Destination: "/d/new", Reason: ReasonRenamed},
+			want: "*moving     /d/old => /d/new",
+		},
+	}
+
+	for _, test := range tests {
+		got := test.item.Itemize()
+		if got != test.want {
+			t.Errorf("Itemize(%s %s) = %q, want %q", test.item.Action, test.item.Reason, got, test.want)
+		}
+	}
+}
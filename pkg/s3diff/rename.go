@@ -0,0 +1,85 @@
+package s3diff
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// contentKey returns the size and md5 a file is matched on when looking for renames and duplicates,
+// or "" when it can't take part because its checksum is unknown or is not an md5 of the content.
+// Empty files are left out since there is nothing to save by moving or copying them.
+func (d *Differ) contentKey(obj FileInfo) string {
+	if obj.Size == 0 || obj.MD5 == "" || d.OpaqueETags || isMultipartETag(obj.MD5) {
+		return ""
+	}
+	return strconv.FormatInt(obj.Size, 10) + ":" + obj.MD5
+}
+
+// matchRenames pairs files only found on the source with files only found on the destination that
+// have the same size and md5. Each pair becomes a move or rename of the destination file and the
+// files left over are returned in the order they were given.
+func (d *Differ) matchRenames(sources []FileInfo, destinations []FileInfo) ([]SyncItem, []FileInfo, []FileInfo) {
+	var (
+		candidates map[string][]int
+		matched    map[int]bool
+		moves      []SyncItem
+		remaining  []FileInfo
+	)
+
+	candidates = make(map[string][]int)
+	for i, obj := range destinations {
+		if key := d.contentKey(obj); key != "" {
+			candidates[key] = append(candidates[key], i)
+		}
+	}
+
+	matched = make(map[int]bool)
+	for _, obj := range sources {
+		key := d.contentKey(obj)
+		if key == "" || len(candidates[key]) == 0 {
+			remaining = append(remaining, obj)
+			continue
+		}
+
+		i := candidates[key][0]
+		candidates[key] = candidates[key][1:]
+		matched[i] = true
+		moves = append(moves, d.MoveItem(obj, destinations[i]))
+	}
+
+	sources = remaining
+	remaining = nil
+	for i, obj := range destinations {
+		if matched[i] == false {
+			remaining = append(remaining, obj)
+		}
+	}
+
+	return moves, sources, remaining
+}
+
+// MoveItem returns the SyncItem that moves the destination file from to where the source file obj
+// belongs. s3 objects are moved with a server side copy and a delete, local files are renamed.
+func (d *Differ) MoveItem(obj FileInfo, from FileInfo) SyncItem {
+	var syncItem SyncItem
+
+	if d.DestinationType == "s3" {
+		syncItem = d.getSyncItem(fmt.Sprintf("s3://%s/%s", d.DestinationBucket, from.Key), obj.Name)
+		syncItem.Action = ActionMove
+		syncItem.Bucket = d.DestinationBucket
+		syncItem.Key = from.Key
+	} else {
+		syncItem = d.getSyncItem(from.Path, obj.Name)
+		syncItem.Action = ActionRename
+		syncItem.Path = from.Path
+	}
+
+	syncItem.Message = fmt.Sprintf("%s: %s to %s", syncItem.Action, syncItem.Source, syncItem.Destination)
+	syncItem.MD5 = obj.MD5
+	syncItem.Size = obj.Size
+	syncItem.SourceState = FileState(d.DestinationType, &from)
+	syncItem.DestinationState = FileState(d.DestinationType, nil)
+	syncItem.Reason = ReasonRenamed
+
+	return syncItem
+}
@@ -0,0 +1,120 @@
+package s3diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fileNames returns the name of each file
+func fileNames(files []FileInfo) []string {
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return names
+}
+
+func TestMatchRenames(t *testing.T) {
+	tests := []struct {
+		name         string
+		differ       Differ
+		sources      []FileInfo
+		destinations []FileInfo
+		moves        []string
+		sourceLeft   []string
+		destLeft     []string
+	}{
+		{
+			name:         "renamed",
+			sources:      []FileInfo{{Name: "new", Size: 3, MD5: "abc"}},
+			destinations: []FileInfo{{Name: "old", Key: "data/old", Size: 3, MD5: "abc"}},
+			moves:        []string{"s3://bucket/data/old => s3://bucket/data/new"},
+		},
+		{
+			name:         "different content",
+			sources:      []FileInfo{{Name: "new", Size: 3, MD5: "abc"}},
+			destinations: []FileInfo{{Name: "old", Key: "data/old", Size: 3, MD5: "def"}},
+			sourceLeft:   []string{"new"},
+			destLeft:     []string{"old"},
+		},
+		{
+			name:         "same md5, different size",
+			sources:      []FileInfo{{Name: "new", Size: 3, MD5: "abc"}},
+			destinations: []FileInfo{{Name: "old", Key: "data/old", Size: 4, MD5: "abc"}},
+			sourceLeft:   []string{"new"},
+			destLeft:     []string{"old"},
+		},
+		{
+			name:         "each destination used once",
+			sources:      []FileInfo{{Name: "a", Size: 3, MD5: "abc"}, {Name: "b", Size: 3, MD5: "abc"}},
+			destinations: []FileInfo{{Name: "old", Key: "data/old", Size: 3, MD5: "abc"}},
+			moves:        []string{"s3://bucket/data/old => s3://bucket/data/a"},
+			sourceLeft:   []string{"b"},
+		},
+		{
+			name:         "destinations matched in order",
+			sources:      []FileInfo{{Name: "new", Size: 3, MD5: "abc"}},
+			destinations: []FileInfo{{Name: "x", Key: "data/x", Size: 3, MD5: "abc"}, {Name: "y", Key: "data/y", Size: 3, MD5: "abc"}},
+			moves:        []string{"s3://bucket/data/x => s3://bucket/data/new"},
+			destLeft:     []string{"y"},
+		},
+		{
+			name:         "empty files",
+			sources:      []FileInfo{{Name: "new", MD5: "d41d8cd98f00b204e9800998ecf8427e"}},
+			destinations: []FileInfo{{Name: "old", Key: "data/old", MD5: "d41d8cd98f00b204e9800998ecf8427e"}},
+			sourceLeft:   []string{"new"},
+			destLeft:     []string{"old"},
+		},
+		{
+			name:         "multipart etags",
+			sources:      []FileInfo{{Name: "new", Size: 3, MD5: "abc-2"}},
+			destinations: []FileInfo{{Name: "old", Key: "data/old", Size: 3, MD5: "abc-2"}},
+			sourceLeft:   []string{"new"},
+			destLeft:     []string{"old"},
+		},
+		{
+			name:         "opaque etags",
+			differ:       Differ{OpaqueETags: true},
+			sources:      []FileInfo{{Name: "new", Size: 3, MD5: "abc"}},
+			destinations: []FileInfo{{Name: "old", Key: "data/old", Size: 3, MD5: "abc"}},
+			sourceLeft:   []string{"new"},
+			destLeft:     []string{"old"},
+		},
+	}
+
+	for _, test := range tests {
+		var moves []string
+
+		d := test.differ
+		d.Destination = "s3://bucket/data"
+		d.DestinationBucket = "bucket"
+		d.DestinationType = "s3"
+
+		items, sources, destinations := d.matchRenames(test.sources, test.destinations)
+		for _, item := range items {
+			if item.Action != ActionMove || item.Reason != ReasonRenamed {
+				t.Errorf("%s: matched %s %s, want %s %s", test.name, item.Action, item.Reason, ActionMove, ReasonRenamed)
+			}
+			moves = append(moves, item.Source+" => "+item.Destination)
+		}
+
+		if reflect.DeepEqual(moves, test.moves) == false {
+			t.Errorf("%s: moves = %v, want %v", test.name, moves, test.moves)
+		}
+		if got := fileNames(sources); reflect.DeepEqual(got, test.sourceLeft) == false {
+			t.Errorf("%s: sources left = %v, want %v", test.name, got, test.sourceLeft)
+		}
+		if got := fileNames(destinations); reflect.DeepEqual(got, test.destLeft) == false {
+			t.Errorf("%s: destinations left = %v, want %v", test.name, got, test.destLeft)
+		}
+	}
+}
+
+func TestMoveItemLocal(t *testing.T) {
+	d := Differ{Destination: "/backup/", DestinationType: "local"}
+
+	item := d.MoveItem(FileInfo{Name: "dir/new", Size: 3, MD5: "abc"}, FileInfo{Path: "/backup/old", Size: 3, MD5: "abc"})
+	if item.Action != ActionRename || item.Source != "/backup/old" || item.Destination != "/backup/dir/new" || item.Path != "/backup/old" {
+		t.Errorf("MoveItem = %s %s to %s (path %s), want %s /backup/old to /backup/dir/new", item.Action, item.Source, item.Destination, item.Path, ActionRename)
+	}
+}
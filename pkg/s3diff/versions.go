@@ -0,0 +1,159 @@
+package s3diff
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// objectVersion is a single entry from ListObjectVersions, either a version or a delete marker
+type objectVersion struct {
+	deleteMarker bool
+	etag         string
+	key          string
+	modTime      time.Time
+	size         int64
+	storageClass string
+	versionID    string
+}
+
+// s3VersionLister lists the version of each key that was current at asOf. ListObjectVersions
+// returns keys in order with the versions of each key newest first, so the first version of a key
+// that is not newer than asOf is the one to use. A key whose chosen entry is a delete marker, or
+// which did not exist yet, is left out.
+type s3VersionLister struct {
+	asOf          time.Time
+	bucket        string
+	chosen        *objectVersion
+	client        *s3.S3
+	current       string
+	done          bool
+	keyMarker     *string
+	page          []objectVersion
+	prefix        string
+	versionMarker *string
+}
+
+func newS3VersionLister(client *s3.S3, bucket string, path string, asOf time.Time) *s3VersionLister {
+	var prefix string
+	if path != "" {
+		prefix = strings.TrimSuffix(path, "/") + "/"
+	}
+	return &s3VersionLister{
+		asOf:   asOf,
+		bucket: bucket,
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (l *s3VersionLister) next() (FileInfo, bool, error) {
+	for {
+		for len(l.page) > 0 {
+			version := l.page[0]
+			l.page = l.page[1:]
+
+			if version.key == l.current {
+				if l.chosen == nil && version.modTime.After(l.asOf) == false {
+					l.chosen = &version
+				}
+				continue
+			}
+
+			previous := l.chosen
+			l.current = version.key
+			l.chosen = nil
+			if version.modTime.After(l.asOf) == false {
+				l.chosen = &version
+			}
+			if file, ok := l.fileInfo(previous); ok {
+				return file, true, nil
+			}
+		}
+
+		if l.done {
+			previous := l.chosen
+			l.chosen = nil
+			if file, ok := l.fileInfo(previous); ok {
+				return file, true, nil
+			}
+			return FileInfo{}, false, nil
+		}
+
+		err := l.fetch()
+		if err != nil {
+			return FileInfo{}, false, err
+		}
+	}
+}
+
+// fetch reads the next page of versions and delete markers, merged into key order
+func (l *s3VersionLister) fetch() error {
+	resp, err := l.client.ListObjectVersions(&s3.ListObjectVersionsInput{
+		Bucket:          &l.bucket,
+		KeyMarker:       l.keyMarker,
+		Prefix:          &l.prefix,
+		VersionIdMarker: l.versionMarker,
+	})
+	if err != nil {
+		return err
+	}
+
+	l.page = nil
+	for _, v := range resp.Versions {
+		l.page = append(l.page, objectVersion{
+			etag:         strings.ReplaceAll(aws.StringValue(v.ETag), "\"", ""),
+			key:          aws.StringValue(v.Key),
+			modTime:      aws.TimeValue(v.LastModified),
+			size:         aws.Int64Value(v.Size),
+			storageClass: aws.StringValue(v.StorageClass),
+			versionID:    aws.StringValue(v.VersionId),
+		})
+	}
+	for _, m := range resp.DeleteMarkers {
+		l.page = append(l.page, objectVersion{
+			deleteMarker: true,
+			key:          aws.StringValue(m.Key),
+			modTime:      aws.TimeValue(m.LastModified),
+			versionID:    aws.StringValue(m.VersionId),
+		})
+	}
+	sort.SliceStable(l.page, func(i, j int) bool {
+		if l.page[i].key != l.page[j].key {
+			return l.page[i].key < l.page[j].key
+		}
+		return l.page[i].modTime.After(l.page[j].modTime)
+	})
+
+	l.keyMarker = resp.NextKeyMarker
+	l.versionMarker = resp.NextVersionIdMarker
+	l.done = resp.IsTruncated == nil || *resp.IsTruncated == false
+
+	return nil
+}
+
+// fileInfo converts the version chosen for a key, skipping delete markers and the same
+// directory placeholders as s3Lister
+func (l *s3VersionLister) fileInfo(version *objectVersion) (FileInfo, bool) {
+	if version == nil || version.deleteMarker || strings.HasSuffix(version.key, "/") {
+		return FileInfo{}, false
+	}
+
+	return FileInfo{
+		Key:          version.key,
+		Name:         strings.TrimPrefix(version.key, l.prefix),
+		Dirname:      filepath.Dir(version.key),
+		Filename:     filepath.Base(version.key),
+		Size:         version.size,
+		MD5:          version.etag,
+		ModTime:      version.modTime,
+		StorageClass: version.storageClass,
+		VersionID:    version.versionID,
+	}, true
+}
+
+func (l *s3VersionLister) close() {}
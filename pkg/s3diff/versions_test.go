@@ -0,0 +1,65 @@
+package s3diff
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVersionListerAsOf(t *testing.T) {
+	var (
+		t1 = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		t2 = t1.Add(time.Hour)
+		t3 = t2.Add(time.Hour)
+	)
+
+	// Keys in order, the versions of each newest first, as fetch leaves them
+	page := []objectVersion{
+		{key: "data/changed", modTime: t3, size: 3, versionID: "changed-3"},
+		{key: "data/changed", modTime: t2, size: 2, versionID: "changed-2"},
+		{key: "data/changed", modTime: t1, size: 1, versionID: "changed-1"},
+		{key: "data/deleted", modTime: t2, deleteMarker: true, versionID: "deleted-2"},
+		{key: "data/deleted", modTime: t1, size: 1, versionID: "deleted-1"},
+		{key: "data/dir/", modTime: t1, versionID: "dir-1"},
+		{key: "data/empty", modTime: t1, versionID: "empty-1"},
+		{key: "data/later", modTime: t3, size: 1, versionID: "later-3"},
+		{key: "data/restored", modTime: t3, size: 3, versionID: "restored-3"},
+		{key: "data/restored", modTime: t2, size: 2, versionID: "restored-2"},
+		{key: "data/restored", modTime: t1, deleteMarker: true, versionID: "restored-1"},
+		{key: "data/same", modTime: t2, size: 2, versionID: "same-2"},
+	}
+
+	tests := []struct {
+		asOf time.Time
+		want []string
+	}{
+		{asOf: t1.Add(-time.Minute)},
+		{asOf: t1, want: []string{"changed-1", "deleted-1", "empty-1"}},
+		{asOf: t2, want: []string{"changed-2", "empty-1", "restored-2", "same-2"}},
+		{asOf: t2.Add(time.Minute), want: []string{"changed-2", "empty-1", "restored-2", "same-2"}},
+		{asOf: t3, want: []string{"changed-3", "empty-1", "later-3", "restored-3", "same-2"}},
+	}
+
+	for _, test := range tests {
+		var versions []string
+
+		lister := &s3VersionLister{asOf: test.asOf, prefix: "data/", page: append([]objectVersion(nil), page...), done: true}
+		for {
+			file, ok, err := lister.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok == false {
+				break
+			}
+			if file.Name != file.Key[len("data/"):] {
+				t.Errorf("as of %s: %s listed with name %q", test.asOf.Format(time.RFC3339), file.Key, file.Name)
+			}
+			versions = append(versions, file.VersionID)
+		}
+
+		if reflect.DeepEqual(versions, test.want) == false {
+			t.Errorf("as of %s: listed %v, want %v", test.asOf.Format(time.RFC3339), versions, test.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package s3sync
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// cannedACLs lists the values accepted by Syncer.ACL
+var cannedACLs = []string{
+	s3.ObjectCannedACLPrivate,
+	s3.ObjectCannedACLPublicRead,
+	s3.ObjectCannedACLPublicReadWrite,
+	s3.ObjectCannedACLAuthenticatedRead,
+	s3.ObjectCannedACLAwsExecRead,
+	s3.ObjectCannedACLBucketOwnerRead,
+	s3.ObjectCannedACLBucketOwnerFullControl,
+}
+
+// errACLNotSupported is returned by s3 when the bucket's object ownership is set to bucket owner
+// enforced, which disables ACLs
+const errACLNotSupported = "AccessControlListNotSupported"
+
+// validateACL checks the ACL options. ACL defaults to private unless grants are given, since s3
+// does not accept a canned ACL and explicit grants on the same request.
+func (s *Syncer) validateACL() error {
+	var valid bool
+
+	if s.GrantRead != "" || s.GrantFullControl != "" {
+		if s.ACL != "" {
+			return fmt.Errorf("the ACL option cannot be combined with GrantRead or GrantFullControl")
+		}
+		if s.PreserveACL == true {
+			return fmt.Errorf("the PreserveACL option cannot be combined with GrantRead or GrantFullControl")
+		}
+		return nil
+	}
+
+	if s.ACL == "" {
+		s.ACL = s3.ObjectCannedACLPrivate
+	}
+
+	for _, acl := range cannedACLs {
+		if s.ACL == acl {
+			valid = true
+		}
+	}
+	if valid == false {
+		return fmt.Errorf("invalid ACL %q", s.ACL)
+	}
+
+	return nil
+}
+
+// aclsEnabled reports whether ACLs are still being sent. They are turned off for the rest of the
+// run the first time the destination bucket rejects them.
+func (s *Syncer) aclsEnabled() bool {
+	return atomic.LoadInt32(&s.aclsDisabled) == 0
+}
+
+// withACL runs put, which sends an ACL, and runs it again without one if the destination bucket
+// turns out to have ACLs disabled
+func (s *Syncer) withACL(put func() error) error {
+	err := put()
+	if isACLNotSupported(err) {
+		s.disableACLs()
+		return put()
+	}
+	return err
+}
+
+// disableACLs stops ACLs being sent for the rest of the run
+func (s *Syncer) disableACLs() {
+	if atomic.CompareAndSwapInt32(&s.aclsDisabled, 0, 1) {
+		fmt.Printf("the destination bucket %s has ACLs disabled, skipping ACLs\n", s.Differ.DestinationBucket)
+	}
+}
+
+// isACLNotSupported reports whether err, or any error it wraps, is errACLNotSupported
+func isACLNotSupported(err error) bool {
+	for err != nil {
+		aerr, ok := err.(awserr.Error)
+		if ok == false {
+			return false
+		}
+		if aerr.Code() == errACLNotSupported {
+			return true
+		}
+		err = aerr.OrigErr()
+	}
+	return false
+}
+
+// uploadACL sets the canned ACL or grants on an upload, or clears them once ACLs are disabled
+func (s *Syncer) uploadACL(input *s3manager.UploadInput) {
+	input.ACL = nil
+	input.GrantFullControl = nil
+	input.GrantRead = nil
+
+	if s.aclsEnabled() == false {
+		return
+	}
+	if s.ACL != "" {
+		input.ACL = aws.String(s.ACL)
+	}
+	if s.GrantFullControl != "" {
+		input.GrantFullControl = aws.String(s.GrantFullControl)
+	}
+	if s.GrantRead != "" {
+		input.GrantRead = aws.String(s.GrantRead)
+	}
+}
+
+// copyACL sets the canned ACL or grants on a copy, or clears them once ACLs are disabled
+func (s *Syncer) copyACL(input *s3.CopyObjectInput) {
+	input.ACL = nil
+	input.GrantFullControl = nil
+	input.GrantRead = nil
+
+	if s.aclsEnabled() == false {
+		return
+	}
+	if s.ACL != "" {
+		input.ACL = aws.String(s.ACL)
+	}
+	if s.GrantFullControl != "" {
+		input.GrantFullControl = aws.String(s.GrantFullControl)
+	}
+	if s.GrantRead != "" {
+		input.GrantRead = aws.String(s.GrantRead)
+	}
+}
+
+// preserveACL replaces the ACL of a copied object with the grants of its source, the version
+// sourceVersionID of it when that is set. The destination object keeps its own owner.
+func (s *Syncer) preserveACL(sourceBucket string, sourceKey string, sourceVersionID *string, destinationBucket string, destinationKey string) error {
+	if s.aclsEnabled() == false {
+		return nil
+	}
+
+	source, err := s.S3.GetObjectAcl(&s3.GetObjectAclInput{
+		Bucket:    aws.String(sourceBucket),
+		Key:       aws.String(sourceKey),
+		VersionId: sourceVersionID,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read the source ACL: %s", err)
+	}
+
+	destination, err := s.S3.GetObjectAcl(&s3.GetObjectAclInput{
+		Bucket: aws.String(destinationBucket),
+		Key:    aws.String(destinationKey),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read the destination ACL: %s", err)
+	}
+
+	_, err = s.S3.PutObjectAcl(&s3.PutObjectAclInput{
+		AccessControlPolicy: &s3.AccessControlPolicy{
+			Grants: source.Grants,
+			Owner:  destination.Owner,
+		},
+		Bucket: aws.String(destinationBucket),
+		Key:    aws.String(destinationKey),
+	})
+	if isACLNotSupported(err) {
+		s.disableACLs()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to copy the source ACL: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package s3sync
+
+import (
+	"testing"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+func TestPreserveACLOfSourceVersion(t *testing.T) {
+	f := newFakeS3()
+	defer f.close()
+	f.put("source/data/file", &fakeObject{body: []byte("old version")})
+
+	s := &Syncer{
+		Differ:      &s3diff.Differ{SourcePath: "data", SourceType: "s3", DestinationPath: "data", DestinationType: "s3"},
+		PreserveACL: true,
+		S3:          f.client(),
+	}
+	err := s.copy(s3diff.SyncItem{Source: "s3://source/data/file", Destination: "s3://destination/data/file", Size: 11, VersionID: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := make(map[string]string)
+	for _, r := range f.received("GET", "acl") {
+		versions[r.path] = r.query.Get("versionId")
+	}
+	if versions["/source/data/file"] != "v1" {
+		t.Errorf("the source ACL was read from version %q, want v1", versions["/source/data/file"])
+	}
+	if version, ok := versions["/destination/data/file"]; ok == false || version != "" {
+		t.Errorf("the destination ACL was read from version %q (read %t), want the latest", version, ok)
+	}
+	if len(f.received("PUT", "acl")) != 1 {
+		t.Errorf("the source ACL was not copied")
+	}
+}
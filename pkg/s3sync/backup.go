@@ -0,0 +1,314 @@
+package s3sync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// DefaultBackupDir is used when Backup is set without a BackupDir. For s3 destinations it is relative
+// to the bucket root, for local destinations it is relative to the destination directory.
+const DefaultBackupDir = ".trash"
+
+// backupTimeFormat names the directory each run moves its backups into
+const backupTimeFormat = "20060102T150405Z"
+
+// backupEnabled reports whether overwritten and deleted files should be moved aside
+func (s *Syncer) backupEnabled() bool {
+	return s.Backup == true || s.BackupDir != ""
+}
+
+// backupRoot returns the backup location without the per run timestamp, either as an s3:// URL or
+// an absolute local path
+func (s *Syncer) backupRoot() string {
+	var dir string
+
+	dir = s.BackupDir
+	if dir == "" {
+		dir = DefaultBackupDir
+	}
+
+	if strings.HasPrefix(dir, "s3://") || filepath.IsAbs(dir) {
+		return strings.TrimSuffix(dir, "/")
+	}
+
+	if s.Differ.DestinationType == "s3" {
+		return "s3://" + path.Join(s.Differ.DestinationBucket, dir)
+	}
+
+	return filepath.Join(s.Differ.DestinationPath, dir)
+}
+
+// backupLocation returns where a destination file is moved to for this run
+func (s *Syncer) backupLocation(destination string) string {
+	var (
+		key  string
+		root string
+		u    *url.URL
+	)
+
+	root = s.backupRoot() + "/" + s.backupTime.Format(backupTimeFormat)
+
+	if s.Differ.DestinationType == "s3" {
+		u, _ = url.Parse(destination)
+		key = strings.TrimLeft(u.Path, "/")
+		return root + "/" + key + s.Suffix
+	}
+
+	key, _ = filepath.Rel(s.Differ.DestinationPath, destination)
+	return filepath.Join(root, key) + s.Suffix
+}
+
+// backupIgnore returns the backup location relative to the destination when it lies inside it, so
+// the diff does not treat earlier backups as extraneous files
+func (s *Syncer) backupIgnore() []string {
+	var (
+		destination string
+		err         error
+		relative    string
+		root        string
+	)
+
+	root = s.backupRoot()
+
+	if s.Differ.DestinationType == "s3" {
+		destination = "s3://" + path.Join(s.Differ.DestinationBucket, s.Differ.DestinationPath) + "/"
+		if strings.HasPrefix(root+"/", destination) {
+			return []string{strings.TrimPrefix(root, destination) + "/"}
+		}
+		return nil
+	}
+
+	relative, err = filepath.Rel(s.Differ.DestinationPath, root)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		return nil
+	}
+	return []string{filepath.ToSlash(relative) + "/"}
+}
+
+// backupOverwritten moves aside the destination file a job is about to replace
+func (s *Syncer) backupOverwritten(job s3diff.SyncItem) error {
+	if s.backupEnabled() == false || job.DestinationState == nil || job.DestinationState.Exists == false {
+		return nil
+	}
+	return s.backup(job.Destination)
+}
+
+// backup copies an s3 object to its backup location, or renames a local file into it. The caller
+// is responsible for removing the original object. A backed up object keeps the storage class
+// and encryption of the original rather than taking those of the sync.
+func (s *Syncer) backup(destination string) error {
+	var (
+		backup *url.URL
+		err    error
+		head   *s3.HeadObjectOutput
+		source *url.URL
+		target string
+	)
+
+	target = s.backupLocation(destination)
+
+	if s.Differ.DestinationType == "s3" {
+		source, err = url.Parse(destination)
+		if err != nil {
+			return err
+		}
+		backup, err = url.Parse(target)
+		if err != nil {
+			return err
+		}
+		sourceKey := strings.TrimLeft(source.Path, "/")
+
+		head, err = s.headObject(source.Hostname(), sourceKey, "", s.sseCustomerKey)
+		if err != nil {
+			return fmt.Errorf("unable to back up to %s: %s", target, err)
+		}
+
+		input := &s3.CopyObjectInput{
+			Bucket:               aws.String(backup.Hostname()),
+			CopySource:           aws.String(copySource(source.Hostname(), sourceKey)),
+			Key:                  aws.String(strings.TrimLeft(backup.Path, "/")),
+			ServerSideEncryption: head.ServerSideEncryption,
+			SSEKMSKeyId:          head.SSEKMSKeyId,
+			StorageClass:         head.StorageClass,
+		}
+		if head.SSECustomerAlgorithm != nil {
+			input.CopySourceSSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+			input.CopySourceSSECustomerKey = aws.String(s.sseCustomerKey)
+			input.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+			input.SSECustomerKey = aws.String(s.sseCustomerKey)
+		}
+
+		_, err = s.copyObject(input, aws.Int64Value(head.ContentLength))
+		if err != nil {
+			return fmt.Errorf("unable to back up to %s: %s", target, err)
+		}
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err == nil {
+		err = os.Rename(destination, target)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to back up to %s: %s", target, err)
+	}
+	return nil
+}
+
+// PruneBackups removes every backup run under the backup location that is older than retention
+func (s *Syncer) PruneBackups(retention time.Duration) error {
+	var (
+		cutoff time.Time
+		err    error
+		root   string
+		runs   []string
+	)
+
+	// Only the destination matters when pruning
+	if s.Source == "" {
+		s.Source = s.Destination
+	}
+
+	err = s.connect()
+	if err != nil {
+		return err
+	}
+
+	s.Differ = &s3diff.Differ{
+		Source:      s.Source,
+		Destination: s.Destination,
+		S3:          s.S3,
+	}
+	err = s.Differ.DetermineTypes()
+	if err != nil {
+		return err
+	}
+
+	root = s.backupRoot()
+	cutoff = time.Now().UTC().Add(-retention)
+
+	runs, err = s.listBackupRuns(root)
+	if err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		created, err := time.Parse(backupTimeFormat, run)
+		if err != nil || created.After(cutoff) {
+			continue
+		}
+
+		location := root + "/" + run
+		if s.Dryrun == true {
+			dryrun(fmt.Sprintf("prune: %s", location))
+			continue
+		}
+
+		fmt.Printf("prune: %s\n", location)
+		err = s.removeBackupRun(location)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listBackupRuns returns the names of the per run directories under the backup root
+func (s *Syncer) listBackupRuns(root string) ([]string, error) {
+	var (
+		err   error
+		infos []os.FileInfo
+		runs  []string
+		u     *url.URL
+	)
+
+	if strings.HasPrefix(root, "s3://") {
+		u, err = url.Parse(root)
+		if err != nil {
+			return nil, err
+		}
+		prefix := strings.TrimLeft(u.Path, "/") + "/"
+		err = s.S3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+			Bucket:    aws.String(u.Hostname()),
+			Delimiter: aws.String("/"),
+			Prefix:    aws.String(prefix),
+		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, p := range page.CommonPrefixes {
+				runs = append(runs, strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(p.Prefix), prefix), "/"))
+			}
+			return true
+		})
+		return runs, err
+	}
+
+	if pathExists(root) == false {
+		return nil, nil
+	}
+
+	infos, err = ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			runs = append(runs, info.Name())
+		}
+	}
+	return runs, nil
+}
+
+// removeBackupRun deletes everything under a single backup run
+func (s *Syncer) removeBackupRun(location string) error {
+	var (
+		batch  []*s3.ObjectIdentifier
+		err    error
+		failed int
+		u      *url.URL
+	)
+
+	if strings.HasPrefix(location, "s3://") == false {
+		return os.RemoveAll(location)
+	}
+
+	u, err = url.Parse(location)
+	if err != nil {
+		return err
+	}
+	bucket := u.Hostname()
+
+	err = s.S3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(strings.TrimLeft(u.Path, "/") + "/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			batch = append(batch, &s3.ObjectIdentifier{Key: obj.Key})
+			if len(batch) == deleteBatchSize {
+				failed += len(s.deleteObjects(bucket, batch))
+				batch = nil
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		failed += len(s.deleteObjects(bucket, batch))
+	}
+	if failed > 0 {
+		return fmt.Errorf("unable to delete %d object(s) under %s", failed, location)
+	}
+	return nil
+}
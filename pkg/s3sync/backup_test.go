@@ -0,0 +1,132 @@
+package s3sync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// backupSyncer returns a Syncer backing up from s3://bucket/data with upload options of its own
+// that backups must not pick up
+func backupSyncer(f *fakeS3) *Syncer {
+	return &Syncer{
+		Backup:       true,
+		Differ:       &s3diff.Differ{DestinationBucket: "bucket", DestinationPath: "data", DestinationType: "s3"},
+		S3:           f.client(),
+		SSE:          SSEKMS,
+		StorageClass: "GLACIER",
+		backupTime:   time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestBackupKeepsStorageClassAndEncryption(t *testing.T) {
+	f := newFakeS3()
+	defer f.close()
+	f.put("bucket/data/small", &fakeObject{body: []byte("small"), headers: map[string]string{
+		"X-Amz-Server-Side-Encryption": "AES256",
+		"X-Amz-Storage-Class":          "STANDARD_IA",
+	}})
+
+	err := backupSyncer(f).backup("s3://bucket/data/small")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copies := f.received("PUT", "")
+	if len(copies) != 1 {
+		t.Fatalf("made %d copies, want 1", len(copies))
+	}
+	header := copies[0].header
+	if class := header.Get("X-Amz-Storage-Class"); class != "STANDARD_IA" {
+		t.Errorf("backup storage class = %q, want STANDARD_IA", class)
+	}
+	if sse := header.Get("X-Amz-Server-Side-Encryption"); sse != "AES256" {
+		t.Errorf("backup encryption = %q, want AES256", sse)
+	}
+	if f.get("bucket/.trash/20260102T030405Z/data/small") == nil {
+		t.Errorf("the backup was not created")
+	}
+}
+
+func TestBackupLargeObject(t *testing.T) {
+	const size = maxCopySize + copyPartSize*3/2
+
+	f := newFakeS3()
+	defer f.close()
+	f.put("bucket/data/large", &fakeObject{
+		headers: map[string]string{
+			"Cache-Control":       "no-cache",
+			"X-Amz-Meta-Owner":    "someone",
+			"X-Amz-Storage-Class": "STANDARD_IA",
+		},
+		size: size,
+		tags: map[string]string{"team": "storage"},
+	})
+
+	err := backupSyncer(f).backup("s3://bucket/data/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if copies := f.received("PUT", "partNumber"); len(f.received("PUT", "")) != len(copies) {
+		t.Errorf("CopyObject was used for an object larger than %d bytes", int64(maxCopySize))
+	}
+
+	creates := f.received("POST", "uploads")
+	if len(creates) != 1 {
+		t.Fatalf("started %d multipart uploads, want 1", len(creates))
+	}
+	header := creates[0].header
+	for name, want := range map[string]string{
+		"Cache-Control":       "no-cache",
+		"X-Amz-Meta-Owner":    "someone",
+		"X-Amz-Storage-Class": "STANDARD_IA",
+		"X-Amz-Tagging":       "team=storage",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("multipart upload %s = %q, want %q", name, got, want)
+		}
+	}
+	if sse := header.Get("X-Amz-Server-Side-Encryption"); sse != "" {
+		t.Errorf("multipart upload encryption = %q, want the original's", sse)
+	}
+
+	parts := f.received("PUT", "partNumber")
+	want := int((size + copyPartSize - 1) / copyPartSize)
+	if len(parts) != want {
+		t.Fatalf("copied %d parts, want %d", len(parts), want)
+	}
+	last := parts[len(parts)-1].header.Get("X-Amz-Copy-Source-Range")
+	if wantRange := fmt.Sprintf("bytes=%d-%d", int64(want-1)*copyPartSize, int64(size-1)); last != wantRange {
+		t.Errorf("last part range = %q, want %q", last, wantRange)
+	}
+
+	if len(f.received("POST", "uploadId")) != 1 {
+		t.Errorf("the multipart upload was not completed")
+	}
+}
+
+func TestSplitCopySource(t *testing.T) {
+	tests := []struct {
+		source  string
+		bucket  string
+		key     string
+		version string
+	}{
+		{source: copySource("bucket", "a/b c+d.txt"), bucket: "bucket", key: "a/b c+d.txt"},
+		{source: copySource("bucket", "k") + "?versionId=v%2B1", bucket: "bucket", key: "k", version: "v+1"},
+	}
+
+	for _, test := range tests {
+		bucket, key, version, err := splitCopySource(test.source)
+		if err != nil {
+			t.Errorf("splitCopySource(%q) returned %s", test.source, err)
+			continue
+		}
+		if bucket != test.bucket || key != test.key || version != test.version {
+			t.Errorf("splitCopySource(%q) = %q, %q, %q, want %q, %q, %q", test.source, bucket, key, version, test.bucket, test.key, test.version)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package s3sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// The values of the Bisync conflict policy, which decides what happens to a file changed on both sides
+const (
+	// ConflictKeepBoth moves the B copy aside under a .conflict name and syncs both copies to each side
+	ConflictKeepBoth = "keep-both"
+	// ConflictNewerWins keeps the copy with the later modification time
+	ConflictNewerWins = "newer-wins"
+	// ConflictSourceWins keeps the A copy
+	ConflictSourceWins = "source-wins"
+)
+
+// conflictSuffix is added to the name of the B copy of a conflicting file with ConflictKeepBoth
+const conflictSuffix = ".conflict"
+
+// BisyncState records what every file looked like on both sides at the end of the last bisync, so
+// the next one can tell which side changed. A is the Source and B the Destination.
+type BisyncState struct {
+	A     string                `json:"a"`
+	B     string                `json:"b"`
+	Files map[string]BisyncFile `json:"files"`
+}
+
+// BisyncFile is the last synced state of one file on each side
+type BisyncFile struct {
+	A *s3diff.State `json:"a"`
+	B *s3diff.State `json:"b"`
+}
+
+// bisyncConflict is a keep-both conflict. The B copy is moved aside first and the copies are only
+// synced if that worked, so neither side is overwritten.
+type bisyncConflict struct {
+	move s3diff.SyncItem
+	toA  s3diff.SyncItem
+	toB  s3diff.SyncItem
+}
+
+// bisyncPlan is everything a bisync will do, in each direction
+type bisyncPlan struct {
+	conflicts []bisyncConflict
+	deletesA  []s3diff.SyncItem
+	deletesB  []s3diff.SyncItem
+	toA       []s3diff.SyncItem
+	toB       []s3diff.SyncItem
+}
+
+// validateBisync checks the options that can't be used with Bisync
+func (s *Syncer) validateBisync(policy string) error {
+	switch policy {
+	case ConflictKeepBoth, ConflictNewerWins, ConflictSourceWins:
+	default:
+		return fmt.Errorf("invalid conflict policy %q, must be one of %s, %s or %s", policy, ConflictNewerWins, ConflictSourceWins, ConflictKeepBoth)
+	}
+
+	if s.AllVersions == true || s.AsOf.IsZero() == false {
+		return fmt.Errorf("the AllVersions and AsOf options can't be used with Bisync")
+	}
+	if s.backupEnabled() == true {
+		return fmt.Errorf("the Backup option can't be used with Bisync")
+	}
+	if s.Dedup == true || s.DetectRenames == true {
+		return fmt.Errorf("the Dedup and DetectRenames options can't be used with Bisync")
+	}
+
+	return nil
+}
+
+// Bisync syncs the Source (A) and the Destination (B) in both directions. Each side is compared
+// with the state saved at statePath by the last run to find what changed since, and creates,
+// updates and deletes are copied to the other side. A file changed differently on both sides is
+// resolved with policy. The first run, without a state, only copies files missing on one side and
+// treats files that differ as conflicts. Deletes are skipped if any transfer failed and are
+// subject to the same safeguards as a sync. The state is not saved on a dry run.
+func (s *Syncer) Bisync(statePath string, policy string) error {
+	var (
+		err     error
+		reverse *Syncer
+	)
+
+	if policy == "" {
+		policy = ConflictNewerWins
+	}
+
+	err = s.validateBisync(policy)
+	if err != nil {
+		return err
+	}
+
+	err = s.connect()
+	if err != nil {
+		return err
+	}
+
+	err = s.init()
+	if err != nil {
+		return err
+	}
+
+	reverse, err = s.reversed()
+	if err != nil {
+		return err
+	}
+
+	// Both sides are written to, so with Lock both are locked
+	return s.withLock(func() error {
+		return reverse.withLock(func() error {
+			return s.bisync(reverse, statePath, policy)
+		})
+	})
+}
+
+// bisync runs a bisync once both sides are set up
+func (s *Syncer) bisync(reverse *Syncer, statePath string, policy string) error {
+	var (
+		err   error
+		plan  bisyncPlan
+		state *BisyncState
+	)
+
+	state, err = readBisyncState(statePath)
+	if err != nil {
+		return err
+	}
+	if state.A != "" && (state.A != s.Source || state.B != s.Destination) {
+		return fmt.Errorf("the bisync state %s is for %s and %s", statePath, state.A, state.B)
+	}
+
+	err = s.Differ.Diff()
+	if err != nil {
+		return fmt.Errorf("unable to build the file list: %s", err)
+	}
+
+	plan = s.bisyncPlan(reverse, state, policy)
+
+	err = s.runBisync(reverse, plan)
+	if err != nil {
+		return err
+	}
+
+	if s.Dryrun == true {
+		return nil
+	}
+
+	err = s.Differ.Diff()
+	if err != nil {
+		return fmt.Errorf("unable to build the file list, the bisync state was not updated: %s", err)
+	}
+
+	return WriteJSONFile(statePath, s.bisyncState(state))
+}
+
+// reversed returns a copy of the Syncer that syncs from the Destination to the Source
+func (s *Syncer) reversed() (*Syncer, error) {
+	reverse := *s
+	reverse.Source, reverse.Destination = s.Destination, s.Source
+	reverse.SSECustomerKey, reverse.SSECustomerSourceKey = s.SSECustomerSourceKey, s.SSECustomerKey
+	reverse.sseCustomerKey, reverse.sseCustomerSourceKey = s.sseCustomerSourceKey, s.sseCustomerKey
+
+	differ := *s.Differ
+	differ.Source, differ.Destination = s.Destination, s.Source
+	differ.SourceBucket, differ.DestinationBucket, differ.DestinationRoot = "", "", ""
+	differ.SourceIgnore, differ.DestinationIgnore = s.Differ.DestinationIgnore, s.Differ.SourceIgnore
+	err := differ.DetermineTypes()
+	if err != nil {
+		return nil, err
+	}
+	reverse.Differ = &differ
+
+	reverse.SourceBucket = differ.SourceBucket
+
+	return &reverse, nil
+}
+
+// bisyncPlan compares every file found on either side with its saved state
+func (s *Syncer) bisyncPlan(reverse *Syncer, state *BisyncState, policy string) bisyncPlan {
+	var (
+		names map[string]bool
+		plan  bisyncPlan
+	)
+
+	names = make(map[string]bool)
+	for name := range s.Differ.SourceList {
+		names[name] = true
+	}
+	for name := range s.Differ.DestinationList {
+		names[name] = true
+	}
+
+	for _, name := range sortedNames(names) {
+		var (
+			a      *s3diff.FileInfo
+			b      *s3diff.FileInfo
+			savedA *s3diff.State
+			savedB *s3diff.State
+		)
+
+		if obj, ok := s.Differ.SourceList[name]; ok {
+			a = &obj
+		}
+		if obj, ok := s.Differ.DestinationList[name]; ok {
+			b = &obj
+		}
+		if saved, ok := state.Files[name]; ok {
+			savedA, savedB = saved.A, saved.B
+		}
+
+		changedA := changedSince(s.Differ.SourceType, a, savedA)
+		changedB := changedSince(s.Differ.DestinationType, b, savedB)
+
+		switch {
+		case changedA == false && changedB == false:
+		case changedA && changedB == false:
+			if a != nil {
+				plan.toB = append(plan.toB, s.Differ.TransferItem(*a, b, transferReason(b)))
+			} else if b != nil {
+				plan.deletesB = append(plan.deletesB, s.Differ.DeleteItem(*b))
+			}
+		case changedB && changedA == false:
+			if b != nil {
+				plan.toA = append(plan.toA, reverse.Differ.TransferItem(*b, a, transferReason(a)))
+			} else if a != nil {
+				plan.deletesA = append(plan.deletesA, reverse.Differ.DeleteItem(*a))
+			}
+		// Changed on both sides. A file deleted on one side and changed on the other is kept.
+		case a == nil && b == nil:
+		case a == nil:
+			plan.toA = append(plan.toA, reverse.Differ.TransferItem(*b, nil, s3diff.ReasonNew))
+		case b == nil:
+			plan.toB = append(plan.toB, s.Differ.TransferItem(*a, nil, s3diff.ReasonNew))
+		// Files whose md5s can't be compared are resolved by policy, since a matching size alone
+		// doesn't make them the same
+		case s.comparableContent(a, b) && s.sameContent(a, b):
+		default:
+			s.resolveConflict(reverse, &plan, name, *a, *b, policy)
+		}
+	}
+
+	return plan
+}
+
+// resolveConflict adds what policy does with a file changed differently on both sides to plan
+func (s *Syncer) resolveConflict(reverse *Syncer, plan *bisyncPlan, name string, a s3diff.FileInfo, b s3diff.FileInfo, policy string) {
+	switch {
+	case policy == ConflictKeepBoth:
+		moved := s.conflictFile(b)
+		fmt.Printf("conflict: %s changed on both sides, keeping the %s copy as %s\n", name, s.Destination, moved.Name)
+		plan.conflicts = append(plan.conflicts, bisyncConflict{
+			move: s.Differ.MoveItem(moved, b),
+			toA:  reverse.Differ.TransferItem(moved, nil, s3diff.ReasonNew),
+			toB:  s.Differ.TransferItem(a, nil, s3diff.ReasonNew),
+		})
+	case policy == ConflictNewerWins && b.ModTime.After(a.ModTime):
+		fmt.Printf("conflict: %s changed on both sides, keeping the newer copy from %s\n", name, s.Destination)
+		plan.toA = append(plan.toA, reverse.Differ.TransferItem(b, &a, s3diff.ReasonContentChanged))
+	default:
+		fmt.Printf("conflict: %s changed on both sides, keeping the copy from %s\n", name, s.Source)
+		plan.toB = append(plan.toB, s.Differ.TransferItem(a, &b, s3diff.ReasonContentChanged))
+	}
+}
+
+// conflictFile returns b as it will be after being moved to a free .conflict name on its side
+func (s *Syncer) conflictFile(b s3diff.FileInfo) s3diff.FileInfo {
+	var moved s3diff.FileInfo
+
+	moved = b
+	moved.Name = b.Name + conflictSuffix
+	for i := 2; s.nameTaken(moved.Name); i++ {
+		moved.Name = fmt.Sprintf("%s%s-%d", b.Name, conflictSuffix, i)
+	}
+
+	if s.Differ.DestinationType == "s3" {
+		moved.Key = strings.TrimSuffix(b.Key, b.Name) + moved.Name
+	} else {
+		moved.Path = strings.TrimSuffix(b.Path, filepath.FromSlash(b.Name)) + filepath.FromSlash(moved.Name)
+	}
+
+	return moved
+}
+
+// nameTaken reports whether a file by that name exists on either side
+func (s *Syncer) nameTaken(name string) bool {
+	_, inA := s.Differ.SourceList[name]
+	_, inB := s.Differ.DestinationList[name]
+	return inA || inB
+}
+
+// runBisync moves conflicting files aside, runs the transfers in both directions and then the
+// deletes on both sides
+func (s *Syncer) runBisync(reverse *Syncer, plan bisyncPlan) error {
+	var (
+		err     error
+		failed  int
+		summary transferSummary
+		total   int
+	)
+
+	for _, conflict := range plan.conflicts {
+		if s.Dryrun == true {
+			dryrun(s.message(conflict.move))
+		} else {
+			fmt.Println(s.message(conflict.move))
+			if s.Differ.DestinationType == "s3" {
+				err = s.move(conflict.move)
+			} else {
+				err = s.rename(conflict.move)
+			}
+			if err != nil {
+				fmt.Printf("failed to move %s to %s: %s\n", conflict.move.Source, conflict.move.Destination, err)
+				failed++
+				continue
+			}
+		}
+		plan.toA = append(plan.toA, conflict.toA)
+		plan.toB = append(plan.toB, conflict.toB)
+	}
+
+	summary = s.transfer(itemChannel(plan.toB))
+	failed += summary.failed
+	total += summary.total
+
+	summary = reverse.transfer(itemChannel(plan.toA))
+	failed += summary.failed
+	total += summary.total
+
+	if failed > 0 {
+		if len(plan.deletesA) > 0 || len(plan.deletesB) > 0 {
+			fmt.Printf("skipping %d delete(s) because of the failures\n", len(plan.deletesA)+len(plan.deletesB))
+		}
+		return fmt.Errorf("%d of %d transfer(s) failed", failed, total+len(plan.conflicts))
+	}
+
+	// Each side's deletes are checked against the other side, which is where they come from
+	if len(plan.deletesB) > 0 {
+		err = s.checkDeletes(plan.deletesB, len(s.Differ.SourceList), len(s.Differ.DestinationList))
+		if err != nil {
+			return err
+		}
+		failed += len(s.deleteFiles(plan.deletesB))
+	}
+	if len(plan.deletesA) > 0 {
+		err = reverse.checkDeletes(plan.deletesA, len(s.Differ.DestinationList), len(s.Differ.SourceList))
+		if err != nil {
+			return err
+		}
+		failed += len(reverse.deleteFiles(plan.deletesA))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d delete(s) failed", failed, len(plan.deletesA)+len(plan.deletesB))
+	}
+
+	if total == 0 && len(plan.conflicts) == 0 && len(plan.deletesA) == 0 && len(plan.deletesB) == 0 {
+		fmt.Println("bisync status: OK")
+	}
+
+	return nil
+}
+
+// bisyncState returns the state to save after a bisync. Files that are the same on both sides are
+// recorded as they are now, and files that are still out of sync keep their old state so the next
+// run sees the same changes again. Files whose md5s can't be compared are recorded when their sizes
+// match, since the state is only saved once every conflict between them has been resolved.
+func (s *Syncer) bisyncState(previous *BisyncState) *BisyncState {
+	var state *BisyncState
+
+	state = &BisyncState{A: s.Source, B: s.Destination, Files: make(map[string]BisyncFile)}
+
+	for name, saved := range previous.Files {
+		_, inA := s.Differ.SourceList[name]
+		_, inB := s.Differ.DestinationList[name]
+		if inA || inB {
+			state.Files[name] = saved
+		}
+	}
+
+	for name, a := range s.Differ.SourceList {
+		a := a
+		if b, ok := s.Differ.DestinationList[name]; ok && s.sameContent(&a, &b) {
+			state.Files[name] = BisyncFile{
+				A: s3diff.FileState(s.Differ.SourceType, &a),
+				B: s3diff.FileState(s.Differ.DestinationType, &b),
+			}
+		}
+	}
+
+	return state
+}
+
+// comparableContent reports whether both files have an md5 of their content, which multipart and
+// opaque ETags are not
+func (s *Syncer) comparableContent(a *s3diff.FileInfo, b *s3diff.FileInfo) bool {
+	return a.MD5 != "" && b.MD5 != "" && s.Differ.OpaqueETags == false && strings.Contains(a.MD5, "-") == false && strings.Contains(b.MD5, "-") == false
+}
+
+// sameContent compares two files by md5 when comparableContent, otherwise by size
+func (s *Syncer) sameContent(a *s3diff.FileInfo, b *s3diff.FileInfo) bool {
+	if a.Size != b.Size {
+		return false
+	}
+	if s.comparableContent(a, b) == false {
+		return true
+	}
+	return a.MD5 == b.MD5
+}
+
+// changedSince reports whether a file was created, changed or removed since it was saved. A file
+// that was not in the state has changed if it exists now.
+func changedSince(pathType string, obj *s3diff.FileInfo, saved *s3diff.State) bool {
+	if saved == nil || saved.Exists == false {
+		return obj != nil
+	}
+	if obj == nil {
+		return true
+	}
+
+	current := s3diff.FileState(pathType, obj)
+	return current.Size != saved.Size || current.ETag != saved.ETag || current.ModTime.Equal(saved.ModTime) == false
+}
+
+// transferReason is the Reason for copying a file over existing, which is nil if there is none
+func transferReason(existing *s3diff.FileInfo) s3diff.Reason {
+	if existing == nil {
+		return s3diff.ReasonNew
+	}
+	return s3diff.ReasonContentChanged
+}
+
+// itemChannel returns a closed channel holding items
+func itemChannel(items []s3diff.SyncItem) <-chan s3diff.SyncItem {
+	channel := make(chan s3diff.SyncItem, len(items))
+	for _, item := range items {
+		channel <- item
+	}
+	close(channel)
+	return channel
+}
+
+// sortedNames returns the keys of names in order
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// readBisyncState reads the state saved by an earlier bisync, or returns an empty one
+func readBisyncState(path string) (*BisyncState, error) {
+	var state BisyncState
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &BisyncState{Files: make(map[string]BisyncFile)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the bisync state %s: %s", path, err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]BisyncFile)
+	}
+
+	return &state, nil
+}
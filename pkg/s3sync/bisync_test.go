@@ -0,0 +1,215 @@
+package s3sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+var (
+	bisyncEarlier = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	bisyncLater   = bisyncEarlier.Add(time.Hour)
+)
+
+// bisyncSyncers returns a Syncer between s3://bucket/a (A) and /b (B) holding the given listings,
+// and its reverse
+func bisyncSyncers(t *testing.T, a []s3diff.FileInfo, b []s3diff.FileInfo) (*Syncer, *Syncer) {
+	s := &Syncer{Source: "s3://bucket/a", Destination: "/b", Differ: &s3diff.Differ{Source: "s3://bucket/a", Destination: "/b"}}
+	err := s.Differ.DetermineTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Differ.SourceList = make(map[string]s3diff.FileInfo)
+	for _, obj := range a {
+		obj.Key = "a/" + obj.Name
+		s.Differ.SourceList[obj.Name] = obj
+	}
+	s.Differ.DestinationList = make(map[string]s3diff.FileInfo)
+	for _, obj := range b {
+		obj.Path = "/b/" + obj.Name
+		s.Differ.DestinationList[obj.Name] = obj
+	}
+
+	reverse, err := s.reversed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, reverse
+}
+
+// planSummary returns what a plan does to each name, as "to A", "to B", "delete A", "delete B"
+// or "keep both"
+func planSummary(plan bisyncPlan) map[string]string {
+	summary := make(map[string]string)
+	for _, item := range plan.toA {
+		summary[item.Destination[len("s3://bucket/a/"):]] = "to A"
+	}
+	for _, item := range plan.toB {
+		summary[item.Destination[len("/b/"):]] = "to B"
+	}
+	for _, item := range plan.deletesA {
+		summary[item.Destination[len("s3://bucket/a/"):]] = "delete A"
+	}
+	for _, item := range plan.deletesB {
+		summary[item.Destination[len("/b/"):]] = "delete B"
+	}
+	for _, conflict := range plan.conflicts {
+		summary[conflict.toB.Destination[len("/b/"):]] = "keep both"
+	}
+	return summary
+}
+
+func TestBisyncPlan(t *testing.T) {
+	var (
+		savedA = &s3diff.State{Exists: true, ETag: "abc", Size: 3}
+		savedB = &s3diff.State{Exists: true, ModTime: bisyncEarlier, Size: 3}
+		same   = s3diff.FileInfo{Size: 3, MD5: "abc", ModTime: bisyncEarlier}
+	)
+
+	tests := []struct {
+		name  string
+		a     *s3diff.FileInfo
+		b     *s3diff.FileInfo
+		saved *BisyncFile
+		want  string
+	}{
+		{name: "unchanged", a: &same, b: &same, saved: &BisyncFile{A: savedA, B: savedB}},
+		{name: "changed on A", a: &s3diff.FileInfo{Size: 4, MD5: "def"}, b: &same, saved: &BisyncFile{A: savedA, B: savedB}, want: "to B"},
+		{name: "changed on B", a: &same, b: &s3diff.FileInfo{Size: 3, MD5: "def", ModTime: bisyncLater}, saved: &BisyncFile{A: savedA, B: savedB}, want: "to A"},
+		{name: "deleted on A", b: &same, saved: &BisyncFile{A: savedA, B: savedB}, want: "delete B"},
+		{name: "deleted on B", a: &same, saved: &BisyncFile{A: savedA, B: savedB}, want: "delete A"},
+		{name: "new on A", a: &same, want: "to B"},
+		{name: "new on B", b: &same, want: "to A"},
+		{name: "deleted on A and changed on B", b: &s3diff.FileInfo{Size: 3, MD5: "def", ModTime: bisyncLater}, saved: &BisyncFile{A: savedA, B: savedB}, want: "to A"},
+		{name: "deleted on both", saved: &BisyncFile{A: savedA, B: savedB}},
+		{name: "same change on both", a: &s3diff.FileInfo{Size: 4, MD5: "def"}, b: &s3diff.FileInfo{Size: 4, MD5: "def", ModTime: bisyncLater}, saved: &BisyncFile{A: savedA, B: savedB}},
+		{name: "same on first run", a: &same, b: &same},
+		{name: "different changes", a: &s3diff.FileInfo{Size: 4, MD5: "def"}, b: &s3diff.FileInfo{Size: 4, MD5: "ghi", ModTime: bisyncLater}, saved: &BisyncFile{A: savedA, B: savedB}, want: "keep both"},
+		{name: "different on first run", a: &same, b: &s3diff.FileInfo{Size: 3, MD5: "def"}, want: "keep both"},
+		{name: "multipart etag", a: &s3diff.FileInfo{Size: 4, MD5: "def-2"}, b: &s3diff.FileInfo{Size: 4, MD5: "ghi", ModTime: bisyncLater}, saved: &BisyncFile{A: savedA, B: savedB}, want: "keep both"},
+		{name: "missing md5", a: &s3diff.FileInfo{Size: 4, MD5: "def"}, b: &s3diff.FileInfo{Size: 4, ModTime: bisyncLater}, saved: &BisyncFile{A: savedA, B: savedB}, want: "keep both"},
+	}
+
+	for _, test := range tests {
+		var a, b []s3diff.FileInfo
+
+		if test.a != nil {
+			obj := *test.a
+			obj.Name = "file"
+			a = append(a, obj)
+		}
+		if test.b != nil {
+			obj := *test.b
+			obj.Name = "file"
+			b = append(b, obj)
+		}
+		state := &BisyncState{Files: make(map[string]BisyncFile)}
+		if test.saved != nil {
+			state.Files["file"] = *test.saved
+		}
+
+		s, reverse := bisyncSyncers(t, a, b)
+		got := planSummary(s.bisyncPlan(reverse, state, ConflictKeepBoth))["file"]
+		if got != test.want {
+			t.Errorf("%s: plan = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBisyncPlanOpaqueETags(t *testing.T) {
+	s, reverse := bisyncSyncers(t,
+		[]s3diff.FileInfo{{Name: "file", Size: 3, MD5: "abc"}},
+		[]s3diff.FileInfo{{Name: "file", Size: 3, MD5: "abc", ModTime: bisyncLater}},
+	)
+	s.Differ.OpaqueETags = true
+
+	got := planSummary(s.bisyncPlan(reverse, &BisyncState{Files: make(map[string]BisyncFile)}, ConflictSourceWins))["file"]
+	if got != "to B" {
+		t.Errorf("plan = %q, want the conflict resolved to B", got)
+	}
+}
+
+func TestBisyncConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		aTime  time.Time
+		bTime  time.Time
+		taken  bool
+		want   string
+	}{
+		{policy: ConflictSourceWins, aTime: bisyncEarlier, bTime: bisyncLater, want: "to B"},
+		{policy: ConflictNewerWins, aTime: bisyncEarlier, bTime: bisyncLater, want: "to A"},
+		{policy: ConflictNewerWins, aTime: bisyncLater, bTime: bisyncEarlier, want: "to B"},
+		{policy: ConflictNewerWins, aTime: bisyncLater, bTime: bisyncLater, want: "to B"},
+		{policy: ConflictKeepBoth, aTime: bisyncEarlier, bTime: bisyncLater, want: "file.conflict"},
+		{policy: ConflictKeepBoth, aTime: bisyncEarlier, bTime: bisyncLater, taken: true, want: "file.conflict-2"},
+	}
+
+	for _, test := range tests {
+		a := []s3diff.FileInfo{{Name: "file", Size: 3, MD5: "abc", ModTime: test.aTime}}
+		b := []s3diff.FileInfo{{Name: "file", Size: 3, MD5: "def", ModTime: test.bTime}}
+		if test.taken {
+			b = append(b, s3diff.FileInfo{Name: "file.conflict", Size: 1, MD5: "ghi"})
+		}
+		s, reverse := bisyncSyncers(t, a, b)
+		plan := s.bisyncPlan(reverse, &BisyncState{Files: make(map[string]BisyncFile)}, test.policy)
+
+		if test.policy != ConflictKeepBoth {
+			if got := planSummary(plan)["file"]; got != test.want {
+				t.Errorf("%s, A at %s, B at %s: plan = %q, want %q", test.policy, test.aTime.Format(time.Kitchen), test.bTime.Format(time.Kitchen), got, test.want)
+			}
+			continue
+		}
+
+		if len(plan.conflicts) != 1 {
+			t.Errorf("%s: %d conflicts, want 1", test.policy, len(plan.conflicts))
+			continue
+		}
+		conflict := plan.conflicts[0]
+		if conflict.move.Source != "/b/file" || conflict.move.Destination != "/b/"+test.want {
+			t.Errorf("%s: moves %s to %s, want /b/file to /b/%s", test.policy, conflict.move.Source, conflict.move.Destination, test.want)
+		}
+		if conflict.toA.Source != "/b/"+test.want || conflict.toA.Destination != "s3://bucket/a/"+test.want {
+			t.Errorf("%s: copies %s to %s, want the moved copy to A", test.policy, conflict.toA.Source, conflict.toA.Destination)
+		}
+		if conflict.toB.Source != "s3://bucket/a/file" || conflict.toB.Destination != "/b/file" {
+			t.Errorf("%s: copies %s to %s, want the A copy to B", test.policy, conflict.toB.Source, conflict.toB.Destination)
+		}
+	}
+}
+
+func TestBisyncState(t *testing.T) {
+	s, _ := bisyncSyncers(t,
+		[]s3diff.FileInfo{
+			{Name: "differs", Size: 3, MD5: "abc"},
+			{Name: "multipart", Size: 3, MD5: "abc-2"},
+			{Name: "only-a", Size: 3, MD5: "abc"},
+			{Name: "same", Size: 3, MD5: "abc"},
+		},
+		[]s3diff.FileInfo{
+			{Name: "differs", Size: 3, MD5: "def"},
+			{Name: "multipart", Size: 3, MD5: "def"},
+			{Name: "same", Size: 3, MD5: "abc"},
+		},
+	)
+	previous := &BisyncState{Files: map[string]BisyncFile{
+		"differs": {A: &s3diff.State{Exists: true, ETag: "old", Size: 3}},
+		"gone":    {A: &s3diff.State{Exists: true, ETag: "old", Size: 3}},
+	}}
+
+	state := s.bisyncState(previous)
+
+	for name, want := range map[string]string{"differs": "old", "multipart": "abc-2", "same": "abc"} {
+		if file, ok := state.Files[name]; ok == false || file.A.ETag != want {
+			t.Errorf("%s saved as %+v, want the A ETag %q", name, file.A, want)
+		}
+	}
+	for _, name := range []string{"gone", "only-a"} {
+		if _, ok := state.Files[name]; ok {
+			t.Errorf("%s was saved", name)
+		}
+	}
+}
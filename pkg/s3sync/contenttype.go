@@ -0,0 +1,165 @@
+package s3sync
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// defaultContentType is used when no resolver recognizes a file
+const defaultContentType = "application/octet-stream"
+
+// ContentTypeResolver decides the Content-Type of an uploaded or copied object. name is the key
+// relative to the source and path is the local file, which is empty for s3 to s3 copies. An empty
+// result passes the file on to the next resolver in the chain.
+type ContentTypeResolver interface {
+	ContentType(name string, path string) (string, error)
+}
+
+// ContentTypeFunc adapts a function to a ContentTypeResolver
+type ContentTypeFunc func(name string, path string) (string, error)
+
+// ContentType calls f
+func (f ContentTypeFunc) ContentType(name string, path string) (string, error) {
+	return f(name, path)
+}
+
+// ContentTypeRule sets the Content-Type of every object whose key matches Pattern
+type ContentTypeRule struct {
+	Pattern     string
+	ContentType string
+}
+
+// fixedContentType resolves every file to the same type
+type fixedContentType string
+
+func (t fixedContentType) ContentType(name string, path string) (string, error) {
+	return string(t), nil
+}
+
+// patternContentTypes resolves files by the first ContentTypeRule they match
+type patternContentTypes []patternContentType
+
+type patternContentType struct {
+	contentType string
+	pattern     *regexp.Regexp
+}
+
+func (p patternContentTypes) ContentType(name string, path string) (string, error) {
+	for _, rule := range p {
+		if rule.pattern.MatchString(name) {
+			return rule.contentType, nil
+		}
+	}
+	return "", nil
+}
+
+// extensionContentTypes resolves files by extension, first from a loaded mime.types file and then
+// from the table built into the mime package
+type extensionContentTypes map[string]string
+
+func (e extensionContentTypes) ContentType(name string, _ string) (string, error) {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return "", nil
+	}
+	if contentType, ok := e[ext]; ok {
+		return contentType, nil
+	}
+	return mime.TypeByExtension(ext), nil
+}
+
+// sniffContentType resolves local files by their content
+func sniffContentType(name string, path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	mt, err := mimetype.DetectFile(path)
+	if err != nil {
+		return "", err
+	}
+	return mt.String(), nil
+}
+
+// validateContentTypes builds the resolver chains. Every file goes through ContentType, then
+// ContentTypeResolvers, then ContentTypeRules, then the extension table, then content sniffing and
+// finally the default. s3 to s3 copies keep the type of the source object unless one of the first
+// three sets it, since there is no local file to look at.
+func (s *Syncer) validateContentTypes() error {
+	var (
+		err        error
+		extensions extensionContentTypes
+		rules      patternContentTypes
+	)
+
+	for _, rule := range s.ContentTypeRules {
+		pattern, err := compilePattern(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid content type pattern %q: %s", rule.Pattern, err)
+		}
+		rules = append(rules, patternContentType{contentType: rule.ContentType, pattern: pattern})
+	}
+
+	extensions = make(extensionContentTypes)
+	if s.MimeTypes != "" {
+		err = loadMimeTypes(s.MimeTypes, extensions)
+		if err != nil {
+			return fmt.Errorf("unable to load %s: %s", s.MimeTypes, err)
+		}
+	}
+
+	s.copyContentTypes = nil
+	if s.ContentType != "" {
+		s.copyContentTypes = append(s.copyContentTypes, fixedContentType(s.ContentType))
+	}
+	s.copyContentTypes = append(s.copyContentTypes, s.ContentTypeResolvers...)
+	s.copyContentTypes = append(s.copyContentTypes, rules)
+
+	s.contentTypes = append([]ContentTypeResolver{}, s.copyContentTypes...)
+	s.contentTypes = append(s.contentTypes, extensions, ContentTypeFunc(sniffContentType), fixedContentType(defaultContentType))
+
+	return nil
+}
+
+// resolveContentType returns the first type a resolver in chain gives for a file. Resolver errors
+// are skipped so that an unreadable file still gets a type from the rest of the chain.
+func resolveContentType(chain []ContentTypeResolver, name string, path string) string {
+	for _, resolver := range chain {
+		contentType, err := resolver.ContentType(name, path)
+		if err == nil && contentType != "" {
+			return contentType
+		}
+	}
+	return ""
+}
+
+// loadMimeTypes adds the extensions in a mime.types file, lines of "type ext ext ...", to extensions
+func loadMimeTypes(file string, extensions extensionContentTypes) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, ext := range fields[1:] {
+			if strings.HasPrefix(ext, "#") {
+				break
+			}
+			extensions["."+strings.ToLower(ext)] = fields[0]
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,106 @@
+package s3sync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveContentType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"mime.types": "# type extensions\ntext/x-notes notes NOTE # trailing comment\ntext/plain\n",
+		"page":       "<!DOCTYPE html><html><body>hello</body></html>",
+		"blob":       "\x00\x01\x02\x03",
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Syncer{
+		ContentTypeResolvers: []ContentTypeResolver{
+			ContentTypeFunc(func(name string, path string) (string, error) {
+				switch name {
+				case "special.html":
+					return "application/x-special", nil
+				case "docs/broken.txt":
+					return "application/x-broken", fmt.Errorf("unreadable")
+				}
+				return "", nil
+			}),
+		},
+		ContentTypeRules: []ContentTypeRule{
+			{Pattern: "docs/*.txt", ContentType: "text/markdown"},
+			{Pattern: "docs/**", ContentType: "text/x-docs"},
+		},
+		MimeTypes: filepath.Join(dir, "mime.types"),
+	}
+	err = s.validateContentTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+		// wantCopy is the type of an s3 to s3 copy, which has no local file
+		wantCopy string
+	}{
+		{name: "special.html", want: "application/x-special", wantCopy: "application/x-special"},
+		{name: "docs/broken.txt", want: "text/markdown", wantCopy: "text/markdown"},
+		{name: "docs/readme.txt", want: "text/markdown", wantCopy: "text/markdown"},
+		{name: "docs/index.html", want: "text/x-docs", wantCopy: "text/x-docs"},
+		{name: "todo.notes", want: "text/x-notes"},
+		{name: "TODO.NOTE", want: "text/x-notes"},
+		{name: "index.html", want: "text/html; charset=utf-8"},
+		{name: "page", path: "page", want: "text/html; charset=utf-8"},
+		{name: "blob", path: "blob", want: defaultContentType},
+		{name: "missing", path: "missing", want: defaultContentType},
+	}
+
+	for _, test := range tests {
+		path := ""
+		if test.path != "" {
+			path = filepath.Join(dir, test.path)
+		}
+		if got := resolveContentType(s.contentTypes, test.name, path); got != test.want {
+			t.Errorf("%s: Content-Type %q, want %q", test.name, got, test.want)
+		}
+		if got := resolveContentType(s.copyContentTypes, test.name, ""); got != test.wantCopy {
+			t.Errorf("%s: copied with Content-Type %q, want %q", test.name, got, test.wantCopy)
+		}
+	}
+
+	// ContentType comes before everything else
+	s.ContentType = "text/plain"
+	err = s.validateContentTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		if got := resolveContentType(s.contentTypes, test.name, ""); got != "text/plain" {
+			t.Errorf("%s: Content-Type %q with ContentType set, want text/plain", test.name, got)
+		}
+	}
+}
+
+func TestValidateContentTypesMissingMimeTypes(t *testing.T) {
+	s := &Syncer{MimeTypes: "/nonexistent/mime.types"}
+
+	err := s.validateContentTypes()
+	if err == nil || strings.Contains(err.Error(), "/nonexistent/mime.types") == false {
+		t.Errorf("validateContentTypes = %v, want the missing mime.types reported", err)
+	}
+}
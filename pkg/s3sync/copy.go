@@ -0,0 +1,189 @@
+package s3sync
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// copy performs a server side copy of a single object to job.Destination, in parts when it is
+// larger than maxCopySize
+func (s *Syncer) copy(job s3diff.SyncItem) error {
+	var (
+		destination       *url.URL
+		destinationBucket string
+		destinationKey    string
+		err               error
+		mimeType          string
+		name              string
+		source            *url.URL
+		sourceBucket      string
+		sourceFile        string
+		sourceKey         string
+	)
+
+	source, err = url.Parse(job.Source)
+	if err != nil {
+		return err
+	}
+	sourceBucket = source.Hostname()
+	sourceKey = strings.TrimLeft(source.Path, string(os.PathSeparator))
+	sourceFile = copySource(sourceBucket, sourceKey)
+	if job.VersionID != "" {
+		sourceFile += "?versionId=" + url.QueryEscape(job.VersionID)
+	}
+
+	destination, err = url.Parse(job.Destination)
+	if err != nil {
+		return err
+	}
+	destinationBucket = destination.Hostname()
+	destinationKey = strings.TrimLeft(destination.Path, string(os.PathSeparator))
+
+	err = s.backupOverwritten(job)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:     &destinationBucket,
+		CopySource: &sourceFile,
+		Key:        &destinationKey,
+	}
+	s.copyOptions(input, s.sseCustomerSourceKey)
+
+	// The source's Content-Type and user metadata are kept unless they are set explicitly. Headers,
+	// the Content-Type and metadata can only be changed by replacing the metadata, which has to be
+	// read from the source first.
+	name = s.relativeName(job)
+	mimeType = resolveContentType(s.copyContentTypes, name, "")
+	if mimeType != "" || len(s.headersFor(name)) > 0 || len(s.Metadata) > 0 {
+		head, err := s.headObject(sourceBucket, sourceKey, job.VersionID, s.sseCustomerSourceKey)
+		if err != nil {
+			return err
+		}
+		replaceMetadata(input, head)
+		s.copyHeaders(input, name)
+		if mimeType != "" {
+			input.ContentType = &mimeType
+		}
+		input.Metadata = awsMetadata(mergeMaps(metadataMap(head.Metadata), s.Metadata))
+	}
+
+	// Tags are copied as they are unless more are added, in which case the source's tags have to be
+	// fetched and merged since the REPLACE directive drops them
+	input.TaggingDirective = aws.String(s3.TaggingDirectiveCopy)
+	if len(s.Tags) > 0 {
+		tags, err := s.desiredTags(sourceBucket, sourceKey, job.VersionID, nil)
+		if err != nil {
+			return err
+		}
+		input.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+		input.Tagging = aws.String(encodeTags(tags))
+	}
+
+	err = s.withACL(func() error {
+		s.copyACL(input)
+		_, err := s.copyObject(input, job.Size)
+		return err
+	})
+	if err == nil && s.PreserveACL == true {
+		err = s.preserveACL(sourceBucket, sourceKey, versionID(job), destinationBucket, destinationKey)
+	}
+
+	return err
+}
+
+// update copies a destination object onto itself so it moves to StorageClass and picks up the
+// headers, tags and metadata it should have without the content being transferred again. Everything
+// else about the object, including its storage class and encryption when those options are not
+// set, is kept as it is.
+func (s *Syncer) update(job s3diff.SyncItem) error {
+	var (
+		destination       *url.URL
+		destinationBucket string
+		destinationKey    string
+		err               error
+		head              *s3.HeadObjectOutput
+		metadata          map[string]string
+		source            *url.URL
+		sourceBucket      string
+		sourceKey         string
+		tags              map[string]string
+	)
+
+	destination, err = url.Parse(job.Destination)
+	if err != nil {
+		return err
+	}
+	destinationBucket = destination.Hostname()
+	destinationKey = strings.TrimLeft(destination.Path, "/")
+
+	head, err = s.headObject(destinationBucket, destinationKey, "", s.sseCustomerKey)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:               aws.String(destinationBucket),
+		CopySource:           aws.String(copySource(destinationBucket, destinationKey)),
+		Key:                  aws.String(destinationKey),
+		ServerSideEncryption: head.ServerSideEncryption,
+		SSEKMSKeyId:          head.SSEKMSKeyId,
+		StorageClass:         head.StorageClass,
+	}
+	replaceMetadata(input, head)
+	s.copyHeaders(input, s.relativeName(job))
+	s.copyOptions(input, s.sseCustomerKey)
+
+	if s.Differ.SourceType == "s3" {
+		source, err = url.Parse(job.Source)
+		if err != nil {
+			return err
+		}
+		sourceBucket = source.Hostname()
+		sourceKey = strings.TrimLeft(source.Path, "/")
+	}
+
+	metadata, err = s.desiredMetadata(sourceBucket, sourceKey, job.VersionID, metadataMap(head.Metadata))
+	if err != nil {
+		return err
+	}
+	input.Metadata = awsMetadata(metadata)
+
+	if s.CompareTags == true || len(s.Tags) > 0 {
+		tags, err = s.objectTags(destinationBucket, destinationKey, "")
+		if err == nil {
+			tags, err = s.desiredTags(sourceBucket, sourceKey, job.VersionID, tags)
+		}
+		if err != nil {
+			return err
+		}
+		input.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+		input.Tagging = aws.String(encodeTags(tags))
+	}
+
+	err = s.withACL(func() error {
+		s.copyACL(input)
+		_, err := s.copyObject(input, aws.Int64Value(head.ContentLength))
+		return err
+	})
+	if err == nil && s.PreserveACL == true {
+		err = s.preserveACL(sourceBucket, sourceKey, versionID(job), destinationBucket, destinationKey)
+	}
+
+	return err
+}
+
+// copySource returns the URL encoded bucket/key expected by CopyObjectInput.CopySource
+func copySource(bucket string, key string) string {
+	var parts []string
+	for _, part := range strings.Split(key, "/") {
+		parts = append(parts, url.PathEscape(part))
+	}
+	return bucket + "/" + strings.Join(parts, "/")
+}
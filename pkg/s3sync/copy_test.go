@@ -0,0 +1,52 @@
+package s3sync
+
+import (
+	"testing"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+func TestCopyLargeObjects(t *testing.T) {
+	const size = maxCopySize + 1
+
+	tests := []struct {
+		name string
+		run  func(s *Syncer) error
+	}{
+		{
+			name: "copy",
+			run: func(s *Syncer) error {
+				return s.copy(s3diff.SyncItem{Source: "s3://source/data/large", Destination: "s3://destination/data/large", Size: size})
+			},
+		},
+		{
+			name: "update",
+			run: func(s *Syncer) error {
+				s.StorageClass = "STANDARD_IA"
+				return s.update(s3diff.SyncItem{Source: "s3://source/data/large", Destination: "s3://destination/data/large", Size: size})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		f := newFakeS3()
+		f.put("source/data/large", &fakeObject{size: size})
+		f.put("destination/data/large", &fakeObject{size: size})
+
+		s := &Syncer{
+			Differ: &s3diff.Differ{SourcePath: "data", SourceType: "s3", DestinationPath: "data", DestinationType: "s3"},
+			S3:     f.client(),
+		}
+		err := test.run(s)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if parts := f.received("PUT", "partNumber"); len(parts) == 0 || len(parts) != len(f.received("PUT", "")) {
+			t.Errorf("%s: CopyObject was used for an object larger than %d bytes", test.name, int64(maxCopySize))
+		}
+		if len(f.received("POST", "uploadId")) != 1 {
+			t.Errorf("%s: the multipart copy was not completed", test.name)
+		}
+		f.close()
+	}
+}
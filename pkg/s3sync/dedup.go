@@ -0,0 +1,150 @@
+package s3sync
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// validateDedup checks the Dedup and Hardlink options
+func (s *Syncer) validateDedup() error {
+	if s.Hardlink == true && s.Dedup == false {
+		return fmt.Errorf("the Hardlink option requires Dedup")
+	}
+	return nil
+}
+
+// copyDuplicate produces an upload from the object its duplicate was uploaded to with a server
+// side copy. The object gets the Content-Type, headers and file metadata of its own local file.
+func (s *Syncer) copyDuplicate(job s3diff.SyncItem) error {
+	var (
+		destinationBucket string
+		destinationKey    string
+		err               error
+		info              os.FileInfo
+		mimeType          string
+		name              string
+		originalKey       string
+	)
+
+	_, originalKey, err = splitS3URL(job.DuplicateOf)
+	if err != nil {
+		return err
+	}
+	destinationBucket, destinationKey, err = splitS3URL(job.Destination)
+	if err != nil {
+		return err
+	}
+
+	info, err = os.Stat(job.Source)
+	if err != nil {
+		return err
+	}
+
+	err = s.backupOverwritten(job)
+	if err != nil {
+		return err
+	}
+
+	name = s.relativeName(job)
+	mimeType = resolveContentType(s.contentTypes, name, job.Source)
+
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(destinationBucket),
+		ContentType:       aws.String(mimeType),
+		CopySource:        aws.String(copySource(destinationBucket, originalKey)),
+		Key:               aws.String(destinationKey),
+		Metadata:          fileMetadata(info),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	}
+	for key, value := range s.Metadata {
+		input.Metadata[key] = aws.String(value)
+	}
+	s.copyOptions(input, s.sseCustomerKey)
+	s.copyHeaders(input, name)
+
+	return s.withACL(func() error {
+		s.copyACL(input)
+		_, err := s.S3.CopyObject(input)
+		return err
+	})
+}
+
+// linkDuplicate produces a download from the file its duplicate was downloaded to, either by
+// copying it or, with Hardlink, by linking to it. Linked files share the attributes of the first
+// download, so Preserve is only applied to copies.
+func (s *Syncer) linkDuplicate(job s3diff.SyncItem) error {
+	var (
+		destinationDirectory string
+		err                  error
+		f                    *os.File
+		head                 *s3.HeadObjectOutput
+		original             *os.File
+		sourceBucket         string
+		sourceKey            string
+		target               string
+	)
+
+	destinationDirectory = filepath.Dir(job.Destination)
+	err = os.MkdirAll(destinationDirectory, 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err = ioutil.TempFile(destinationDirectory, tempPrefix+filepath.Base(job.Destination)+"-*")
+	if err != nil {
+		return err
+	}
+	target = f.Name()
+
+	if s.Hardlink == true {
+		f.Close()
+		err = os.Remove(target)
+		if err == nil {
+			err = os.Link(job.DuplicateOf, target)
+		}
+	} else {
+		original, err = os.Open(job.DuplicateOf)
+		if err == nil {
+			_, err = io.Copy(f, original)
+			original.Close()
+		}
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = s.verifyDownload(target, job)
+		}
+		if err == nil && len(s.Preserve) > 0 {
+			sourceBucket, sourceKey, err = splitS3URL(job.Source)
+			if err == nil {
+				head, err = s.headObject(sourceBucket, sourceKey, job.VersionID, s.sseCustomerSourceKey)
+			}
+			if err == nil {
+				err = s.applyMetadata(target, head)
+			}
+		}
+	}
+
+	if err == nil {
+		err = s.backupOverwritten(job)
+	}
+	if err == nil {
+		err = os.Rename(target, job.Destination)
+	}
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package s3sync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// requestsFor returns the requests made with method for path
+func requestsFor(f *fakeS3, method string, path string) []fakeRequest {
+	var found []fakeRequest
+	for _, r := range f.received(method, "") {
+		if r.path == path {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func TestDedupUploadCopiesDuplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.txt", "b.html"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("same"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := newFakeS3()
+	defer f.close()
+
+	s := &Syncer{Dedup: true, Destination: "s3://bucket/data", MaxThreads: 1, S3: f.client(), Source: dir}
+	s.Uploader = s3manager.NewUploaderWithClient(s.S3)
+	diffItems(t, s)
+	_, err = s.syncFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if puts := requestsFor(f, "PUT", "/bucket/data/a.txt"); len(puts) != 1 || puts[0].header.Get("X-Amz-Copy-Source") != "" {
+		t.Errorf("a.txt was not uploaded")
+	}
+	copies := requestsFor(f, "PUT", "/bucket/data/b.html")
+	if len(copies) != 1 || copies[0].header.Get("X-Amz-Copy-Source") != "bucket/data/a.txt" {
+		t.Fatalf("b.html was not copied from a.txt")
+	}
+	if directive := copies[0].header.Get("X-Amz-Metadata-Directive"); directive != "REPLACE" {
+		t.Errorf("b.html was copied with the %q metadata directive, want REPLACE", directive)
+	}
+	if contentType := copies[0].header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+		t.Errorf("b.html was copied with Content-Type %q, want its own", contentType)
+	}
+	if object := f.get("bucket/data/b.html"); object == nil || string(object.body) != "same" {
+		t.Errorf("b.html = %v, want the content of a.txt", object)
+	}
+}
+
+func TestDedupDownloadLinksDuplicates(t *testing.T) {
+	tests := []struct {
+		name     string
+		hardlink bool
+	}{
+		{name: "copy"},
+		{name: "hardlink", hardlink: true},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "s3sync-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		f := newFakeS3()
+		defer f.close()
+		f.put("bucket/data/a", &fakeObject{body: []byte("same"), headers: map[string]string{}})
+		f.put("bucket/data/b", &fakeObject{body: []byte("same"), headers: map[string]string{}})
+
+		s := &Syncer{Dedup: true, Destination: dir, Hardlink: test.hardlink, MaxThreads: 1, S3: f.client(), Source: "s3://bucket/data"}
+		s.Downloader = s3manager.NewDownloaderWithClient(s.S3)
+		diffItems(t, s)
+		_, err = s.syncFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(requestsFor(f, "GET", "/bucket/data/b")) > 0 {
+			t.Errorf("%s: b was downloaded instead of produced from a", test.name)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "b"))
+		if err != nil || string(data) != "same" {
+			t.Errorf("%s: b = %q, %v, want the content of a", test.name, data, err)
+		}
+
+		a, errA := os.Stat(filepath.Join(dir, "a"))
+		b, errB := os.Stat(filepath.Join(dir, "b"))
+		if errA != nil || errB != nil {
+			t.Fatalf("%s: %v, %v", test.name, errA, errB)
+		}
+		if os.SameFile(a, b) != test.hardlink {
+			t.Errorf("%s: a and b are the same file = %t, want %t", test.name, os.SameFile(a, b), test.hardlink)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package s3sync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// deleteBatchSize is the most keys DeleteObjects accepts in one request
+const deleteBatchSize = 1000
+
+// deleteSummaryLimit is the number of files listed when asking for confirmation
+const deleteSummaryLimit = 20
+
+// checkDeletes applies the deletion safeguards before anything is removed. The whole delete phase is
+// refused if the source was empty, if MaxDelete or MaxDeletePercent would be exceeded, or if the
+// user does not confirm when Confirm is set.
+func (s *Syncer) checkDeletes(deletes []s3diff.SyncItem, sourceCount int, destinationCount int) error {
+	var percent float64
+
+	if sourceCount == 0 {
+		return fmt.Errorf("refusing to delete %d file(s) because the source listing is empty", len(deletes))
+	}
+
+	if s.MaxDelete > 0 && len(deletes) > s.MaxDelete {
+		return fmt.Errorf("refusing to delete %d file(s), which is more than --max-delete %d", len(deletes), s.MaxDelete)
+	}
+
+	if s.MaxDeletePercent > 0 && destinationCount > 0 {
+		percent = float64(len(deletes)) * 100 / float64(destinationCount)
+		if percent > s.MaxDeletePercent {
+			return fmt.Errorf("refusing to delete %d of %d file(s) (%.1f%%), which is more than --max-delete-percent %g", len(deletes), destinationCount, percent, s.MaxDeletePercent)
+		}
+	}
+
+	if s.Confirm == true && s.Dryrun == false {
+		if confirm(deletes, destinationCount) == false {
+			return fmt.Errorf("delete phase cancelled")
+		}
+	}
+
+	return nil
+}
+
+// confirm prints a summary of the pending deletes and waits for the user to answer y
+func confirm(deletes []s3diff.SyncItem, destinationCount int) bool {
+	var (
+		answer string
+		size   int64
+	)
+
+	for _, job := range deletes {
+		size += job.Size
+	}
+
+	fmt.Printf("about to delete %d of %d file(s) (%d bytes) from the destination:\n", len(deletes), destinationCount, size)
+	for i, job := range deletes {
+		if i == deleteSummaryLimit {
+			fmt.Printf("  ... and %d more\n", len(deletes)-deleteSummaryLimit)
+			break
+		}
+		fmt.Printf("  %s\n", job.Destination)
+	}
+	fmt.Print("continue? [y/N] ")
+
+	answer, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// deleteFiles removes files that only exist on the destination, moving them to the backup location
+// first when backups are enabled. S3 objects are removed in batches. It returns the files that
+// could not be deleted, which is every file when the lock on the destination was lost.
+func (s *Syncer) deleteFiles(fileList []s3diff.SyncItem) []s3diff.SyncItem {
+	var (
+		batches map[string][]s3diff.SyncItem
+		err     error
+		failed  []s3diff.SyncItem
+		job     s3diff.SyncItem
+	)
+
+	if s.lockLost() == true {
+		fmt.Printf("skipping %d delete(s) because the lock on the destination was lost\n", len(fileList))
+		return fileList
+	}
+
+	batches = make(map[string][]s3diff.SyncItem)
+
+	for _, job = range fileList {
+		if s.Dryrun == true {
+			dryrun(s.message(job))
+			continue
+		}
+
+		fmt.Println(s.message(job))
+		if s.backupEnabled() == true {
+			err = s.backup(job.Destination)
+			if err != nil {
+				fmt.Printf("failed to delete %s: %s\n", job.Destination, err)
+				failed = append(failed, job)
+				continue
+			}
+		}
+
+		if job.Bucket != "" {
+			batches[job.Bucket] = append(batches[job.Bucket], job)
+			if len(batches[job.Bucket]) == deleteBatchSize {
+				failed = append(failed, s.deleteBatch(job.Bucket, batches[job.Bucket])...)
+				batches[job.Bucket] = nil
+			}
+		} else if s.backupEnabled() == false {
+			err = os.Remove(job.Path)
+			if err != nil {
+				fmt.Printf("failed to delete %s: %s\n", job.Path, err)
+				failed = append(failed, job)
+			}
+		}
+	}
+
+	for bucket, jobs := range batches {
+		if len(jobs) > 0 {
+			failed = append(failed, s.deleteBatch(bucket, jobs)...)
+		}
+	}
+
+	return failed
+}
+
+// deleteBatch removes the objects of up to deleteBatchSize delete jobs from a bucket and returns
+// the jobs whose objects could not be deleted
+func (s *Syncer) deleteBatch(bucket string, jobs []s3diff.SyncItem) []s3diff.SyncItem {
+	var (
+		failed    []s3diff.SyncItem
+		objects   []*s3.ObjectIdentifier
+		undeleted map[string]bool
+	)
+
+	for _, job := range jobs {
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(job.Key)})
+	}
+
+	undeleted = make(map[string]bool)
+	for _, key := range s.deleteObjects(bucket, objects) {
+		undeleted[key] = true
+	}
+	for _, job := range jobs {
+		if undeleted[job.Key] {
+			failed = append(failed, job)
+		}
+	}
+
+	return failed
+}
+
+// deleteObjects removes up to deleteBatchSize objects from a bucket in a single request and returns
+// the keys that could not be deleted
+func (s *Syncer) deleteObjects(bucket string, objects []*s3.ObjectIdentifier) []string {
+	var failed []string
+
+	resp, err := s.S3.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{
+			Objects: objects,
+			Quiet:   aws.Bool(true),
+		},
+	})
+	if err != nil {
+		fmt.Printf("failed to delete %d object(s) from %s: %s\n", len(objects), bucket, err)
+		for _, object := range objects {
+			failed = append(failed, aws.StringValue(object.Key))
+		}
+		return failed
+	}
+
+	for _, failure := range resp.Errors {
+		fmt.Printf("failed to delete s3://%s/%s: %s\n", bucket, aws.StringValue(failure.Key), aws.StringValue(failure.Message))
+		failed = append(failed, aws.StringValue(failure.Key))
+	}
+
+	return failed
+}
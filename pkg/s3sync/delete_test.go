@@ -0,0 +1,44 @@
+package s3sync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+func TestFinishDeletesCountsFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	present := filepath.Join(dir, "present")
+	err = ioutil.WriteFile(present, []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Syncer{DeleteMode: DeleteAfter, Differ: &s3diff.Differ{DestinationType: "local"}}
+	summary := transferSummary{deletes: []s3diff.SyncItem{
+		{Action: s3diff.ActionDelete, Destination: present, Path: present, Size: 1},
+		{Action: s3diff.ActionDelete, Destination: filepath.Join(dir, "missing"), Path: filepath.Join(dir, "missing"), Size: 5},
+	}}
+
+	err = s.finishDeletes(&summary, 1, 2)
+	if err == nil {
+		t.Fatal("finishDeletes succeeded with a delete that failed")
+	}
+	if summary.deleted != 1 || summary.deleteFailed != 1 {
+		t.Errorf("deleted %d and failed %d, want 1 and 1", summary.deleted, summary.deleteFailed)
+	}
+	if summary.deletedBytes != 1 {
+		t.Errorf("deleted %d bytes, want only the 1 byte of the file that was deleted", summary.deletedBytes)
+	}
+	if pathExists(present) {
+		t.Errorf("%s was not deleted", present)
+	}
+}
@@ -0,0 +1,207 @@
+package s3sync
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// tempPrefix is prepended to the name of every in-flight download so orphans can be found later
+const tempPrefix = ".s3sync-tmp-"
+
+// tempCounter keeps the temp file names of concurrent downloads apart
+var tempCounter uint64
+
+// download fetches a single object to job.Destination. Unless Inplace is set, the object is
+// written to a hidden temp file in the destination directory and only renamed into place once
+// it has been flushed to disk and verified, so a failed download never clobbers the existing file.
+func (s *Syncer) download(job s3diff.SyncItem) error {
+	var (
+		destinationDirectory string
+		err                  error
+		f                    *os.File
+		head                 *s3.HeadObjectOutput
+		source               *url.URL
+		sourceBucket         string
+		sourceKey            string
+		target               string
+	)
+
+	source, err = url.Parse(job.Source)
+	if err != nil {
+		return err
+	}
+	sourceBucket = source.Hostname()
+	sourceKey = strings.TrimLeft(source.Path, string(os.PathSeparator))
+	destinationDirectory = filepath.Dir(job.Destination)
+
+	err = os.MkdirAll(destinationDirectory, 0755)
+	if err != nil {
+		return err
+	}
+
+	if s.Inplace == true {
+		err = s.backupOverwritten(job)
+		if err != nil {
+			return err
+		}
+		f, err = os.Create(job.Destination)
+	} else {
+		f, err = createTemp(job.Destination)
+	}
+	if err != nil {
+		return err
+	}
+	target = f.Name()
+
+	input := &s3.GetObjectInput{
+		Bucket: &sourceBucket,
+		Key:    &sourceKey,
+	}
+	input.VersionId = versionID(job)
+	s.getOptions(input)
+
+	_, err = s.Downloader.Download(f, input)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = s.verifyDownload(target, job)
+	}
+	if err == nil && len(s.Preserve) > 0 {
+		head, err = s.headObject(sourceBucket, sourceKey, job.VersionID, s.sseCustomerSourceKey)
+		if err == nil {
+			err = s.applyMetadata(target, head)
+		}
+	}
+
+	if s.Inplace == true {
+		return err
+	}
+
+	if err == nil {
+		err = s.backupOverwritten(job)
+	}
+	if err == nil {
+		err = os.Rename(target, job.Destination)
+	}
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+
+	return nil
+}
+
+// createTemp creates the temp file a download to destination is written to. Unlike ioutil.TempFile,
+// which creates files only the owner can read, it gets the mode of the file it will replace, or
+// the mode os.Create would give it.
+func createTemp(destination string) (*os.File, error) {
+	info, _ := os.Stat(destination)
+
+	for attempt := 0; attempt < 100; attempt++ {
+		suffix := strconv.FormatInt(time.Now().UnixNano(), 36) + strconv.FormatUint(atomic.AddUint64(&tempCounter, 1), 36)
+		name := filepath.Join(filepath.Dir(destination), tempPrefix+filepath.Base(destination)+"-"+suffix)
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The umask applies to a new file, but not to the mode of the file being replaced
+		if info != nil {
+			err = f.Chmod(info.Mode().Perm())
+			if err != nil {
+				f.Close()
+				os.Remove(name)
+				return nil, err
+			}
+		}
+		return f, nil
+	}
+
+	return nil, fmt.Errorf("unable to create a temp file for %s", destination)
+}
+
+// verifyDownload compares the size and, where the ETag is a plain md5, the checksum of a downloaded
+// file. The ETag of an object encrypted with KMS or a customer key is not an md5 either, which is
+// only known after a HEAD, so a checksum mismatch is confirmed against the object before failing.
+func (s *Syncer) verifyDownload(path string, job s3diff.SyncItem) error {
+	var (
+		checksum string
+		err      error
+		head     *s3.HeadObjectOutput
+		info     os.FileInfo
+		source   *url.URL
+	)
+
+	info, err = os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.Size() != job.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", job.Size, info.Size())
+	}
+
+	// Multipart ETags and the ETags of objects encrypted with a customer key are not an md5 of the
+	// content so there is nothing to compare against
+	if job.MD5 == "" || strings.Contains(job.MD5, "-") || s.sseCustomerSourceKey != "" {
+		return nil
+	}
+
+	checksum, err = s3diff.MD5Checksum(path)
+	if err != nil {
+		return err
+	}
+
+	if checksum != job.MD5 {
+		source, err = url.Parse(job.Source)
+		if err == nil {
+			head, err = s.headObject(source.Hostname(), strings.TrimLeft(source.Path, "/"), job.VersionID, s.sseCustomerSourceKey)
+		}
+		if err == nil && aws.StringValue(head.ServerSideEncryption) == SSEKMS {
+			return nil
+		}
+		return fmt.Errorf("md5 mismatch: expected %s, got %s", job.MD5, checksum)
+	}
+
+	return nil
+}
+
+// cleanupTempFiles removes temp files left behind by downloads that were interrupted in a previous run
+func (s *Syncer) cleanupTempFiles(path string) error {
+	if pathExists(path) == false {
+		return nil
+	}
+
+	return filepath.Walk(path, func(item string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() && strings.HasPrefix(info.Name(), tempPrefix) {
+			if s.Dryrun == true {
+				dryrun(fmt.Sprintf("remove orphaned temp file: %s", item))
+			} else {
+				fmt.Printf("remove orphaned temp file: %s\n", item)
+				return os.Remove(item)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+package s3sync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// downloadSyncer returns a Syncer downloading from the fake into dir
+func downloadSyncer(f *fakeS3, dir string) *Syncer {
+	s := &Syncer{
+		Differ: &s3diff.Differ{DestinationPath: dir, DestinationType: "local", SourceBucket: "bucket", SourceType: "s3"},
+		S3:     f.client(),
+	}
+	s.Downloader = s3manager.NewDownloaderWithClient(s.S3)
+	return s
+}
+
+// tempFiles returns the in-flight download files left in dir
+func tempFiles(t *testing.T, dir string) []string {
+	var found []string
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), tempPrefix) {
+			found = append(found, info.Name())
+		}
+	}
+	return found
+}
+
+func TestDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newFakeS3()
+	defer f.close()
+	object := &fakeObject{body: []byte("hello")}
+	f.put("bucket/data/new", object)
+	f.put("bucket/data/existing", object)
+	f.put("bucket/data/corrupt", object)
+
+	// A new file gets the mode os.Create gives, not the 0600 of ioutil.TempFile
+	created, err := os.Create(filepath.Join(dir, "reference"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+	reference, _ := os.Stat(created.Name())
+
+	existing := filepath.Join(dir, "existing")
+	err = ioutil.WriteFile(existing, []byte("old"), 0640)
+	if err == nil {
+		err = os.Chmod(existing, 0640)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := filepath.Join(dir, "corrupt")
+	err = ioutil.WriteFile(corrupt, []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		md5     string
+		mode    os.FileMode
+		content string
+		err     bool
+	}{
+		{name: "new", md5: object.etag(), mode: reference.Mode().Perm(), content: "hello"},
+		{name: "existing", md5: object.etag(), mode: 0640, content: "hello"},
+		// A download that fails verification leaves the file it would replace alone
+		{name: "corrupt", md5: "00000000000000000000000000000000", mode: 0644, content: "old", err: true},
+	}
+
+	s := downloadSyncer(f, dir)
+	for _, test := range tests {
+		destination := filepath.Join(dir, test.name)
+		err = s.download(s3diff.SyncItem{Source: "s3://bucket/data/" + test.name, Destination: destination, MD5: test.md5, Size: 5})
+		if test.err != (err != nil) {
+			t.Errorf("download of %s returned %v", test.name, err)
+		}
+
+		info, err := os.Stat(destination)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if info.Mode().Perm() != test.mode {
+			t.Errorf("%s has mode %s, want %s", test.name, info.Mode().Perm(), test.mode)
+		}
+		if data, _ := ioutil.ReadFile(destination); string(data) != test.content {
+			t.Errorf("%s contains %q, want %q", test.name, data, test.content)
+		}
+	}
+
+	if left := tempFiles(t, dir); len(left) > 0 {
+		t.Errorf("temp files were left behind: %v", left)
+	}
+}
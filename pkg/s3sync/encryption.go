@@ -0,0 +1,163 @@
+package s3sync
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// StorageClasses lists the values accepted by Syncer.StorageClass
+var StorageClasses = []string{
+	"STANDARD",
+	"REDUCED_REDUNDANCY",
+	"STANDARD_IA",
+	"ONEZONE_IA",
+	"INTELLIGENT_TIERING",
+	"GLACIER",
+	"GLACIER_IR",
+	"DEEP_ARCHIVE",
+}
+
+// The values accepted by Syncer.SSE
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+)
+
+// sseCustomerAlgorithm is the only algorithm s3 supports for customer provided keys
+const sseCustomerAlgorithm = "AES256"
+
+// validateEncryption checks the storage class and encryption options and decodes the customer keys
+func (s *Syncer) validateEncryption() error {
+	var (
+		err   error
+		valid bool
+	)
+
+	if s.StorageClass != "" {
+		for _, class := range StorageClasses {
+			if s.StorageClass == class {
+				valid = true
+			}
+		}
+		if valid == false {
+			return fmt.Errorf("invalid StorageClass %q", s.StorageClass)
+		}
+	}
+
+	if s.SSE != "" && s.SSE != SSEAES256 && s.SSE != SSEKMS {
+		return fmt.Errorf("invalid SSE %q, must be %s or %s", s.SSE, SSEAES256, SSEKMS)
+	}
+
+	if s.SSEKMSKeyID != "" && s.SSE != SSEKMS {
+		return fmt.Errorf("the SSEKMSKeyID option requires SSE to be %s", SSEKMS)
+	}
+
+	if s.SSECustomerKey != "" && s.SSE != "" {
+		return fmt.Errorf("the SSECustomerKey and SSE options cannot be used together")
+	}
+
+	s.sseCustomerKey, err = decodeCustomerKey(s.SSECustomerKey)
+	if err != nil {
+		return fmt.Errorf("invalid SSECustomerKey: %s", err)
+	}
+
+	s.sseCustomerSourceKey, err = decodeCustomerKey(s.SSECustomerSourceKey)
+	if err != nil {
+		return fmt.Errorf("invalid SSECustomerSourceKey: %s", err)
+	}
+
+	return nil
+}
+
+// decodeCustomerKey decodes a base64 encoded 256 bit key. The SDK expects the raw key.
+func decodeCustomerKey(key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(raw) != 32 {
+		return "", fmt.Errorf("expected a 256 bit key, got %d bits", len(raw)*8)
+	}
+
+	return string(raw), nil
+}
+
+// opaqueETags reports whether objects written by this sync have ETags that are not an md5 of the content
+func (s *Syncer) opaqueETags() bool {
+	return s.SSE == SSEKMS || s.sseCustomerKey != ""
+}
+
+// uploadOptions sets the storage class and encryption on an upload. The uploader carries the
+// customer key through to every part of a multipart upload.
+func (s *Syncer) uploadOptions(input *s3manager.UploadInput) {
+	if s.StorageClass != "" {
+		input.StorageClass = aws.String(s.StorageClass)
+	}
+	if s.SSE != "" {
+		input.ServerSideEncryption = aws.String(s.SSE)
+	}
+	if s.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.SSEKMSKeyID)
+	}
+	if s.sseCustomerKey != "" {
+		input.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		input.SSECustomerKey = aws.String(s.sseCustomerKey)
+	}
+}
+
+// copyOptions sets the storage class and encryption on a server side copy. sourceKey is the
+// customer key the source object is encrypted with, if any.
+func (s *Syncer) copyOptions(input *s3.CopyObjectInput, sourceKey string) {
+	if s.StorageClass != "" {
+		input.StorageClass = aws.String(s.StorageClass)
+	}
+	if s.SSE != "" {
+		input.ServerSideEncryption = aws.String(s.SSE)
+	}
+	if s.SSEKMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.SSEKMSKeyID)
+	}
+	if s.sseCustomerKey != "" {
+		input.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		input.SSECustomerKey = aws.String(s.sseCustomerKey)
+	}
+	if sourceKey != "" {
+		input.CopySourceSSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		input.CopySourceSSECustomerKey = aws.String(sourceKey)
+	}
+}
+
+// getOptions sets the customer key needed to read a source object
+func (s *Syncer) getOptions(input *s3.GetObjectInput) {
+	if s.sseCustomerSourceKey != "" {
+		input.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		input.SSECustomerKey = aws.String(s.sseCustomerSourceKey)
+	}
+}
+
+// headObject fetches the metadata of an object, passing the customer key it is encrypted with if
+// any. An empty versionID reads the latest version.
+func (s *Syncer) headObject(bucket string, key string, versionID string, customerKey string) (*s3.HeadObjectOutput, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	if customerKey != "" {
+		input.SSECustomerAlgorithm = aws.String(sseCustomerAlgorithm)
+		input.SSECustomerKey = aws.String(customerKey)
+	}
+
+	return s.S3.HeadObject(input)
+}
@@ -0,0 +1,358 @@
+package s3sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// EventMessage is a single S3 event notification, as sent to SQS directly or through SNS
+type EventMessage struct {
+	Body string
+
+	// handle is the SQS receipt handle used to delete the message once it has been applied
+	handle string
+}
+
+// EventSource delivers S3 event notification messages. Receive waits for the next messages and
+// returns io.EOF when there will be no more. Done is called with messages once their keys have
+// been synced, so a source that redelivers messages can stop doing so.
+type EventSource interface {
+	Receive() ([]EventMessage, error)
+	Done(messages []EventMessage) error
+}
+
+// sqsEventSource long polls an SQS queue
+type sqsEventSource struct {
+	client   *sqs.SQS
+	queueURL string
+}
+
+// sqsWaitSeconds is how long a ReceiveMessage call waits for messages to arrive
+const sqsWaitSeconds = 20
+
+// The wait before retrying a failed Receive doubles after each failure, from
+// minReceiveRetryDelay up to maxReceiveRetryDelay
+var (
+	maxReceiveRetryDelay = time.Minute
+	minReceiveRetryDelay = time.Second
+)
+
+// permanentReceiveErrors are the AWS error codes that retrying a Receive won't fix
+var permanentReceiveErrors = map[string]bool{
+	"AccessDenied":                  true,
+	"InvalidClientTokenId":          true,
+	sqs.ErrCodeQueueDoesNotExist:    true,
+	"SignatureDoesNotMatch":         true,
+	sqs.ErrCodeUnsupportedOperation: true,
+}
+
+// NewSQSEventSource returns an EventSource reading from an SQS queue with the Region, Profile and
+// credentials of s. endpoint overrides the SQS endpoint, e.g. for a local SQS compatible server,
+// and is normally empty.
+func (s *Syncer) NewSQSEventSource(queueURL string, endpoint string) (EventSource, error) {
+	config := aws.Config{}
+	if endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	sess, err := s.newSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqsEventSource{client: sqs.New(sess), queueURL: queueURL}, nil
+}
+
+func (e *sqsEventSource) Receive() ([]EventMessage, error) {
+	var messages []EventMessage
+
+	resp, err := e.client.ReceiveMessage(&sqs.ReceiveMessageInput{
+		MaxNumberOfMessages: aws.Int64(10),
+		QueueUrl:            aws.String(e.queueURL),
+		WaitTimeSeconds:     aws.Int64(sqsWaitSeconds),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, message := range resp.Messages {
+		messages = append(messages, EventMessage{Body: aws.StringValue(message.Body), handle: aws.StringValue(message.ReceiptHandle)})
+	}
+
+	return messages, nil
+}
+
+func (e *sqsEventSource) Done(messages []EventMessage) error {
+	var entries []*sqs.DeleteMessageBatchRequestEntry
+
+	for i, message := range messages {
+		entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(fmt.Sprint(i)),
+			ReceiptHandle: aws.String(message.handle),
+		})
+		if len(entries) == 10 || i == len(messages)-1 {
+			resp, err := e.client.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+				Entries:  entries,
+				QueueUrl: aws.String(e.queueURL),
+			})
+			if err != nil {
+				return err
+			}
+			if len(resp.Failed) > 0 {
+				return fmt.Errorf("unable to delete %d message(s) from the queue: %s", len(resp.Failed), aws.StringValue(resp.Failed[0].Message))
+			}
+			entries = nil
+		}
+	}
+
+	return nil
+}
+
+// readerEventSource reads a stream of JSON messages, e.g. from a file or stdin, for testing
+type readerEventSource struct {
+	decoder *json.Decoder
+}
+
+// NewReaderEventSource returns an EventSource reading one message per JSON document from r
+func NewReaderEventSource(r io.Reader) EventSource {
+	return &readerEventSource{decoder: json.NewDecoder(r)}
+}
+
+func (e *readerEventSource) Receive() ([]EventMessage, error) {
+	var message json.RawMessage
+
+	err := e.decoder.Decode(&message)
+	if err != nil {
+		return nil, err
+	}
+
+	return []EventMessage{{Body: string(message)}}, nil
+}
+
+func (e *readerEventSource) Done(messages []EventMessage) error {
+	return nil
+}
+
+// s3Event is the part of an S3 event notification needed to find the keys it affects
+type s3Event struct {
+	// Message holds the notification when it was delivered through SNS
+	Message string `json:"Message"`
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// eventNames returns the names, relative to the Source, of the keys a message reports as created
+// or removed. Keys in other buckets or outside SourcePath, and test events, are left out.
+func (s *Syncer) eventNames(message EventMessage) ([]string, error) {
+	var (
+		event  s3Event
+		names  []string
+		prefix string
+	)
+
+	err := json.Unmarshal([]byte(message.Body), &event)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the event: %s", err)
+	}
+	if event.Message != "" && len(event.Records) == 0 {
+		return s.eventNames(EventMessage{Body: event.Message})
+	}
+
+	if s.Differ.SourcePath != "" {
+		prefix = strings.TrimSuffix(s.Differ.SourcePath, "/") + "/"
+	}
+
+	for _, record := range event.Records {
+		if strings.HasPrefix(record.EventName, "ObjectCreated:") == false && strings.HasPrefix(record.EventName, "ObjectRemoved:") == false {
+			continue
+		}
+		if record.S3.Bucket.Name != s.Differ.SourceBucket {
+			continue
+		}
+
+		// Keys are form encoded in notifications
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in the event: %s", record.S3.Object.Key, err)
+		}
+		if strings.HasPrefix(key, prefix) == false || strings.HasSuffix(key, "/") {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(key, prefix))
+	}
+
+	return names, nil
+}
+
+// Events keeps a local Destination in sync with an s3 Source by applying the keys named in S3
+// event notifications from events, until stop is closed or events has no more messages. The
+// current state of each key is diffed rather than trusting the event, so events that arrive late
+// or out of order do no harm. With reconcile set, a full sync is run at the start and then every
+// reconcile to catch events that were missed. Messages are only marked done once their keys have
+// been synced without errors.
+func (s *Syncer) Events(events EventSource, reconcile time.Duration, stop <-chan struct{}) error {
+	var err error
+
+	// Only a full sync counts the destination, which MaxDeletePercent is measured against
+	if s.MaxDeletePercent > 0 && reconcile <= 0 {
+		return fmt.Errorf("the MaxDeletePercent option needs a reconcile interval when syncing from events")
+	}
+
+	err = s.connect()
+	if err != nil {
+		return err
+	}
+
+	err = s.init()
+	if err != nil {
+		return err
+	}
+
+	if s.Differ.SourceType != "s3" || s.Differ.DestinationType != "local" {
+		return fmt.Errorf("syncing from events needs an s3 Source and a local Destination")
+	}
+	if s.Confirm == true {
+		return fmt.Errorf("the Confirm option can't be used when syncing from events")
+	}
+	if s.DeleteMode == DeleteBefore {
+		s.DeleteMode = DeleteAfter
+	}
+
+	return s.withLock(func() error {
+		return s.applyEvents(events, reconcile, stop)
+	})
+}
+
+// applyEvents syncs the keys named by events until stop is closed or there are no more. Failed
+// Receives are logged and retried with a growing delay unless retrying can't help.
+func (s *Syncer) applyEvents(events EventSource, reconcile time.Duration, stop <-chan struct{}) error {
+	var (
+		batches          chan []EventMessage
+		destinationCount int
+		err              error
+		errs             chan error
+		messages         []EventMessage
+		names            []string
+		reconciles       <-chan time.Time
+	)
+
+	if reconcile > 0 {
+		destinationCount = s.reconcile()
+		ticker := time.NewTicker(reconcile)
+		defer ticker.Stop()
+		reconciles = ticker.C
+	}
+
+	batches = make(chan []EventMessage)
+	errs = make(chan error, 1)
+	minDelay, maxDelay := minReceiveRetryDelay, maxReceiveRetryDelay
+	go func() {
+		delay := minDelay
+		for {
+			batch, err := events.Receive()
+			if err != nil && isRetryableReceiveError(err) {
+				fmt.Printf("unable to receive events, retrying in %s: %s\n", delay, err)
+				select {
+				case <-time.After(delay):
+				case <-stop:
+					return
+				}
+				delay *= 2
+				if delay > maxDelay {
+					delay = maxDelay
+				}
+				continue
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			delay = minDelay
+
+			select {
+			case batches <- batch:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case <-reconciles:
+			destinationCount = s.reconcile()
+
+		case err = <-errs:
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("unable to receive events: %s", err)
+
+		case messages = <-batches:
+			names = nil
+			for _, message := range messages {
+				found, err := s.eventNames(message)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+				names = append(names, found...)
+			}
+
+			if len(names) > 0 {
+				sort.Strings(names)
+				err = s.syncPaths(names, destinationCount)
+				if err != nil {
+					fmt.Println(err)
+					continue
+				}
+			}
+
+			err = events.Done(messages)
+			if err != nil {
+				fmt.Printf("unable to mark %d event(s) as done: %s\n", len(messages), err)
+			}
+		}
+	}
+}
+
+// isRetryableReceiveError reports whether a Receive that failed with err may succeed if retried.
+// AWS errors other than bad credentials, denied access or a missing queue are retried, while
+// io.EOF and errors reading a stream of messages are not.
+func isRetryableReceiveError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && permanentReceiveErrors[aerr.Code()] == false
+}
+
+// reconcile runs a full sync and returns the number of files in the destination
+func (s *Syncer) reconcile() int {
+	fmt.Println("reconciling the destination with a full sync")
+
+	_, err := s.syncFiles()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return s.Differ.DestinationCount
+}
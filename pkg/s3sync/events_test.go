@@ -0,0 +1,316 @@
+package s3sync
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// fakeSQS is just enough of the SQS query API to receive and delete messages
+type fakeSQS struct {
+	authorization string
+	deleted       []string
+	lock          sync.Mutex
+	messages      []string
+	server        *httptest.Server
+}
+
+func newFakeSQS(messages ...string) *fakeSQS {
+	f := &fakeSQS{messages: messages}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeSQS) close() {
+	f.server.Close()
+}
+
+// signedWith returns the Authorization header of the last request
+func (f *fakeSQS) signedWith() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.authorization
+}
+
+// deletedHandles returns the receipt handles of the messages deleted so far
+func (f *fakeSQS) deletedHandles() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.deleted...)
+}
+
+type sqsMessage struct {
+	Body          string `xml:"Body"`
+	MD5OfBody     string `xml:"MD5OfBody"`
+	MessageID     string `xml:"MessageId"`
+	ReceiptHandle string `xml:"ReceiptHandle"`
+}
+
+type sqsResponse struct {
+	XMLName  xml.Name
+	Messages []sqsMessage `xml:"ReceiveMessageResult>Message,omitempty"`
+	Deleted  []string     `xml:"DeleteMessageBatchResult>DeleteMessageBatchResultEntry>Id,omitempty"`
+}
+
+func (f *fakeSQS) serve(w http.ResponseWriter, r *http.Request) {
+	var response sqsResponse
+
+	r.ParseForm()
+
+	f.lock.Lock()
+	f.authorization = r.Header.Get("Authorization")
+	switch r.Form.Get("Action") {
+	case "ReceiveMessage":
+		response.XMLName.Local = "ReceiveMessageResponse"
+		for i, body := range f.messages {
+			sum := md5.Sum([]byte(body))
+			response.Messages = append(response.Messages, sqsMessage{
+				Body:          body,
+				MD5OfBody:     hex.EncodeToString(sum[:]),
+				MessageID:     fmt.Sprint(i),
+				ReceiptHandle: fmt.Sprintf("handle-%d", i),
+			})
+		}
+		f.messages = nil
+	case "DeleteMessageBatch":
+		response.XMLName.Local = "DeleteMessageBatchResponse"
+		for i := 1; r.Form.Get(fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.Id", i)) != ""; i++ {
+			response.Deleted = append(response.Deleted, r.Form.Get(fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.Id", i)))
+			f.deleted = append(f.deleted, r.Form.Get(fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.ReceiptHandle", i)))
+		}
+	default:
+		f.lock.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.lock.Unlock()
+
+	// An empty queue answers at once, not after a long poll
+	if len(response.Messages) == 0 && response.XMLName.Local == "ReceiveMessageResponse" {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+// withProfile points the shared AWS config at a credentials file holding profile, restoring the
+// environment when the returned function is called
+func withProfile(t *testing.T, profile string, accessKey string) func() {
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentials := filepath.Join(dir, "credentials")
+	err = ioutil.WriteFile(credentials, []byte(fmt.Sprintf("[%s]\naws_access_key_id = %s\naws_secret_access_key = secret\n", profile, accessKey)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := make(map[string]string)
+	for name, value := range map[string]string{
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_CONFIG_FILE":             filepath.Join(dir, "config"),
+		"AWS_PROFILE":                 "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+		"AWS_SHARED_CREDENTIALS_FILE": credentials,
+	} {
+		saved[name] = os.Getenv(name)
+		os.Setenv(name, value)
+	}
+
+	return func() {
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSQSEventSourceUsesProfile(t *testing.T) {
+	defer withProfile(t, "events", "EVENTSKEY")()
+
+	f := newFakeSQS(`{"Records": []}`, `{"Records": []}`)
+	defer f.close()
+
+	s := &Syncer{Profile: "events", Region: "us-west-2"}
+	events, err := s.NewSQSEventSource(f.server.URL+"/123456789012/queue", f.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := events.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("received %d message(s), want 2", len(messages))
+	}
+	if signed := f.signedWith(); strings.Contains(signed, "Credential=EVENTSKEY/") == false || strings.Contains(signed, "/us-west-2/sqs/") == false {
+		t.Errorf("request signed with %q, want the events profile in us-west-2", signed)
+	}
+
+	err = events.Done(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted := f.deletedHandles(); strings.Join(deleted, ",") != "handle-0,handle-1" {
+		t.Errorf("deleted %v, want handle-0 and handle-1", deleted)
+	}
+}
+
+func TestApplyEventsFromSQS(t *testing.T) {
+	defer withProfile(t, "default", "DEFAULTKEY")()
+
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	objects := newFakeS3()
+	defer objects.close()
+	objects.put("bucket/data/new+file.txt", &fakeObject{body: []byte("new"), headers: map[string]string{}})
+
+	queue := newFakeSQS(
+		`{"Records": [{"eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "bucket"}, "object": {"key": "data/new%2Bfile.txt"}}}]}`,
+		`{"Records": [{"eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "other"}, "object": {"key": "data/other.txt"}}}]}`,
+	)
+	defer queue.close()
+
+	s := &Syncer{Source: "s3://bucket/data", Destination: dir, S3: objects.client()}
+	s.Downloader = s3manager.NewDownloaderWithClient(s.S3)
+	diffItems(t, s)
+
+	events, err := s.NewSQSEventSource(queue.server.URL+"/123456789012/queue", queue.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.applyEvents(events, 0, stop)
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); len(queue.deletedHandles()) < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(queue.deletedHandles()) != 2 {
+		t.Errorf("deleted %v from the queue, want both messages", queue.deletedHandles())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "new+file.txt"))
+	if err != nil || string(data) != "new" {
+		t.Errorf("new+file.txt = %q, %v, want it downloaded", data, err)
+	}
+}
+
+func TestEventsMaxDeletePercentNeedsReconcile(t *testing.T) {
+	s := &Syncer{MaxDeletePercent: 10}
+
+	err := s.Events(NewReaderEventSource(strings.NewReader("")), 0, nil)
+	if err == nil || strings.Contains(err.Error(), "MaxDeletePercent") == false {
+		t.Errorf("Events = %v, want MaxDeletePercent rejected without a reconcile interval", err)
+	}
+}
+
+// scriptedEventSource returns the errors in order from Receive, then io.EOF
+type scriptedEventSource struct {
+	errs     []error
+	received int
+}
+
+func (e *scriptedEventSource) Receive() ([]EventMessage, error) {
+	e.received++
+	if len(e.errs) == 0 {
+		return nil, io.EOF
+	}
+	err := e.errs[0]
+	e.errs = e.errs[1:]
+	return nil, err
+}
+
+func (e *scriptedEventSource) Done(messages []EventMessage) error {
+	return nil
+}
+
+func TestApplyEventsRetriesReceive(t *testing.T) {
+	defer func(min time.Duration, max time.Duration) {
+		minReceiveRetryDelay, maxReceiveRetryDelay = min, max
+	}(minReceiveRetryDelay, maxReceiveRetryDelay)
+	minReceiveRetryDelay, maxReceiveRetryDelay = time.Millisecond, 2*time.Millisecond
+
+	transient := awserr.New("RequestError", "send request failed", nil)
+
+	tests := []struct {
+		name     string
+		errs     []error
+		received int
+		wantErr  string
+	}{
+		{name: "transient errors", errs: []error{transient, transient, transient}, received: 4},
+		{name: "missing queue", errs: []error{transient, awserr.New(sqs.ErrCodeQueueDoesNotExist, "no queue", nil)}, received: 2, wantErr: "no queue"},
+		{name: "access denied", errs: []error{awserr.New("AccessDenied", "denied", nil)}, received: 1, wantErr: "denied"},
+		{name: "bad message stream", errs: []error{errors.New("invalid character")}, received: 1, wantErr: "invalid character"},
+	}
+
+	for _, test := range tests {
+		events := &scriptedEventSource{errs: test.errs}
+
+		err := (&Syncer{}).applyEvents(events, 0, make(chan struct{}))
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: applyEvents = %v, want the errors retried", test.name, err)
+		case test.wantErr != "" && (err == nil || strings.Contains(err.Error(), test.wantErr) == false):
+			t.Errorf("%s: applyEvents = %v, want %q", test.name, err, test.wantErr)
+		}
+		if events.received != test.received {
+			t.Errorf("%s: Receive called %d times, want %d", test.name, events.received, test.received)
+		}
+	}
+}
+
+func TestApplyEventsStopsWhileRetrying(t *testing.T) {
+	defer func(min time.Duration) {
+		minReceiveRetryDelay = min
+	}(minReceiveRetryDelay)
+	minReceiveRetryDelay = time.Hour
+
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- (&Syncer{}).applyEvents(&scriptedEventSource{errs: []error{awserr.New("RequestError", "send request failed", nil)}}, 0, stop)
+	}()
+
+	close(stop)
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("applyEvents = %v after stop, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("applyEvents kept waiting to retry after stop")
+	}
+}
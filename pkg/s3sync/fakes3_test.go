@@ -0,0 +1,414 @@
+package s3sync
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// fakeObject is an object held by fakeS3. size is reported instead of len(body) when it is set,
+// so large objects can be faked without their content.
+type fakeObject struct {
+	body         []byte
+	headers      map[string]string
+	lastModified time.Time
+	size         int64
+	tags         map[string]string
+}
+
+// fakeRequest is a request fakeS3 received
+type fakeRequest struct {
+	header http.Header
+	method string
+	path   string
+	query  url.Values
+}
+
+// fakeS3 is just enough of the s3 API, served over HTTP with path style addressing, to exercise
+// the Syncer without AWS. date is sent as the Date of every response when it is set, to fake a
+// server clock that differs from the local one.
+type fakeS3 struct {
+	date     time.Time
+	lock     sync.Mutex
+	objects  map[string]*fakeObject
+	requests []fakeRequest
+	server   *httptest.Server
+	uploads  int
+}
+
+// newFakeS3 starts a fakeS3, which the caller closes
+func newFakeS3() *fakeS3 {
+	f := &fakeS3{objects: make(map[string]*fakeObject)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeS3) close() {
+	f.server.Close()
+}
+
+// client returns an s3 client talking to the fake
+func (f *fakeS3) client() *s3.S3 {
+	return s3.New(session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		DisableSSL:       aws.Bool(true),
+		Endpoint:         aws.String(f.server.URL),
+		Region:           aws.String("us-east-1"),
+		S3ForcePathStyle: aws.Bool(true),
+	})))
+}
+
+// put stores an object under bucket/key
+func (f *fakeS3) put(path string, object *fakeObject) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if object.lastModified.IsZero() {
+		object.lastModified = time.Now().UTC().Truncate(time.Second)
+	}
+	f.objects[path] = object
+}
+
+// get returns the object stored under bucket/key, if any
+func (f *fakeS3) get(path string) *fakeObject {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.objects[path]
+}
+
+// received returns the requests made with method whose query has param
+func (f *fakeS3) received(method string, param string) []fakeRequest {
+	var found []fakeRequest
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, r := range f.requests {
+		if _, ok := r.query[param]; r.method == method && (param == "" || ok) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func (o *fakeObject) etag() string {
+	sum := md5.Sum(o.body)
+	return hex.EncodeToString(sum[:])
+}
+
+func (o *fakeObject) length() int64 {
+	if o.size > 0 {
+		return o.size
+	}
+	return int64(len(o.body))
+}
+
+func (f *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	var (
+		bucket string
+		key    string
+	)
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket = parts[0]
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	query := r.URL.Query()
+
+	f.lock.Lock()
+	f.requests = append(f.requests, fakeRequest{header: r.Header.Clone(), method: r.Method, path: r.URL.Path, query: query})
+	if f.date.IsZero() == false {
+		w.Header().Set("Date", f.date.Format(http.TimeFormat))
+	}
+	f.lock.Unlock()
+
+	_, copying := r.Header["X-Amz-Copy-Source"]
+
+	switch {
+	case key == "" && r.Method == "GET":
+		f.list(w, bucket, query.Get("prefix"))
+	case key == "" && r.Method == "POST" && has(query, "delete"):
+		f.deleteObjects(w, r, bucket)
+	case r.Method == "GET" && has(query, "tagging"):
+		f.getTagging(w, bucket+"/"+key)
+	case r.Method == "GET" && has(query, "acl"):
+		writeXML(w, s3Result{XMLName: xml.Name{Local: "AccessControlPolicy"}, OwnerID: "owner"})
+	case r.Method == "PUT" && has(query, "acl"):
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "POST" && has(query, "restore"):
+		f.restore(w, bucket+"/"+key)
+	case r.Method == "POST" && has(query, "uploads"):
+		f.lock.Lock()
+		f.uploads++
+		id := fmt.Sprintf("upload-%d", f.uploads)
+		f.lock.Unlock()
+		writeXML(w, s3Result{XMLName: xml.Name{Local: "InitiateMultipartUploadResult"}, Bucket: bucket, Key: key, UploadID: id})
+	case r.Method == "PUT" && has(query, "partNumber") && copying:
+		writeXML(w, s3Result{XMLName: xml.Name{Local: "CopyPartResult"}, ETag: `"part-` + query.Get("partNumber") + `"`})
+	case r.Method == "POST" && has(query, "uploadId"):
+		f.put(bucket+"/"+key, &fakeObject{headers: map[string]string{}})
+		writeXML(w, s3Result{XMLName: xml.Name{Local: "CompleteMultipartUploadResult"}, Bucket: bucket, Key: key, ETag: `"multipart-1"`})
+	case r.Method == "DELETE" && has(query, "uploadId"):
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT" && copying:
+		f.copyObject(w, r, bucket+"/"+key)
+	case r.Method == "PUT" && f.conflicts(r, bucket+"/"+key):
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", bucket+"/"+key)
+	case r.Method == "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		object := &fakeObject{body: body, headers: map[string]string{}}
+		f.put(bucket+"/"+key, object)
+		w.Header().Set("ETag", `"`+object.etag()+`"`)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "HEAD" || r.Method == "GET":
+		f.getObject(w, r, bucket+"/"+key)
+	case r.Method == "DELETE":
+		f.lock.Lock()
+		delete(f.objects, bucket+"/"+key)
+		f.lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" "+r.URL.String())
+	}
+}
+
+// conflicts reports whether the If-None-Match or If-Match header of a conditional write fails
+func (f *fakeS3) conflicts(r *http.Request, path string) bool {
+	object := f.get(path)
+	if r.Header.Get("If-None-Match") == "*" && object != nil {
+		return true
+	}
+	if match := r.Header.Get("If-Match"); match != "" && (object == nil || match != `"`+object.etag()+`"`) {
+		return true
+	}
+	return false
+}
+
+func has(query url.Values, param string) bool {
+	_, ok := query[param]
+	return ok
+}
+
+// s3Result covers the fields of the XML results the fake sends
+type s3Result struct {
+	XMLName  xml.Name
+	Bucket   string        `xml:"Bucket,omitempty"`
+	ETag     string        `xml:"ETag,omitempty"`
+	Key      string        `xml:"Key,omitempty"`
+	UploadID string        `xml:"UploadId,omitempty"`
+	Contents []s3Content   `xml:"Contents,omitempty"`
+	Tags     []s3Tag       `xml:"TagSet>Tag,omitempty"`
+	Errors   []s3DeleteErr `xml:"Error,omitempty"`
+	Name     string        `xml:"Name,omitempty"`
+	OwnerID  string        `xml:"Owner>ID,omitempty"`
+	Prefix   string        `xml:"Prefix,omitempty"`
+	KeyCount *int          `xml:"KeyCount,omitempty"`
+	Complete *bool         `xml:"IsTruncated,omitempty"`
+}
+
+type s3Content struct {
+	ETag         string `xml:"ETag"`
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass,omitempty"`
+}
+
+type s3Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type s3DeleteErr struct {
+	Code    string `xml:"Code"`
+	Key     string `xml:"Key"`
+	Message string `xml:"Message"`
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, prefix string) {
+	var (
+		contents  []s3Content
+		truncated = false
+	)
+
+	f.lock.Lock()
+	for path, object := range f.objects {
+		if strings.HasPrefix(path, bucket+"/"+prefix) == false {
+			continue
+		}
+		contents = append(contents, s3Content{
+			ETag:         `"` + object.etag() + `"`,
+			Key:          strings.TrimPrefix(path, bucket+"/"),
+			LastModified: object.lastModified.Format(time.RFC3339),
+			Size:         object.length(),
+			StorageClass: object.headers["X-Amz-Storage-Class"],
+		})
+	}
+	f.lock.Unlock()
+
+	sort.Slice(contents, func(i, j int) bool { return contents[i].Key < contents[j].Key })
+	count := len(contents)
+	writeXML(w, s3Result{XMLName: xml.Name{Local: "ListBucketResult"}, Name: bucket, Prefix: prefix, Contents: contents, KeyCount: &count, Complete: &truncated})
+}
+
+func (f *fakeS3) getObject(w http.ResponseWriter, r *http.Request, path string) {
+	object := f.get(path)
+	if object == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey", path)
+		return
+	}
+
+	for name, value := range object.headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(object.length(), 10))
+	w.Header().Set("ETag", `"`+object.etag()+`"`)
+	w.Header().Set("Last-Modified", object.lastModified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "GET" {
+		w.Write(object.body)
+	}
+}
+
+func (f *fakeS3) getTagging(w http.ResponseWriter, path string) {
+	var tags []s3Tag
+
+	object := f.get(path)
+	if object == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey", path)
+		return
+	}
+	for key, value := range object.tags {
+		tags = append(tags, s3Tag{Key: key, Value: value})
+	}
+	writeXML(w, s3Result{XMLName: xml.Name{Local: "Tagging"}, Tags: tags})
+}
+
+func (f *fakeS3) copyObject(w http.ResponseWriter, r *http.Request, path string) {
+	source, _ := url.PathUnescape(strings.SplitN(r.Header.Get("X-Amz-Copy-Source"), "?", 2)[0])
+	object := f.get(strings.TrimPrefix(source, "/"))
+	if object == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey", source)
+		return
+	}
+
+	copied := &fakeObject{body: object.body, headers: map[string]string{}, size: object.size, tags: object.tags}
+	for name, value := range object.headers {
+		copied.headers[name] = value
+	}
+	if class := r.Header.Get("X-Amz-Storage-Class"); class != "" {
+		copied.headers["X-Amz-Storage-Class"] = class
+	}
+	f.put(path, copied)
+
+	writeXML(w, s3Result{XMLName: xml.Name{Local: "CopyObjectResult"}, ETag: `"` + copied.etag() + `"`})
+}
+
+// restore starts restoring an object, which stays ongoing until the test replaces the object
+func (f *fakeS3) restore(w http.ResponseWriter, path string) {
+	object := f.get(path)
+	if object == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey", path)
+		return
+	}
+	if object.headers["X-Amz-Restore"] == `ongoing-request="true"` {
+		writeError(w, http.StatusConflict, "RestoreAlreadyInProgress", path)
+		return
+	}
+
+	restoring := &fakeObject{body: object.body, headers: map[string]string{"X-Amz-Restore": `ongoing-request="true"`}, lastModified: object.lastModified, size: object.size, tags: object.tags}
+	for name, value := range object.headers {
+		if name != "X-Amz-Restore" {
+			restoring.headers[name] = value
+		}
+	}
+	f.put(path, restoring)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// deleteObjects reports keys that don't exist as errors, unlike s3, so failed deletes can be tested
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	var (
+		request struct {
+			Objects []struct {
+				Key string `xml:"Key"`
+			} `xml:"Object"`
+		}
+		errors []s3DeleteErr
+	)
+
+	body, _ := ioutil.ReadAll(r.Body)
+	xml.Unmarshal(body, &request)
+
+	f.lock.Lock()
+	for _, object := range request.Objects {
+		if _, ok := f.objects[bucket+"/"+object.Key]; ok == false {
+			errors = append(errors, s3DeleteErr{Code: "NoSuchKey", Key: object.Key, Message: "missing"})
+			continue
+		}
+		delete(f.objects, bucket+"/"+object.Key)
+	}
+	f.lock.Unlock()
+
+	writeXML(w, s3Result{XMLName: xml.Name{Local: "DeleteResult"}, Errors: errors})
+}
+
+// diffItems validates and initializes s and returns everything its diff would sync
+func diffItems(t *testing.T, s *Syncer) []s3diff.SyncItem {
+	var found []s3diff.SyncItem
+
+	s.Region = "us-east-1"
+	err := s.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := make(chan s3diff.SyncItem)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Differ.Stream(items)
+	}()
+	for item := range items {
+		found = append(found, item)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	return found
+}
@@ -0,0 +1,208 @@
+package s3sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// HeaderRule sets HTTP headers on every object whose key, relative to the source, matches Pattern.
+// Rules are applied in order so a later rule overrides a header set by an earlier one.
+type HeaderRule struct {
+	Pattern string            `json:"pattern"`
+	Headers map[string]string `json:"headers"`
+}
+
+// The headers a HeaderRule can set
+const (
+	HeaderCacheControl       = "Cache-Control"
+	HeaderContentDisposition = "Content-Disposition"
+	HeaderContentEncoding    = "Content-Encoding"
+	HeaderContentLanguage    = "Content-Language"
+	HeaderExpires            = "Expires"
+)
+
+// headerRule is a HeaderRule with its pattern compiled and header names canonicalized
+type headerRule struct {
+	headers map[string]string
+	pattern *regexp.Regexp
+}
+
+// ReadHeaderRules reads a JSON list of header rules, e.g.
+// [{"pattern": "*.html", "headers": {"Cache-Control": "no-cache"}}]
+func ReadHeaderRules(r io.Reader) ([]HeaderRule, error) {
+	var rules []HeaderRule
+
+	err := json.NewDecoder(r).Decode(&rules)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header rules: %s", err)
+	}
+
+	return rules, nil
+}
+
+// validateHeaderRules compiles HeaderRules, rejecting unsupported headers and malformed values
+func (s *Syncer) validateHeaderRules() error {
+	s.headerRules = nil
+
+	for _, rule := range s.HeaderRules {
+		pattern, err := compilePattern(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid header rule pattern %q: %s", rule.Pattern, err)
+		}
+
+		headers := make(map[string]string)
+		for name, value := range rule.Headers {
+			name = http.CanonicalHeaderKey(name)
+			switch name {
+			case HeaderCacheControl, HeaderContentDisposition, HeaderContentEncoding, HeaderContentLanguage:
+			case HeaderExpires:
+				_, err = http.ParseTime(value)
+				if err != nil {
+					return fmt.Errorf("invalid %s header %q for pattern %q, it must be an HTTP date", name, value, rule.Pattern)
+				}
+			default:
+				return fmt.Errorf("unsupported header %q for pattern %q, must be one of %s", name, rule.Pattern, strings.Join([]string{HeaderCacheControl, HeaderContentDisposition, HeaderContentEncoding, HeaderContentLanguage, HeaderExpires}, ", "))
+			}
+			headers[name] = value
+		}
+
+		s.headerRules = append(s.headerRules, headerRule{headers: headers, pattern: pattern})
+	}
+
+	return nil
+}
+
+// headersFor returns the headers every matching rule sets for a key relative to the source
+func (s *Syncer) headersFor(name string) map[string]string {
+	var headers map[string]string
+
+	for _, rule := range s.headerRules {
+		if rule.pattern.MatchString(name) == false {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		for header, value := range rule.headers {
+			headers[header] = value
+		}
+	}
+
+	return headers
+}
+
+// relativeName returns the key of a job relative to the destination, which is the same as its key
+// relative to the source
+func (s *Syncer) relativeName(job s3diff.SyncItem) string {
+	return strings.TrimPrefix(job.Destination, strings.TrimSuffix(s.Destination, "/")+"/")
+}
+
+// uploadHeaders sets the headers matching name on an upload
+func (s *Syncer) uploadHeaders(input *s3manager.UploadInput, name string) {
+	for header, value := range s.headersFor(name) {
+		switch header {
+		case HeaderCacheControl:
+			input.CacheControl = aws.String(value)
+		case HeaderContentDisposition:
+			input.ContentDisposition = aws.String(value)
+		case HeaderContentEncoding:
+			input.ContentEncoding = aws.String(value)
+		case HeaderContentLanguage:
+			input.ContentLanguage = aws.String(value)
+		case HeaderExpires:
+			expires, _ := http.ParseTime(value)
+			input.Expires = aws.Time(expires)
+		}
+	}
+}
+
+// copyHeaders sets the headers matching name on a copy. They only take effect with the REPLACE
+// metadata directive.
+func (s *Syncer) copyHeaders(input *s3.CopyObjectInput, name string) {
+	for header, value := range s.headersFor(name) {
+		switch header {
+		case HeaderCacheControl:
+			input.CacheControl = aws.String(value)
+		case HeaderContentDisposition:
+			input.ContentDisposition = aws.String(value)
+		case HeaderContentEncoding:
+			input.ContentEncoding = aws.String(value)
+		case HeaderContentLanguage:
+			input.ContentLanguage = aws.String(value)
+		case HeaderExpires:
+			expires, _ := http.ParseTime(value)
+			input.Expires = aws.Time(expires)
+		}
+	}
+}
+
+// replaceMetadata switches a copy to the REPLACE metadata directive, carrying over the metadata and
+// headers of the object being copied so that only what the copy sets explicitly changes
+func replaceMetadata(input *s3.CopyObjectInput, head *s3.HeadObjectOutput) {
+	input.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+	input.Metadata = head.Metadata
+	input.CacheControl = head.CacheControl
+	input.ContentDisposition = head.ContentDisposition
+	input.ContentEncoding = head.ContentEncoding
+	input.ContentLanguage = head.ContentLanguage
+	input.ContentType = head.ContentType
+	if expires, err := http.ParseTime(aws.StringValue(head.Expires)); err == nil {
+		input.Expires = aws.Time(expires)
+	}
+}
+
+// headersChanged reports whether a destination object is missing any of the headers its key
+// matches. It is used by the Differ to find header drift on objects whose content is unchanged,
+// and reports a change when the object can't be read so the headers are set again.
+func (s *Syncer) headersChanged(destination *s3diff.FileInfo) bool {
+	var (
+		actual  string
+		err     error
+		head    *s3.HeadObjectOutput
+		headers map[string]string
+	)
+
+	headers = s.headersFor(destination.Name)
+	if len(headers) == 0 {
+		return false
+	}
+
+	head, err = s.headObject(s.Differ.DestinationBucket, destination.Key, "", s.sseCustomerKey)
+	if err != nil {
+		return true
+	}
+
+	for header, value := range headers {
+		switch header {
+		case HeaderCacheControl:
+			actual = aws.StringValue(head.CacheControl)
+		case HeaderContentDisposition:
+			actual = aws.StringValue(head.ContentDisposition)
+		case HeaderContentEncoding:
+			actual = aws.StringValue(head.ContentEncoding)
+		case HeaderContentLanguage:
+			actual = aws.StringValue(head.ContentLanguage)
+		case HeaderExpires:
+			expected, _ := http.ParseTime(value)
+			current, err := http.ParseTime(aws.StringValue(head.Expires))
+			if err != nil || current.Equal(expected) == false {
+				return true
+			}
+			continue
+		}
+		if actual != value {
+			return true
+		}
+	}
+
+	return false
+}
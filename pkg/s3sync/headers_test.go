@@ -0,0 +1,60 @@
+package s3sync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+func TestHeaderRuleChangeUpdatesMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		want         int
+	}{
+		{name: "header missing", want: 1},
+		{name: "header changed", cacheControl: "max-age=60", want: 1},
+		{name: "header set", cacheControl: "no-cache"},
+	}
+
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("hello"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		f := newFakeS3()
+		headers := map[string]string{}
+		if test.cacheControl != "" {
+			headers["Cache-Control"] = test.cacheControl
+		}
+		f.put("bucket/site/index.html", &fakeObject{body: []byte("hello"), headers: headers})
+
+		items := diffItems(t, &Syncer{
+			Source:      dir,
+			Destination: "s3://bucket/site",
+			S3:          f.client(),
+			HeaderRules: []HeaderRule{{Pattern: "*.html", Headers: map[string]string{"cache-control": "no-cache"}}},
+		})
+		f.close()
+
+		if len(items) != test.want {
+			t.Errorf("%s: %d item(s) to sync, want %d", test.name, len(items), test.want)
+			continue
+		}
+		for _, item := range items {
+			if item.Reason != s3diff.ReasonMetadataChanged {
+				t.Errorf("%s: reason = %q, want %q", test.name, item.Reason, s3diff.ReasonMetadataChanged)
+			}
+		}
+	}
+}
@@ -1,26 +1,63 @@
 package s3sync
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
 	"github.com/kylelemons/godebug/pretty"
 )
 
 func (s *Syncer) validate() error {
+	var err error
+
 	// errorList := []string
-	if s.ACL == "" {
-		s.ACL = "private"
+	err = s.validateACL()
+	if err != nil {
+		return err
+	}
+
+	switch s.DeleteMode {
+	case "":
+		s.DeleteMode = DeleteAfter
+	case DeleteAfter, DeleteBefore, DeleteDuring:
+	default:
+		return fmt.Errorf("invalid DeleteMode %q, must be one of %s, %s or %s", s.DeleteMode, DeleteAfter, DeleteBefore, DeleteDuring)
+	}
+
+	if s.DeleteMode == DeleteDuring && (s.MaxDeletePercent > 0 || s.Confirm == true) {
+		return fmt.Errorf("the MaxDeletePercent and Confirm options need the whole listing and cannot be used with the %s DeleteMode", DeleteDuring)
+	}
+
+	if s.DetectRenames == true && s.Delete == false {
+		return fmt.Errorf("the DetectRenames option moves extraneous destination files and requires Delete")
 	}
 
 	if s.Destination == "" {
 		return fmt.Errorf("the Destination option is required")
 	}
 
+	if s.MaxDelete < 0 {
+		return fmt.Errorf("the MaxDelete option cannot be negative")
+	}
+
+	if s.MaxDeletePercent < 0 || s.MaxDeletePercent > 100 {
+		return fmt.Errorf("the MaxDeletePercent option must be between 0 and 100")
+	}
+
 	if s.MaxThreads == 0 {
 		s.MaxThreads = 12
 	}
 
+	for _, attribute := range s.Preserve {
+		if attribute != PreserveMode && attribute != PreserveOwner && attribute != PreserveTimes {
+			return fmt.Errorf("invalid Preserve attribute %q, must be one of %s, %s or %s", attribute, PreserveMode, PreserveOwner, PreserveTimes)
+		}
+	}
+
 	if s.Profile == "" {
 		s.Profile = "default"
 	}
@@ -33,6 +70,86 @@ func (s *Syncer) validate() error {
 		return fmt.Errorf("the Source option is required")
 	}
 
+	err = s.validateHeaderRules()
+	if err != nil {
+		return err
+	}
+
+	err = s.validateContentTypes()
+	if err != nil {
+		return err
+	}
+
+	err = s.validateTagging()
+	if err != nil {
+		return err
+	}
+
+	err = s.validateRestore()
+	if err != nil {
+		return err
+	}
+
+	err = s.validateVersions()
+	if err != nil {
+		return err
+	}
+
+	err = s.validateDedup()
+	if err != nil {
+		return err
+	}
+
+	err = s.validateLock()
+	if err != nil {
+		return err
+	}
+
+	return s.validateEncryption()
+}
+
+func pathExists(path string) bool {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false
+	}
+	return true
+}
+
+// message returns the line printed for a job, itemized when ItemizeChanges is set
+func (s *Syncer) message(job s3diff.SyncItem) string {
+	if s.ItemizeChanges == true {
+		return job.Itemize()
+	}
+	return fmt.Sprintf("%s (%s)", job.Message, job.Reason)
+}
+
+// WriteJSONFile writes v as indented JSON to a temp file and renames it into place. The temp
+// file is named like an in-flight download so it is cleaned up if the write is interrupted.
+func WriteJSONFile(path string, v interface{}) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), tempPrefix+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(v)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,489 @@
+package s3sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// LockName is the name of the lock object or file at the top of a locked destination. It is
+// never synced or deleted.
+const LockName = ".s3sync.lock"
+
+// DefaultLockTTL is how long an s3 lock is good for without a heartbeat
+const DefaultLockTTL = 5 * time.Minute
+
+// LockInfo is the content of a lock, saying who holds it
+type LockInfo struct {
+	Owner      string    `json:"owner"`
+	Acquired   time.Time `json:"acquired"`
+	TTLSeconds int64     `json:"ttl_seconds"`
+}
+
+// lease is a lock held on a destination. An s3 lock is kept alive by a heartbeat that rewrites
+// it before it expires, conditional on it not having been taken over.
+type lease struct {
+	bucket string
+	done   chan struct{}
+	etag   string
+	file   *os.File
+	info   LockInfo
+	key    string
+	lock   sync.Mutex
+	lost   bool
+	stop   chan struct{}
+}
+
+// validateLock checks the Lock options
+func (s *Syncer) validateLock() error {
+	if s.LockTTL < 0 {
+		return fmt.Errorf("the LockTTL option cannot be negative")
+	}
+	if s.LockTTL == 0 {
+		s.LockTTL = DefaultLockTTL
+	}
+	if s.LockTTL < 30*time.Second {
+		return fmt.Errorf("the LockTTL option must be at least 30s")
+	}
+	if s.LockOwner == "" {
+		s.LockOwner = defaultLockOwner()
+	}
+	return nil
+}
+
+// defaultLockOwner identifies this process in a lock
+func defaultLockOwner() string {
+	owner := "unknown"
+	if u, err := user.Current(); err == nil {
+		owner = u.Username
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s pid %d", owner, host, os.Getpid())
+}
+
+// acquireLock locks the Destination when Lock is set and returns the function that releases it.
+// The release function returns an error if the lock was lost while it was held.
+func (s *Syncer) acquireLock() (func() error, error) {
+	var (
+		l   *lease
+		err error
+	)
+
+	if s.Lock == false {
+		return func() error { return nil }, nil
+	}
+
+	l = &lease{info: LockInfo{Owner: s.LockOwner, Acquired: time.Now().UTC(), TTLSeconds: int64(s.LockTTL / time.Second)}}
+
+	if s.Differ.DestinationType == "s3" {
+		l.bucket = s.Differ.DestinationBucket
+		l.key = lockKey(s.Differ.DestinationPath)
+		err = s.acquireS3Lock(l)
+	} else {
+		err = acquireLocalLock(l, filepath.Join(s.Differ.DestinationPath, LockName))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.lease = l
+	if l.file == nil {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go s.heartbeat(l)
+	}
+
+	return func() error {
+		s.lease = nil
+		return s.releaseLock(l)
+	}, nil
+}
+
+// withLock runs fn while holding the lock on the destination when Lock is set. Temp files left in
+// a local destination by an interrupted download are removed once the lock is held, so they can't
+// belong to another sync that is still running.
+func (s *Syncer) withLock(fn func() error) error {
+	release, err := s.acquireLock()
+	if err != nil {
+		return err
+	}
+
+	if s.Differ.DestinationType == "local" && s.Inplace == false {
+		err = s.cleanupTempFiles(s.Differ.DestinationPath)
+	}
+	if err == nil {
+		err = fn()
+	}
+	if releaseErr := release(); err == nil {
+		err = releaseErr
+	}
+
+	return err
+}
+
+// lockKey returns the key of the lock object for a destination prefix
+func lockKey(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return LockName
+	}
+	return path + "/" + LockName
+}
+
+// lockLost reports whether the lock on the destination was taken over or could not be renewed,
+// in which case nothing more is deleted
+func (s *Syncer) lockLost() bool {
+	if s.lease == nil {
+		return false
+	}
+	s.lease.lock.Lock()
+	defer s.lease.lock.Unlock()
+	return s.lease.lost
+}
+
+// acquireS3Lock creates the lock object only if there isn't one, or takes over one that has
+// expired only if nobody else took it over first
+func (s *Syncer) acquireS3Lock(l *lease) error {
+	etag, err := s.putLock(l, "If-None-Match", "*")
+	if err == nil {
+		l.etag = etag
+		return nil
+	}
+	if isPreconditionFailed(err) == false {
+		return fmt.Errorf("unable to lock s3://%s/%s: %s", l.bucket, l.key, err)
+	}
+
+	held, current, err := s.readS3Lock(l.bucket, l.key)
+	if err != nil {
+		return fmt.Errorf("unable to read the lock s3://%s/%s: %s", l.bucket, l.key, err)
+	}
+	if held == nil {
+		// Released in the meantime
+		return s.acquireS3Lock(l)
+	}
+
+	// Expiry is judged by the server clock, which also set LastModified, so a skewed local clock
+	// can't take over a lock that is still alive
+	now := current.Date
+	if now.IsZero() {
+		now = time.Now()
+	}
+	expires := current.LastModified.Add(time.Duration(held.TTLSeconds) * time.Second)
+	if now.Before(expires) {
+		return fmt.Errorf("s3://%s/%s is locked by %s since %s until %s, use the unlock command if it is stale", s.Differ.DestinationBucket, s.Differ.DestinationPath, held.Owner, held.Acquired.Format(time.RFC3339), expires.UTC().Format(time.RFC3339))
+	}
+
+	fmt.Printf("taking over the lock held by %s, which expired at %s\n", held.Owner, expires.UTC().Format(time.RFC3339))
+	etag, err = s.putLock(l, "If-Match", current.ETag)
+	if isPreconditionFailed(err) {
+		return fmt.Errorf("s3://%s/%s was locked by someone else while taking over a stale lock", s.Differ.DestinationBucket, s.Differ.DestinationPath)
+	} else if err != nil {
+		return fmt.Errorf("unable to lock s3://%s/%s: %s", l.bucket, l.key, err)
+	}
+	l.etag = etag
+
+	return nil
+}
+
+// s3LockObject is what readS3Lock found about the lock object. Date is the time on the server
+// when it was read, and is zero if the response had no Date header.
+type s3LockObject struct {
+	Date         time.Time
+	ETag         string
+	LastModified time.Time
+}
+
+// readS3Lock returns the lock object, or nil if there is none
+func (s *Syncer) readS3Lock(bucket string, key string) (*LockInfo, *s3LockObject, error) {
+	var info LockInfo
+
+	req, resp := s.S3.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	err := req.Send()
+	if isNotFound(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A lock that can't be read is reported without an owner and treated as expired
+	json.Unmarshal(data, &info)
+
+	date, _ := http.ParseTime(req.HTTPResponse.Header.Get("Date"))
+
+	return &info, &s3LockObject{Date: date, ETag: aws.StringValue(resp.ETag), LastModified: aws.TimeValue(resp.LastModified)}, nil
+}
+
+// putLock writes the lock object with a conditional header and returns its new ETag
+func (s *Syncer) putLock(l *lease, header string, value string) (string, error) {
+	body, err := json.Marshal(l.info)
+	if err != nil {
+		return "", err
+	}
+
+	req, resp := s.S3.PutObjectRequest(&s3.PutObjectInput{
+		Body:        bytes.NewReader(body),
+		Bucket:      aws.String(l.bucket),
+		ContentType: aws.String("application/json"),
+		Key:         aws.String(l.key),
+	})
+	// Conditional writes are newer than the SDK, so the header is set by hand
+	req.HTTPRequest.Header.Set(header, value)
+
+	err = req.Send()
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(resp.ETag), nil
+}
+
+// heartbeat renews the lock every third of its TTL. The lock is lost if it was taken over, or if
+// it could not be renewed before it expired.
+func (s *Syncer) heartbeat(l *lease) {
+	var (
+		renewed time.Time
+		ticker  *time.Ticker
+	)
+
+	defer close(l.done)
+
+	renewed = time.Now()
+	ticker = time.NewTicker(s.LockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		l.lock.Lock()
+		etag, err := s.putLock(l, "If-Match", l.etag)
+		switch {
+		case err == nil:
+			l.etag = etag
+			renewed = time.Now()
+		case isPreconditionFailed(err) || time.Since(renewed) > s.LockTTL:
+			l.lost = true
+			fmt.Printf("lost the lock s3://%s/%s, no more files will be deleted: %s\n", l.bucket, l.key, err)
+		default:
+			fmt.Printf("unable to renew the lock s3://%s/%s, retrying: %s\n", l.bucket, l.key, err)
+		}
+		lost := l.lost
+		l.lock.Unlock()
+
+		if lost {
+			return
+		}
+	}
+}
+
+// releaseLock stops the heartbeat and removes the lock if it is still ours
+func (s *Syncer) releaseLock(l *lease) error {
+	if l.file != nil {
+		return releaseLocalLock(l)
+	}
+
+	close(l.stop)
+	<-l.done
+
+	if l.lost == true {
+		return fmt.Errorf("the lock on the destination was lost during the sync, run it again")
+	}
+
+	_, current, err := s.readS3Lock(l.bucket, l.key)
+	if err != nil {
+		return fmt.Errorf("unable to release the lock s3://%s/%s: %s", l.bucket, l.key, err)
+	}
+	if current == nil || current.ETag != l.etag {
+		return fmt.Errorf("the lock on the destination was taken over during the sync, run it again")
+	}
+
+	_, err = s.S3.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(l.bucket), Key: aws.String(l.key)})
+	if err != nil {
+		return fmt.Errorf("unable to release the lock s3://%s/%s: %s", l.bucket, l.key, err)
+	}
+
+	return nil
+}
+
+// acquireLocalLock takes an exclusive lock on the lock file, which the operating system releases
+// if the process dies, and writes the LockInfo into it
+func acquireLocalLock(l *lease, path string) error {
+	var held LockInfo
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to lock %s: %s", filepath.Dir(path), err)
+	}
+
+	err = lockFile(f)
+	if err != nil {
+		data, _ := ioutil.ReadAll(f)
+		json.Unmarshal(data, &held)
+		f.Close()
+		return fmt.Errorf("%s is locked by %s since %s", filepath.Dir(path), held.Owner, held.Acquired.Format(time.RFC3339))
+	}
+
+	data, err := json.Marshal(l.info)
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		_, err = f.WriteAt(data, 0)
+	}
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return fmt.Errorf("unable to lock %s: %s", filepath.Dir(path), err)
+	}
+
+	l.file = f
+	return nil
+}
+
+// releaseLocalLock unlocks the lock file. It is left in place so every process locks the same file.
+func releaseLocalLock(l *lease) error {
+	l.file.Truncate(0)
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Unlock removes the lock on the Destination left by a sync that didn't release it, and returns
+// what it said. A local lock can only be held by a running process, which is not interrupted; one
+// left by a process that died is cleared but the file stays in place.
+func (s *Syncer) Unlock() (*LockInfo, error) {
+	var (
+		differ *s3diff.Differ
+		err    error
+		held   *LockInfo
+	)
+
+	differ = &s3diff.Differ{Source: s.Destination, Destination: s.Destination}
+	err = differ.DetermineTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	if differ.DestinationType == "s3" {
+		// Only the destination matters when unlocking
+		if s.Source == "" {
+			s.Source = s.Destination
+		}
+
+		err = s.connect()
+		if err != nil {
+			return nil, err
+		}
+
+		key := lockKey(differ.DestinationPath)
+		held, _, err = s.readS3Lock(differ.DestinationBucket, key)
+		if err != nil {
+			return nil, err
+		}
+		if held == nil {
+			return nil, fmt.Errorf("%s is not locked", s.Destination)
+		}
+		_, err = s.S3.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(differ.DestinationBucket), Key: aws.String(key)})
+		if err != nil {
+			return nil, err
+		}
+		return held, nil
+	}
+
+	path := filepath.Join(differ.DestinationPath, LockName)
+	if pathExists(path) == false {
+		return nil, fmt.Errorf("%s is not locked", s.Destination)
+	}
+	held, err = clearLocalLock(path)
+	if err != nil {
+		return nil, err
+	}
+	if held == nil {
+		return nil, fmt.Errorf("%s is not locked", s.Destination)
+	}
+
+	return held, nil
+}
+
+// clearLocalLock empties a lock file left by a process that died, whose lock the operating system
+// has already released, and returns what it said, or nil if it was empty. The file is not removed,
+// since a process still holding its inode and one locking a new file would both own the lock.
+func clearLocalLock(path string) (*LockInfo, error) {
+	var held LockInfo
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = lockFile(f)
+	if err != nil {
+		data, _ := ioutil.ReadAll(f)
+		json.Unmarshal(data, &held)
+		return nil, fmt.Errorf("%s is locked by %s since %s, which is still running and can't be unlocked", filepath.Dir(path), held.Owner, held.Acquired.Format(time.RFC3339))
+	}
+	defer unlockFile(f)
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	// A lock file that can't be read is reported without an owner
+	json.Unmarshal(data, &held)
+
+	err = f.Truncate(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &held, nil
+}
+
+// isPreconditionFailed reports whether a conditional write failed because of the state of the object
+func isPreconditionFailed(err error) bool {
+	if failure, ok := err.(awserr.RequestFailure); ok {
+		// 409 is returned when another conditional write to the key is in progress
+		return failure.StatusCode() == 412 || failure.StatusCode() == 409
+	}
+	return false
+}
+
+// isNotFound reports whether a request failed because the object does not exist
+func isNotFound(err error) bool {
+	if failure, ok := err.(awserr.RequestFailure); ok {
+		return failure.StatusCode() == 404
+	}
+	return false
+}
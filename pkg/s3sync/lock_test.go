@@ -0,0 +1,178 @@
+package s3sync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// lockSyncer returns a Syncer locking s3://bucket/data
+func lockSyncer(f *fakeS3) *Syncer {
+	return &Syncer{
+		Differ:    &s3diff.Differ{DestinationBucket: "bucket", DestinationPath: "data", DestinationType: "s3"},
+		Lock:      true,
+		LockOwner: "me",
+		LockTTL:   5 * time.Minute,
+		S3:        f.client(),
+	}
+}
+
+// putLockObject stores a lock held by other, written at lastModified by the server clock
+func putLockObject(t *testing.T, f *fakeS3, lastModified time.Time) {
+	body, err := json.Marshal(LockInfo{Owner: "other", Acquired: lastModified, TTLSeconds: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.put("bucket/data/"+LockName, &fakeObject{body: body, lastModified: lastModified})
+}
+
+func TestLockExpiryUsesServerClock(t *testing.T) {
+	var held LockInfo
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// The local clock is an hour ahead of the server, which says the lock was written a minute ago
+	f := newFakeS3()
+	defer f.close()
+	putLockObject(t, f, now.Add(-time.Hour))
+	f.date = now.Add(-time.Hour + time.Minute)
+
+	_, err := lockSyncer(f).acquireLock()
+	if err == nil || strings.Contains(err.Error(), "is locked by other") == false {
+		t.Errorf("acquireLock = %v, want the live lock of other to be kept", err)
+	}
+
+	// The local clock is behind the server, which says the lock expired five minutes ago
+	f = newFakeS3()
+	defer f.close()
+	putLockObject(t, f, now)
+	f.date = now.Add(10 * time.Minute)
+
+	release, err := lockSyncer(f).acquireLock()
+	if err != nil {
+		t.Fatalf("acquireLock = %v, want the expired lock to be taken over", err)
+	}
+	json.Unmarshal(f.get("bucket/data/"+LockName).body, &held)
+	if held.Owner != "me" {
+		t.Errorf("lock owner = %q after the takeover, want me", held.Owner)
+	}
+
+	err = release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.get("bucket/data/"+LockName) != nil {
+		t.Errorf("the lock was not released")
+	}
+}
+
+func TestWithLockCleansUpTempFilesOnceLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	temp := filepath.Join(dir, tempPrefix+"file")
+	err = ioutil.WriteFile(temp, []byte("partial"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSyncer := func() *Syncer {
+		return &Syncer{
+			Differ:    &s3diff.Differ{DestinationPath: dir, DestinationType: "local"},
+			Lock:      true,
+			LockOwner: "me",
+		}
+	}
+
+	// The temp file may belong to the sync holding the lock, so it is left alone
+	release, err := newSyncer().acquireLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newSyncer().withLock(func() error {
+		t.Errorf("ran while another sync held the lock")
+		return nil
+	})
+	if err == nil {
+		t.Errorf("withLock succeeded while another sync held the lock")
+	}
+	if pathExists(temp) == false {
+		t.Errorf("the temp file of the sync holding the lock was removed")
+	}
+
+	err = release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newSyncer().withLock(func() error {
+		if pathExists(temp) {
+			t.Errorf("the orphaned temp file was still there once the lock was held")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnlockLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, LockName)
+	s := &Syncer{Destination: dir}
+
+	_, err = s.Unlock()
+	if err == nil || strings.Contains(err.Error(), "is not locked") == false {
+		t.Errorf("Unlock without a lock file = %v, want not locked", err)
+	}
+
+	// A process that died left its LockInfo behind, but the operating system released its lock
+	body, _ := json.Marshal(LockInfo{Owner: "other", Acquired: time.Now().UTC()})
+	err = ioutil.WriteFile(path, body, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, err := s.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held == nil || held.Owner != "other" {
+		t.Errorf("Unlock of a stale lock = %+v, want the lock of other", held)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || len(data) > 0 {
+		t.Errorf("the lock file was removed or not cleared: %q, %v", data, err)
+	}
+
+	_, err = s.Unlock()
+	if err == nil || strings.Contains(err.Error(), "is not locked") == false {
+		t.Errorf("Unlock of a cleared lock file = %v, want not locked", err)
+	}
+
+	// A lock held by a running process is left alone
+	holder := &Syncer{Differ: &s3diff.Differ{DestinationPath: dir, DestinationType: "local"}, Lock: true, LockOwner: "running"}
+	release, err := holder.acquireLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	_, err = s.Unlock()
+	if err == nil || strings.Contains(err.Error(), "locked by running") == false {
+		t.Errorf("Unlock of a held lock = %v, want it refused", err)
+	}
+	if pathExists(path) == false {
+		t.Errorf("the lock file of a running process was removed")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package s3sync
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on a file without waiting for it
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,180 @@
+package s3sync
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// The user metadata keys used to carry file attributes, matching the rclone/s3cmd conventions
+const (
+	metaGID   = "gid"
+	metaMode  = "mode"
+	metaMtime = "mtime"
+	metaUID   = "uid"
+)
+
+// The attributes that can be passed to Syncer.Preserve
+const (
+	PreserveMode  = "mode"
+	PreserveOwner = "owner"
+	PreserveTimes = "times"
+)
+
+// fileMetadata builds the user metadata stored alongside an uploaded file
+func fileMetadata(info os.FileInfo) map[string]*string {
+	var (
+		metadata map[string]*string
+		mtime    time.Time
+	)
+
+	mtime = info.ModTime()
+	metadata = map[string]*string{
+		metaMtime: aws.String(fmt.Sprintf("%d.%09d", mtime.Unix(), mtime.Nanosecond())),
+		metaMode:  aws.String(strconv.FormatUint(uint64(info.Mode().Perm()), 8)),
+	}
+
+	if uid, gid, ok := fileOwner(info); ok {
+		metadata[metaUID] = aws.String(strconv.Itoa(uid))
+		metadata[metaGID] = aws.String(strconv.Itoa(gid))
+	}
+
+	return metadata
+}
+
+// preserves reports whether the given attribute was requested in Syncer.Preserve
+func (s *Syncer) preserves(attribute string) bool {
+	for _, p := range s.Preserve {
+		if p == attribute {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMetadata sets the requested attributes on a downloaded file from the object's metadata,
+// falling back to LastModified for the mtime when the object was not uploaded by s3sync
+func (s *Syncer) applyMetadata(path string, head *s3.HeadObjectOutput) error {
+	var (
+		err   error
+		gid   int
+		mode  uint64
+		mtime time.Time
+		uid   int
+	)
+
+	if s.preserves(PreserveMode) {
+		if value, ok := metadataValue(head.Metadata, metaMode); ok {
+			mode, err = strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return fmt.Errorf("invalid %s metadata %q: %s", metaMode, value, err)
+			}
+			err = os.Chmod(path, os.FileMode(mode)&os.ModePerm)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.preserves(PreserveOwner) {
+		uidValue, uidOk := metadataValue(head.Metadata, metaUID)
+		gidValue, gidOk := metadataValue(head.Metadata, metaGID)
+		if uidOk && gidOk {
+			uid, err = strconv.Atoi(uidValue)
+			if err != nil {
+				return fmt.Errorf("invalid %s metadata %q: %s", metaUID, uidValue, err)
+			}
+			gid, err = strconv.Atoi(gidValue)
+			if err != nil {
+				return fmt.Errorf("invalid %s metadata %q: %s", metaGID, gidValue, err)
+			}
+			// Like rsync, only root can give files away so a permission error is not fatal
+			err = os.Chown(path, uid, gid)
+			if err != nil && !os.IsPermission(err) {
+				return err
+			}
+		}
+	}
+
+	if s.preserves(PreserveTimes) {
+		if value, ok := metadataValue(head.Metadata, metaMtime); ok {
+			mtime, err = parseMtime(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s metadata %q: %s", metaMtime, value, err)
+			}
+		} else if head.LastModified != nil {
+			mtime = *head.LastModified
+		}
+		if mtime.IsZero() == false {
+			err = os.Chtimes(path, mtime, mtime)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sourceMtime is the Differ's SourceMtime hook. It returns the mtime a source object was uploaded
+// with, which is what a download that preserves times gives the local file.
+func (s *Syncer) sourceMtime(source *s3diff.FileInfo) (time.Time, bool) {
+	head, err := s.headObject(s.Differ.SourceBucket, source.Key, source.VersionID, s.sseCustomerSourceKey)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	value, ok := metadataValue(head.Metadata, metaMtime)
+	if ok == false {
+		return time.Time{}, false
+	}
+
+	mtime, err := parseMtime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return mtime, true
+}
+
+// metadataValue looks up a user metadata key, ignoring the canonical casing applied by the SDK
+func metadataValue(metadata map[string]*string, key string) (string, bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v, true
+		}
+	}
+	return "", false
+}
+
+// parseMtime parses seconds since the epoch with an optional fractional part
+func parseMtime(value string) (time.Time, error) {
+	var (
+		err   error
+		nsec  int64
+		parts []string
+		sec   int64
+	)
+
+	parts = strings.SplitN(value, ".", 2)
+	sec, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(parts) == 2 && parts[1] != "" {
+		fraction := (parts[1] + "000000000")[:9]
+		nsec, err = strconv.ParseInt(fraction, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return time.Unix(sec, nsec), nil
+}
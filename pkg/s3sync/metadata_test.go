@@ -0,0 +1,81 @@
+package s3sync
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeFileInfo is an os.FileInfo with only a mode and mtime
+type fakeFileInfo struct {
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func (f fakeFileInfo) IsDir() bool        { return false }
+func (f fakeFileInfo) ModTime() time.Time { return f.mtime }
+func (f fakeFileInfo) Mode() os.FileMode  { return f.mode }
+func (f fakeFileInfo) Name() string       { return "file" }
+func (f fakeFileInfo) Size() int64        { return 0 }
+func (f fakeFileInfo) Sys() interface{}   { return nil }
+
+func TestParseMtime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		err   bool
+	}{
+		{value: "1600000000", want: time.Unix(1600000000, 0)},
+		{value: "1600000000.", want: time.Unix(1600000000, 0)},
+		{value: "1600000000.5", want: time.Unix(1600000000, 500000000)},
+		{value: "1600000000.000000001", want: time.Unix(1600000000, 1)},
+		{value: "1600000000.123456789", want: time.Unix(1600000000, 123456789)},
+		// Anything past nanoseconds is dropped
+		{value: "1600000000.1234567891", want: time.Unix(1600000000, 123456789)},
+		{value: "-1", want: time.Unix(-1, 0)},
+		{value: "", err: true},
+		{value: "abc", err: true},
+		{value: "1600000000.abc", err: true},
+		{value: "1.2.3", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseMtime(test.value)
+		if test.err == true {
+			if err == nil {
+				t.Errorf("parseMtime(%q) = %s, want an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMtime(%q) returned %s", test.value, err)
+			continue
+		}
+		if got.Equal(test.want) == false {
+			t.Errorf("parseMtime(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestFileMetadataRoundTrip(t *testing.T) {
+	mtime := time.Unix(1600000000, 123456789)
+	info := fakeFileInfo{mode: 0640, mtime: mtime}
+
+	metadata := fileMetadata(info)
+
+	value, ok := metadataValue(metadata, "Mtime")
+	if ok == false {
+		t.Fatalf("fileMetadata did not set %s", metaMtime)
+	}
+	got, err := parseMtime(value)
+	if err != nil {
+		t.Fatalf("parseMtime(%q) returned %s", value, err)
+	}
+	if got.Equal(mtime) == false {
+		t.Errorf("mtime round trip = %s, want %s", got, mtime)
+	}
+
+	if value, _ := metadataValue(metadata, metaMode); value != "640" {
+		t.Errorf("%s = %q, want %q", metaMode, value, "640")
+	}
+}
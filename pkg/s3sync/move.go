@@ -0,0 +1,78 @@
+package s3sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// move copies a destination object to the key of the new source file it matches and deletes the
+// original. The object keeps its metadata, tags and Content-Type unless headers or a Content-Type
+// are set for its new name. Objects larger than maxCopySize are copied in parts.
+func (s *Syncer) move(job s3diff.SyncItem) error {
+	var (
+		destinationKey string
+		err            error
+		mimeType       string
+		name           string
+	)
+
+	_, destinationKey, err = splitS3URL(job.Destination)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(job.Bucket),
+		CopySource: aws.String(copySource(job.Bucket, job.Key)),
+		Key:        aws.String(destinationKey),
+	}
+	s.copyOptions(input, s.sseCustomerKey)
+
+	name = s.relativeName(job)
+	mimeType = resolveContentType(s.copyContentTypes, name, "")
+	if mimeType != "" || len(s.headersFor(name)) > 0 {
+		head, err := s.headObject(job.Bucket, job.Key, "", s.sseCustomerKey)
+		if err != nil {
+			return err
+		}
+		replaceMetadata(input, head)
+		s.copyHeaders(input, name)
+		if mimeType != "" {
+			input.ContentType = &mimeType
+		}
+	}
+
+	err = s.withACL(func() error {
+		s.copyACL(input)
+		_, err := s.copyObject(input, job.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.S3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(job.Bucket),
+		Key:    aws.String(job.Key),
+	})
+	if err != nil {
+		return fmt.Errorf("copied but unable to delete the original: %s", err)
+	}
+
+	return nil
+}
+
+// rename moves a local destination file to the path of the new source file it matches
+func (s *Syncer) rename(job s3diff.SyncItem) error {
+	err := os.MkdirAll(filepath.Dir(job.Destination), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(job.Path, job.Destination)
+}
@@ -0,0 +1,37 @@
+package s3sync
+
+import (
+	"testing"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+func TestMoveLargeObject(t *testing.T) {
+	const size = maxCopySize + 1
+
+	f := newFakeS3()
+	defer f.close()
+	f.put("bucket/data/old", &fakeObject{size: size})
+
+	s := &Syncer{
+		Differ: &s3diff.Differ{DestinationBucket: "bucket", DestinationPath: "data", DestinationType: "s3"},
+		S3:     f.client(),
+	}
+	err := s.move(s3diff.SyncItem{
+		Action:      s3diff.ActionMove,
+		Bucket:      "bucket",
+		Destination: "s3://bucket/data/new",
+		Key:         "data/old",
+		Size:        size,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parts := f.received("PUT", "partNumber"); len(parts) == 0 || len(parts) != len(f.received("PUT", "")) {
+		t.Errorf("CopyObject was used for an object larger than %d bytes", int64(maxCopySize))
+	}
+	if f.get("bucket/data/new") == nil || f.get("bucket/data/old") != nil {
+		t.Errorf("the object was not moved")
+	}
+}
@@ -0,0 +1,185 @@
+package s3sync
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// maxCopySize is the largest object a single CopyObject can copy
+const maxCopySize = 5 * 1024 * 1024 * 1024
+
+// copyPartSize is the size of each part of a multipart copy, unless the object is so large that
+// it would take more than maxCopyParts parts
+const copyPartSize = 512 * 1024 * 1024
+
+// maxCopyParts is the most parts a multipart upload can have
+const maxCopyParts = 10000
+
+// copyObject runs a server side copy of an object of the given size and returns the version ID it
+// created. Objects larger than maxCopySize are copied in parts, which unlike CopyObject does not
+// carry anything over from the source, so the metadata and tags of the source are read and set
+// on the new object unless input replaces them.
+func (s *Syncer) copyObject(input *s3.CopyObjectInput, size int64) (string, error) {
+	if size <= maxCopySize {
+		resp, err := s.S3.CopyObject(input)
+		if err != nil {
+			return "", err
+		}
+		return aws.StringValue(resp.VersionId), nil
+	}
+
+	return s.multipartCopy(input, size)
+}
+
+// multipartCopy copies an object with UploadPartCopy, aborting the upload if any part fails
+func (s *Syncer) multipartCopy(input *s3.CopyObjectInput, size int64) (string, error) {
+	var (
+		parts    []*s3.CompletedPart
+		partSize int64
+	)
+
+	bucket, key, versionID, err := splitCopySource(aws.StringValue(input.CopySource))
+	if err != nil {
+		return "", err
+	}
+
+	create := &s3.CreateMultipartUploadInput{
+		ACL:                  input.ACL,
+		Bucket:               input.Bucket,
+		CacheControl:         input.CacheControl,
+		ContentDisposition:   input.ContentDisposition,
+		ContentEncoding:      input.ContentEncoding,
+		ContentLanguage:      input.ContentLanguage,
+		ContentType:          input.ContentType,
+		Expires:              input.Expires,
+		GrantFullControl:     input.GrantFullControl,
+		GrantRead:            input.GrantRead,
+		GrantReadACP:         input.GrantReadACP,
+		GrantWriteACP:        input.GrantWriteACP,
+		Key:                  input.Key,
+		Metadata:             input.Metadata,
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+		SSEKMSKeyId:          input.SSEKMSKeyId,
+		ServerSideEncryption: input.ServerSideEncryption,
+		StorageClass:         input.StorageClass,
+		Tagging:              input.Tagging,
+	}
+
+	if aws.StringValue(input.MetadataDirective) != s3.MetadataDirectiveReplace {
+		head, err := s.headObject(bucket, key, versionID, aws.StringValue(input.CopySourceSSECustomerKey))
+		if err != nil {
+			return "", err
+		}
+		create.Metadata = head.Metadata
+		create.CacheControl = head.CacheControl
+		create.ContentDisposition = head.ContentDisposition
+		create.ContentEncoding = head.ContentEncoding
+		create.ContentLanguage = head.ContentLanguage
+		create.ContentType = head.ContentType
+		if expires, err := http.ParseTime(aws.StringValue(head.Expires)); err == nil {
+			create.Expires = aws.Time(expires)
+		}
+	}
+
+	if aws.StringValue(input.TaggingDirective) != s3.TaggingDirectiveReplace {
+		tags, err := s.objectTags(bucket, key, versionID)
+		if err != nil {
+			return "", err
+		}
+		if len(tags) > 0 {
+			create.Tagging = aws.String(encodeTags(tags))
+		}
+	}
+
+	upload, err := s.S3.CreateMultipartUpload(create)
+	if err != nil {
+		return "", err
+	}
+
+	partSize = copyPartSize
+	if size > partSize*maxCopyParts {
+		partSize = (size + maxCopyParts - 1) / maxCopyParts
+	}
+
+	for number, start := int64(1), int64(0); start < size; number, start = number+1, start+partSize {
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+
+		resp, err := s.S3.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:                         input.Bucket,
+			CopySource:                     input.CopySource,
+			CopySourceRange:                aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			CopySourceSSECustomerAlgorithm: input.CopySourceSSECustomerAlgorithm,
+			CopySourceSSECustomerKey:       input.CopySourceSSECustomerKey,
+			Key:                            input.Key,
+			PartNumber:                     aws.Int64(number),
+			SSECustomerAlgorithm:           input.SSECustomerAlgorithm,
+			SSECustomerKey:                 input.SSECustomerKey,
+			UploadId:                       upload.UploadId,
+		})
+		if err != nil {
+			s.abortUpload(upload)
+			return "", fmt.Errorf("unable to copy part %d: %s", number, err)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: resp.CopyPartResult.ETag, PartNumber: aws.Int64(number)})
+	}
+
+	complete, err := s.S3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		UploadId:        upload.UploadId,
+	})
+	if err != nil {
+		s.abortUpload(upload)
+		return "", err
+	}
+
+	return aws.StringValue(complete.VersionId), nil
+}
+
+// abortUpload removes the parts of a multipart upload that could not be completed
+func (s *Syncer) abortUpload(upload *s3.CreateMultipartUploadOutput) {
+	_, err := s.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   upload.Bucket,
+		Key:      upload.Key,
+		UploadId: upload.UploadId,
+	})
+	if err != nil {
+		fmt.Printf("unable to abort the upload of s3://%s/%s, its parts are left behind: %s\n", aws.StringValue(upload.Bucket), aws.StringValue(upload.Key), err)
+	}
+}
+
+// splitCopySource splits a CopySource made by copySource, with an optional versionId, back into
+// the bucket, key and version
+func splitCopySource(source string) (string, string, string, error) {
+	var versionID string
+
+	if i := strings.Index(source, "?versionId="); i >= 0 {
+		version, err := url.QueryUnescape(source[i+len("?versionId="):])
+		if err != nil {
+			return "", "", "", err
+		}
+		source, versionID = source[:i], version
+	}
+
+	parts := strings.SplitN(source, "/", 2)
+	if len(parts) != 2 {
+		return "", "", "", fmt.Errorf("invalid copy source %q", source)
+	}
+
+	key, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return parts[0], key, versionID, nil
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package s3sync
+
+import (
+	"os"
+)
+
+// fileOwner is not supported on windows, which has no numeric owner and group
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,40 @@
+package s3sync
+
+import (
+	"regexp"
+	"strings"
+)
+
+// compilePattern turns a glob into a regexp matched against keys relative to the source. * and ?
+// do not cross a /, ** matches any number of directories. A pattern without a / matches the last
+// path element at any depth, so *.html matches both index.html and docs/index.html. A leading /
+// anchors a pattern to the top level.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+
+	expr.WriteString("^")
+	if strings.Contains(pattern, "/") == false {
+		expr.WriteString("(.*/)?")
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.Compile(expr.String())
+}
@@ -0,0 +1,40 @@
+package s3sync
+
+import "testing"
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "*.html", name: "index.html", want: true},
+		{pattern: "*.html", name: "docs/index.html", want: true},
+		{pattern: "*.html", name: "index.htm"},
+		{pattern: "*.html", name: "index.html/file"},
+		{pattern: "/*.html", name: "index.html", want: true},
+		{pattern: "/*.html", name: "docs/index.html"},
+		{pattern: "docs/*.html", name: "docs/index.html", want: true},
+		{pattern: "docs/*.html", name: "docs/api/index.html"},
+		{pattern: "docs/*.html", name: "old/docs/index.html"},
+		{pattern: "docs/**/*.html", name: "docs/index.html", want: true},
+		{pattern: "docs/**/*.html", name: "docs/api/v1/index.html", want: true},
+		{pattern: "docs/**", name: "docs/api/v1/index.html", want: true},
+		{pattern: "img/?.png", name: "img/a.png", want: true},
+		{pattern: "img/?.png", name: "img/ab.png"},
+		{pattern: "img/?.png", name: "img//.png"},
+		{pattern: "a+b.(1).txt", name: "a+b.(1).txt", want: true},
+		{pattern: "a+b.(1).txt", name: "aab.(1).txt"},
+	}
+
+	for _, test := range tests {
+		pattern, err := compilePattern(test.pattern)
+		if err != nil {
+			t.Errorf("compilePattern(%q) returned %s", test.pattern, err)
+			continue
+		}
+		if got := pattern.MatchString(test.name); got != test.want {
+			t.Errorf("%q matching %q = %t, want %t", test.pattern, test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package s3sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// Plan is a saved diff which can be reviewed and then executed with Apply
+type Plan struct {
+	Created          time.Time         `json:"created"`
+	Source           string            `json:"source"`
+	Destination      string            `json:"destination"`
+	Delete           bool              `json:"delete"`
+	SourceCount      int               `json:"source_count"`
+	DestinationCount int               `json:"destination_count"`
+	Items            []s3diff.SyncItem `json:"items"`
+}
+
+// Plan diffs the source and destination and returns everything a sync would do without changing anything
+func (s *Syncer) Plan() (*Plan, error) {
+	var (
+		diffErrs chan error
+		err      error
+		item     s3diff.SyncItem
+		items    chan s3diff.SyncItem
+		plan     *Plan
+	)
+
+	if s.AllVersions == true {
+		return nil, fmt.Errorf("the AllVersions option cannot be planned, use Dryrun instead")
+	}
+
+	err = s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.init()
+	if err != nil {
+		return nil, err
+	}
+
+	plan = &Plan{
+		Created:     time.Now().UTC(),
+		Source:      s.Source,
+		Destination: s.Destination,
+		Delete:      s.Delete,
+		Items:       []s3diff.SyncItem{},
+	}
+
+	items = make(chan s3diff.SyncItem, s.MaxThreads)
+	diffErrs = make(chan error, 1)
+
+	go func() {
+		diffErrs <- s.Differ.Stream(items)
+	}()
+
+	for item = range items {
+		plan.Items = append(plan.Items, item)
+	}
+
+	err = <-diffErrs
+	if err != nil {
+		return nil, fmt.Errorf("unable to build the file list: %s", err)
+	}
+	plan.SourceCount = s.Differ.SourceCount
+	plan.DestinationCount = s.Differ.DestinationCount
+
+	return plan, nil
+}
+
+// Save writes the plan as indented JSON to a temp file that is renamed into place, so an
+// interrupted write never leaves a truncated plan behind
+func (p *Plan) Save(path string) error {
+	return WriteJSONFile(path, p)
+}
+
+// WritePlan writes a plan as indented JSON
+func WritePlan(w io.Writer, plan *Plan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+// ReadPlan reads a plan written by WritePlan
+func ReadPlan(r io.Reader) (*Plan, error) {
+	var plan Plan
+
+	err := json.NewDecoder(r).Decode(&plan)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the plan: %s", err)
+	}
+
+	return &plan, nil
+}
+
+// Apply executes a saved plan. Every item is checked against the state recorded when the plan was
+// made and nothing is changed if either side of any item has been modified since.
+func (s *Syncer) Apply(plan *Plan) (SyncResult, error) {
+	var (
+		err    error
+		result SyncResult
+	)
+
+	s.Source = plan.Source
+	s.Destination = plan.Destination
+	s.Delete = plan.Delete
+
+	err = s.connect()
+	if err != nil {
+		return result, err
+	}
+
+	err = s.init()
+	if err != nil {
+		return result, err
+	}
+
+	err = s.withLock(func() error {
+		var err error
+		result, err = s.apply(plan)
+		return err
+	})
+	return result, err
+}
+
+// apply checks and executes a plan once the Syncer is set up
+func (s *Syncer) apply(plan *Plan) (SyncResult, error) {
+	var (
+		before  transferSummary
+		changed []string
+		err     error
+		item    s3diff.SyncItem
+		items   chan s3diff.SyncItem
+	)
+
+	for _, item = range plan.Items {
+		switch item.Action {
+		case s3diff.ActionDelete:
+		case s3diff.ActionMove, s3diff.ActionRename:
+			// The file being moved is already on the destination
+			err = s.checkState(item.Source, "", item.SourceState, s.sseCustomerKey)
+			if err != nil {
+				changed = append(changed, fmt.Sprintf("%s: %s", item.Source, err))
+			}
+		default:
+			err = s.checkState(item.Source, item.VersionID, item.SourceState, s.sseCustomerSourceKey)
+			if err != nil {
+				changed = append(changed, fmt.Sprintf("%s: %s", item.Source, err))
+			}
+		}
+		err = s.checkState(item.Destination, "", item.DestinationState, s.sseCustomerKey)
+		if err != nil {
+			changed = append(changed, fmt.Sprintf("%s: %s", item.Destination, err))
+		}
+	}
+
+	if len(changed) > 0 {
+		return SyncResult{}, fmt.Errorf("refusing to apply the plan, %d file(s) changed since it was made:\n  %s", len(changed), strings.Join(changed, "\n  "))
+	}
+
+	if s.Delete == true && s.DeleteMode == DeleteBefore {
+		for _, item = range plan.Items {
+			if item.Action == s3diff.ActionDelete {
+				before.deletes = append(before.deletes, item)
+			}
+		}
+		err = s.deleteUpFront(&before, plan.SourceCount, plan.DestinationCount)
+		if err != nil {
+			return before.result(), err
+		}
+	}
+
+	items = make(chan s3diff.SyncItem, s.MaxThreads)
+	go func() {
+		defer close(items)
+		for _, item := range plan.Items {
+			items <- item
+		}
+	}()
+
+	return s.runSync(before, items, func() (int, int, error) {
+		return plan.SourceCount, plan.DestinationCount, nil
+	})
+}
+
+// checkState compares the current state of a local path or s3:// URL with the recorded state.
+// versionID selects a version of an object and customerKey is needed to read objects encrypted
+// with a customer key.
+func (s *Syncer) checkState(location string, versionID string, state *s3diff.State, customerKey string) error {
+	var (
+		current *s3diff.State
+		err     error
+	)
+
+	if state == nil {
+		return nil
+	}
+
+	current, err = s.currentState(location, versionID, customerKey)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case current.Exists != state.Exists && state.Exists:
+		return fmt.Errorf("no longer exists")
+	case current.Exists != state.Exists:
+		return fmt.Errorf("now exists")
+	case current.Exists == false:
+		return nil
+	case current.Size != state.Size:
+		return fmt.Errorf("size changed from %d to %d", state.Size, current.Size)
+	case current.ETag != state.ETag:
+		return fmt.Errorf("etag changed from %s to %s", state.ETag, current.ETag)
+	case current.ModTime.Equal(state.ModTime) == false:
+		return fmt.Errorf("mtime changed from %s to %s", state.ModTime, current.ModTime)
+	}
+
+	return nil
+}
+
+// currentState looks up the state of a local path or s3:// URL in the same form s3diff records it
+func (s *Syncer) currentState(location string, versionID string, customerKey string) (*s3diff.State, error) {
+	var (
+		err  error
+		head *s3.HeadObjectOutput
+		info os.FileInfo
+		u    *url.URL
+	)
+
+	u, err = url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "s3" {
+		head, err = s.headObject(u.Hostname(), strings.TrimLeft(u.Path, "/"), versionID, customerKey)
+		if err != nil {
+			if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 404 {
+				return &s3diff.State{Exists: false}, nil
+			}
+			return nil, err
+		}
+		return &s3diff.State{
+			Exists: true,
+			ETag:   strings.ReplaceAll(aws.StringValue(head.ETag), "\"", ""),
+			Size:   aws.Int64Value(head.ContentLength),
+		}, nil
+	}
+
+	info, err = os.Stat(location)
+	if os.IsNotExist(err) {
+		return &s3diff.State{Exists: false}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &s3diff.State{Exists: true, ModTime: info.ModTime(), Size: info.Size()}, nil
+}
@@ -0,0 +1,71 @@
+package s3sync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyRefusesChangedPlan(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(f *fakeS3)
+		want   string
+	}{
+		{name: "unchanged", change: func(f *fakeS3) {}},
+		{
+			name:   "source changed",
+			change: func(f *fakeS3) { f.put("source/data/new", &fakeObject{body: []byte("hello, world")}) },
+			want:   "s3://source/data/new: size changed",
+		},
+		{
+			name:   "destination created",
+			change: func(f *fakeS3) { f.put("destination/data/new", &fakeObject{body: []byte("other")}) },
+			want:   "s3://destination/data/new: now exists",
+		},
+		{
+			name: "extraneous file gone",
+			change: func(f *fakeS3) {
+				f.lock.Lock()
+				delete(f.objects, "destination/data/extra")
+				f.lock.Unlock()
+			},
+			want: "s3://destination/data/extra: no longer exists",
+		},
+	}
+
+	for _, test := range tests {
+		f := newFakeS3()
+		f.put("source/data/new", &fakeObject{body: []byte("hello")})
+		f.put("destination/data/extra", &fakeObject{body: []byte("goodbye")})
+
+		s := &Syncer{
+			Delete:      true,
+			Destination: "s3://destination/data",
+			MaxThreads:  2,
+			S3:          f.client(),
+			Source:      "s3://source/data",
+		}
+		plan := &Plan{Delete: true, Items: diffItems(t, s)}
+		plan.SourceCount = s.Differ.SourceCount
+		plan.DestinationCount = s.Differ.DestinationCount
+
+		test.change(f)
+		copies := len(f.received("PUT", ""))
+		result, err := s.apply(plan)
+
+		if test.want == "" {
+			if err != nil || result.Transferred != 1 || result.Deleted != 1 {
+				t.Errorf("%s: apply = %+v, %v, want 1 transfer and 1 delete", test.name, result, err)
+			}
+			f.close()
+			continue
+		}
+		if err == nil || strings.Contains(err.Error(), test.want) == false {
+			t.Errorf("%s: apply = %v, want an error containing %q", test.name, err, test.want)
+		}
+		if len(f.received("PUT", "")) != copies || len(f.received("POST", "delete")) > 0 {
+			t.Errorf("%s: the plan was applied after a file changed", test.name)
+		}
+		f.close()
+	}
+}
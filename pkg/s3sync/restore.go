@@ -0,0 +1,223 @@
+package s3sync
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// restorePollInterval is how long to wait between checks on objects being restored
+var restorePollInterval = time.Minute
+
+// errInvalidObjectState is returned when reading an archived object that has not been restored
+const errInvalidObjectState = "InvalidObjectState"
+
+// errRestoreInProgress is returned by RestoreObject when the object is already being restored
+const errRestoreInProgress = "RestoreAlreadyInProgress"
+
+// validateRestore checks the options for archived objects
+func (s *Syncer) validateRestore() error {
+	switch s.RestoreTier {
+	case "":
+		if s.RestoreDays != 0 || s.RestoreWait != 0 {
+			return fmt.Errorf("the RestoreDays and RestoreWait options require RestoreTier")
+		}
+		return nil
+	case s3.TierBulk, s3.TierStandard, s3.TierExpedited:
+	default:
+		return fmt.Errorf("invalid RestoreTier %q, must be one of %s, %s or %s", s.RestoreTier, s3.TierBulk, s3.TierStandard, s3.TierExpedited)
+	}
+
+	if s.SkipArchived == true {
+		return fmt.Errorf("the RestoreTier and SkipArchived options cannot be used together")
+	}
+
+	if s.RestoreDays < 0 {
+		return fmt.Errorf("the RestoreDays option cannot be negative")
+	}
+	if s.RestoreDays == 0 {
+		s.RestoreDays = 1
+	}
+
+	if s.RestoreWait < 0 {
+		return fmt.Errorf("the RestoreWait option cannot be negative")
+	}
+
+	return nil
+}
+
+// archived reports whether a job reads an object that has to be restored before it can be read
+func archived(job s3diff.SyncItem) bool {
+	if job.Action != s3diff.ActionCopy && job.Action != s3diff.ActionDownload {
+		return false
+	}
+	return job.StorageClass == s3.StorageClassGlacier || job.StorageClass == s3.StorageClassDeepArchive
+}
+
+// restoreArchived restores the source objects of archived jobs and passes each one to dispatch
+// once it can be read. With RestoreWait set it gives up after that long, returning the jobs that
+// are still being restored so a later run can finish them.
+func (s *Syncer) restoreArchived(archivedJobs []s3diff.SyncItem, summary *transferSummary, dispatch func(s3diff.SyncItem)) []s3diff.SyncItem {
+	var (
+		deadline time.Time
+		err      error
+		restored bool
+		still    []s3diff.SyncItem
+		waiting  []s3diff.SyncItem
+	)
+
+	for _, job := range archivedJobs {
+		if s.Dryrun == true {
+			dryrun(fmt.Sprintf("restore: %s (%s, %d day(s))", job.Source, s.RestoreTier, s.RestoreDays))
+			dispatch(job)
+			continue
+		}
+
+		restored, err = s.requestRestore(job)
+		if err != nil {
+			fmt.Printf("failed to restore %s: %s\n", job.Source, err)
+			summary.total++
+			summary.failed++
+			summary.failedBytes += job.Size
+			continue
+		}
+		if restored {
+			dispatch(job)
+			continue
+		}
+		waiting = append(waiting, job)
+	}
+
+	if len(waiting) == 0 {
+		return nil
+	}
+
+	fmt.Printf("waiting for %d archived object(s) to be restored\n", len(waiting))
+	if s.RestoreWait > 0 {
+		deadline = time.Now().Add(s.RestoreWait)
+	}
+
+	for len(waiting) > 0 && (deadline.IsZero() || time.Now().Before(deadline)) {
+		time.Sleep(restorePollInterval)
+
+		still = nil
+		for _, job := range waiting {
+			restored, err = s.restoreFinished(job)
+			if err != nil {
+				fmt.Printf("failed to check the restore of %s: %s\n", job.Source, err)
+			}
+			if restored {
+				dispatch(job)
+			} else {
+				still = append(still, job)
+			}
+		}
+		waiting = still
+	}
+
+	return waiting
+}
+
+// requestRestore starts restoring the source object of a job unless a restore is already running.
+// It reports whether the object has already been restored and can be read now.
+func (s *Syncer) requestRestore(job s3diff.SyncItem) (bool, error) {
+	var (
+		bucket string
+		err    error
+		key    string
+		head   *s3.HeadObjectOutput
+	)
+
+	bucket, key, err = splitS3URL(job.Source)
+	if err != nil {
+		return false, err
+	}
+
+	head, err = s.headObject(bucket, key, job.VersionID, s.sseCustomerSourceKey)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case strings.Contains(aws.StringValue(head.Restore), `ongoing-request="false"`):
+		return true, nil
+	case strings.Contains(aws.StringValue(head.Restore), `ongoing-request="true"`):
+		return false, nil
+	}
+
+	fmt.Printf("restore: %s (%s, %d day(s))\n", job.Source, s.RestoreTier, s.RestoreDays)
+	_, err = s.S3.RestoreObject(&s3.RestoreObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: versionID(job),
+		RestoreRequest: &s3.RestoreRequest{
+			Days: aws.Int64(int64(s.RestoreDays)),
+			GlacierJobParameters: &s3.GlacierJobParameters{
+				Tier: aws.String(s.RestoreTier),
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == errRestoreInProgress {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// restoreFinished reports whether the restore of a job's source object has completed
+func (s *Syncer) restoreFinished(job s3diff.SyncItem) (bool, error) {
+	bucket, key, err := splitS3URL(job.Source)
+	if err != nil {
+		return false, err
+	}
+
+	head, err := s.headObject(bucket, key, job.VersionID, s.sseCustomerSourceKey)
+	if err != nil {
+		return false, err
+	}
+
+	return strings.Contains(aws.StringValue(head.Restore), `ongoing-request="false"`), nil
+}
+
+// archivedHint explains how to deal with an archived object when err came from reading one
+func archivedHint(err error) error {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == errInvalidObjectState {
+		return fmt.Errorf("the object is archived, use --restore-tier to restore it or --skip-archived to skip it: %s", err)
+	}
+	return err
+}
+
+// reportPending lists the archived objects a run left behind
+func reportPending(message string, jobs []s3diff.SyncItem) {
+	if len(jobs) == 0 {
+		return
+	}
+
+	fmt.Printf("%d archived object(s) %s:\n", len(jobs), message)
+	for _, job := range jobs {
+		fmt.Printf("  %s\n", job.Source)
+	}
+}
+
+// versionID returns the version of a job's source object for an SDK input, nil for the latest
+func versionID(job s3diff.SyncItem) *string {
+	if job.VersionID == "" {
+		return nil
+	}
+	return aws.String(job.VersionID)
+}
+
+// splitS3URL returns the bucket and key of an s3:// URL
+func splitS3URL(location string) (string, string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	return u.Hostname(), strings.TrimLeft(u.Path, "/"), nil
+}
@@ -0,0 +1,161 @@
+package s3sync
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// archivedJob downloads the GLACIER object bucket/data/name
+func archivedJob(name string) s3diff.SyncItem {
+	return s3diff.SyncItem{
+		Action:       s3diff.ActionDownload,
+		Destination:  "/tmp/" + name,
+		Size:         4,
+		Source:       "s3://bucket/data/" + name,
+		StorageClass: s3.StorageClassGlacier,
+	}
+}
+
+func TestRestoreArchived(t *testing.T) {
+	defer func(interval time.Duration) {
+		restorePollInterval = interval
+	}(restorePollInterval)
+	restorePollInterval = time.Millisecond
+
+	glacier := map[string]string{"X-Amz-Storage-Class": s3.StorageClassGlacier}
+	restored := map[string]string{"X-Amz-Storage-Class": s3.StorageClassGlacier, "X-Amz-Restore": `ongoing-request="false", expiry-date="Fri, 23 Dec 2026 00:00:00 GMT"`}
+	ongoing := map[string]string{"X-Amz-Storage-Class": s3.StorageClassGlacier, "X-Amz-Restore": `ongoing-request="true"`}
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		missing    bool
+		finish     bool
+		dispatched bool
+		pending    bool
+		failed     int
+		requested  int
+	}{
+		{name: "already restored", headers: restored, dispatched: true},
+		{name: "restored while waiting", headers: glacier, finish: true, dispatched: true, requested: 1},
+		{name: "still restoring at the deadline", headers: glacier, pending: true, requested: 1},
+		{name: "restore already in progress", headers: ongoing, pending: true},
+		{name: "missing object", missing: true, failed: 1},
+	}
+
+	for _, test := range tests {
+		var (
+			dispatched []string
+			summary    transferSummary
+		)
+
+		f := newFakeS3()
+		if test.missing == false {
+			f.put("bucket/data/file", &fakeObject{body: []byte("data"), headers: test.headers})
+		}
+
+		// The restore completes once it has been requested
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for deadline := time.Now().Add(5 * time.Second); test.finish && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+				if len(f.received("POST", "restore")) > 0 {
+					f.put("bucket/data/file", &fakeObject{body: []byte("data"), headers: restored})
+					return
+				}
+			}
+		}()
+
+		s := &Syncer{RestoreDays: 3, RestoreTier: s3.TierBulk, RestoreWait: 50 * time.Millisecond, S3: f.client()}
+		pending := s.restoreArchived([]s3diff.SyncItem{archivedJob("file")}, &summary, func(job s3diff.SyncItem) {
+			dispatched = append(dispatched, job.Source)
+		})
+		<-done
+
+		if (len(dispatched) == 1) != test.dispatched {
+			t.Errorf("%s: dispatched %v, want dispatched = %t", test.name, dispatched, test.dispatched)
+		}
+		if (len(pending) == 1) != test.pending {
+			t.Errorf("%s: pending %v, want pending = %t", test.name, pending, test.pending)
+		}
+		if summary.failed != test.failed || summary.failedBytes != int64(test.failed)*4 {
+			t.Errorf("%s: %d failed (%d bytes), want %d", test.name, summary.failed, summary.failedBytes, test.failed)
+		}
+
+		requests := f.received("POST", "restore")
+		if len(requests) != test.requested {
+			t.Errorf("%s: requested %d restore(s), want %d", test.name, len(requests), test.requested)
+		}
+		f.close()
+	}
+}
+
+func TestRestoreArchivedVersions(t *testing.T) {
+	var dispatched []string
+
+	defer func(interval time.Duration) {
+		restorePollInterval = interval
+	}(restorePollInterval)
+	restorePollInterval = time.Millisecond
+
+	f := newFakeS3()
+	defer f.close()
+	f.put("bucket/data/a", &fakeObject{body: []byte("data"), headers: map[string]string{"X-Amz-Storage-Class": s3.StorageClassDeepArchive}})
+	f.put("bucket/data/b", &fakeObject{body: []byte("data"), headers: map[string]string{"X-Amz-Storage-Class": s3.StorageClassGlacier}})
+
+	a := archivedJob("a")
+	a.StorageClass = s3.StorageClassDeepArchive
+	a.VersionID = "v1"
+	b := archivedJob("b")
+
+	s := &Syncer{RestoreDays: 3, RestoreTier: s3.TierStandard, RestoreWait: time.Millisecond, S3: f.client()}
+	pending := s.restoreArchived([]s3diff.SyncItem{a, b}, &transferSummary{}, func(job s3diff.SyncItem) {
+		dispatched = append(dispatched, job.Source)
+	})
+	if len(dispatched) > 0 {
+		t.Errorf("dispatched %v before the restores finished", dispatched)
+	}
+
+	var sources []string
+	for _, job := range pending {
+		sources = append(sources, job.Source)
+	}
+	sort.Strings(sources)
+	if want := []string{"s3://bucket/data/a", "s3://bucket/data/b"}; reflect.DeepEqual(sources, want) == false {
+		t.Errorf("pending = %v, want %v", sources, want)
+	}
+
+	versions := make(map[string]string)
+	for _, r := range f.received("POST", "restore") {
+		versions[r.path] = r.query.Get("versionId")
+	}
+	if want := map[string]string{"/bucket/data/a": "v1", "/bucket/data/b": ""}; reflect.DeepEqual(versions, want) == false {
+		t.Errorf("restored versions %v, want %v", versions, want)
+	}
+}
+
+func TestArchived(t *testing.T) {
+	tests := []struct {
+		action       s3diff.Action
+		storageClass string
+		want         bool
+	}{
+		{action: s3diff.ActionDownload, storageClass: s3.StorageClassGlacier, want: true},
+		{action: s3diff.ActionCopy, storageClass: s3.StorageClassDeepArchive, want: true},
+		{action: s3diff.ActionDownload, storageClass: s3.StorageClassStandardIa},
+		{action: s3diff.ActionDelete, storageClass: s3.StorageClassGlacier},
+		{action: s3diff.ActionUpload, storageClass: s3.StorageClassGlacier},
+	}
+
+	for _, test := range tests {
+		got := archived(s3diff.SyncItem{Action: test.action, StorageClass: test.storageClass})
+		if got != test.want {
+			t.Errorf("archived(%s %s) = %t, want %t", test.action, test.storageClass, got, test.want)
+		}
+	}
+}
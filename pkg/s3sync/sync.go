@@ -1,64 +1,164 @@
 package s3sync
 
 import (
-	"io"
-	"net/url"
-	"os"
-	"path/filepath"
-	"strings"
+	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/gabriel-vasile/mimetype"
 	"github.com/gdanko/golang-s3sync/pkg/s3diff"
-
-	"fmt"
 )
 
 // Syncer holds information about how to sync
 type Syncer struct {
-	ACL         string
-	Debug       bool
-	Delete      bool
-	Destination string
-	Differ      *s3diff.Differ
-	Downloader  *s3manager.Downloader
-	Dryrun      bool
-	MaxThreads  int
-	Profile     string
-	Region      string
-	Source      string
-	S3          *s3.S3
-	Uploader    *s3manager.Uploader
-	Verify      bool
+	ACL                  string
+	AllVersions          bool
+	AsOf                 time.Time
+	Backup               bool
+	BackupDir            string
+	CompareMetadata      bool
+	CompareStorageClass  bool
+	CompareTags          bool
+	Confirm              bool
+	ContentType          string
+	ContentTypeResolvers []ContentTypeResolver
+	ContentTypeRules     []ContentTypeRule
+	Debug                bool
+	Dedup                bool
+	Delete               bool
+	DeleteMode           string
+	Destination          string
+	DetectRenames        bool
+	Differ               *s3diff.Differ
+	Downloader           *s3manager.Downloader
+	Dryrun               bool
+	GrantFullControl     string
+	GrantRead            string
+	Hardlink             bool
+	HashCache            string
+	HeaderRules          []HeaderRule
+	Inplace              bool
+	ItemizeChanges       bool
+	Lock                 bool
+	LockOwner            string
+	LockTTL              time.Duration
+	MaxDelete            int
+	MaxDeletePercent     float64
+	MaxThreads           int
+	Metadata             map[string]string
+	MimeTypes            string
+	Preserve             []string
+	PreserveACL          bool
+	Profile              string
+	Region               string
+	RestoreDays          int
+	RestoreTier          string
+	RestoreWait          time.Duration
+	SSE                  string
+	SSECustomerKey       string
+	SSECustomerSourceKey string
+	SSEKMSKeyID          string
+	SkipArchived         bool
+	Source               string
+	SourceBucket         string
+	StorageClass         string
+	Suffix               string
+	Tags                 map[string]string
+	S3                   *s3.S3
+	Uploader             *s3manager.Uploader
+	Verify               bool
+	VersionManifest      string
+
+	aclsDisabled         int32
+	backupTime           time.Time
+	contentTypes         []ContentTypeResolver
+	copyContentTypes     []ContentTypeResolver
+	headerRules          []headerRule
+	lease                *lease
+	sseCustomerKey       string
+	sseCustomerSourceKey string
 }
 
 // SyncOuput will hold the output information for each synced item
 type SyncOutput struct {
 	Message string
+	Size    int64
 	Status  string
 }
 
-var (
-	err   error
-	input *s3.ListBucketsInput
-	sess  *session.Session
+// SyncResult counts the files a sync handled and their size in bytes. Transferred counts every
+// file copied, downloaded, uploaded or moved, and Skipped counts archived files that were skipped
+// or are still being restored.
+type SyncResult struct {
+	Deleted          int   `json:"deleted"`
+	DeletedBytes     int64 `json:"deleted_bytes"`
+	DeleteFailed     int   `json:"delete_failed"`
+	Failed           int   `json:"failed"`
+	FailedBytes      int64 `json:"failed_bytes"`
+	Skipped          int   `json:"skipped"`
+	SkippedBytes     int64 `json:"skipped_bytes"`
+	Transferred      int   `json:"transferred"`
+	TransferredBytes int64 `json:"transferred_bytes"`
+}
+
+// The values of SyncOutput.Status
+const (
+	StatusFailed = "failed"
+	StatusOK     = "ok"
 )
 
-// Sync initializes the Differ, triggers the diff, and performs the sync
-func (s *Syncer) Sync() error {
-	err = s.validate()
+// The values of Syncer.DeleteMode, which decide when files are deleted from the destination
+const (
+	// DeleteAfter deletes once every transfer has finished, and not at all if any of them failed
+	DeleteAfter = "after"
+	// DeleteBefore deletes before anything is transferred, which needs an extra pass over the listing
+	DeleteBefore = "before"
+	// DeleteDuring deletes in batches while the transfers are running
+	DeleteDuring = "during"
+)
+
+// Sync initializes the Differ, triggers the diff, and performs the sync. The result counts what
+// was done even when an error is returned.
+func (s *Syncer) Sync() (SyncResult, error) {
+	var (
+		err    error
+		result SyncResult
+	)
+
+	err = s.connect()
 	if err != nil {
-		return err
+		return result, err
 	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(s.Region),
-		// Credentials: credentials.NewSharedCredentials("", s.Profile),
+	err = s.init()
+	if err != nil {
+		return result, err
+	}
+
+	err = s.withLock(func() error {
+		var err error
+		if s.AllVersions == true {
+			result, err = s.replicateVersions()
+			return err
+		}
+		result, err = s.syncFiles()
+		return err
 	})
+	return result, err
+}
+
+// connect validates the options and sets up the s3 clients
+func (s *Syncer) connect() error {
+	var err error
 
+	err = s.validate()
+	if err != nil {
+		return err
+	}
+
+	sess, err := s.newSession(aws.Config{})
 	if err != nil {
 		return err
 	}
@@ -67,29 +167,50 @@ func (s *Syncer) Sync() error {
 	s.Uploader = s3manager.NewUploaderWithClient(s.S3)
 	s.Downloader = s3manager.NewDownloaderWithClient(s.S3)
 
-	input = &s3.ListBucketsInput{}
-
 	// Make sure we can connect with the provided credentials
-	_, err = s.S3.ListBuckets(input)
+	_, err = s.S3.ListBuckets(&s3.ListBucketsInput{})
 	if err != nil {
 		// https://docs.aws.amazon.com/sdk-for-go/api/service/s3/#S3.ListBuckets
 		return fmt.Errorf("aws was not able to validate the provided access credentials")
 	}
 
-	s.init()
-	// prettyPrint(s.Differ.SyncList, true)
-	s.syncFiles()
-
 	return nil
 }
 
+// newSession returns an AWS session for the Region and Profile with the rest of config
+func (s *Syncer) newSession(config aws.Config) (*session.Session, error) {
+	config.Region = aws.String(s.Region)
+	options := session.Options{Config: config}
+	// A named profile is loaded from the shared config in place of the default credential chain,
+	// which still reads the environment for the default profile
+	if s.Profile != "" && s.Profile != "default" {
+		options.Profile = s.Profile
+	}
+
+	return session.NewSessionWithOptions(options)
+}
+
 func (s *Syncer) init() error {
+	var err error
+
 	s.Differ = &s3diff.Differ{
-		Source:      s.Source,
-		Destination: s.Destination,
-		S3:          s.S3,
-		Delete:      s.Delete,
-		Debug:       s.Debug,
+		Source:        s.Source,
+		Destination:   s.Destination,
+		S3:            s.S3,
+		Delete:        s.Delete,
+		Debug:         s.Debug,
+		HashCache:     s.HashCache,
+		HashThreads:   s.MaxThreads,
+		DetectRenames: s.DetectRenames,
+		Dedup:         s.Dedup,
+		// Objects written with KMS or a customer key, or read with one, do not have md5 ETags
+		OpaqueETags: s.opaqueETags() || s.sseCustomerSourceKey != "",
+	}
+	if s.CompareStorageClass == true {
+		s.Differ.StorageClass = s.StorageClass
+		if s.Differ.StorageClass == "" {
+			s.Differ.StorageClass = "STANDARD"
+		}
 	}
 
 	err = s.Differ.DetermineTypes()
@@ -97,269 +218,520 @@ func (s *Syncer) init() error {
 		return err
 	}
 
+	if s.Differ.SourceType == "local" && s.Differ.DestinationType == "local" {
+		return fmt.Errorf("at least one of the Source and Destination must be in s3")
+	}
+
 	if s.Differ.SourceType == "s3" {
 		s.SourceBucket = s.Differ.SourceBucket
 	}
 
-	s.Differ.Diff()
-	s.Differ.GenerateSyncList()
+	if s.Differ.DestinationType == "s3" && (len(s.headerRules) > 0 || s.CompareTags == true || s.CompareMetadata == true) {
+		s.Differ.MetadataChanged = s.metadataChanged
+	}
+
+	if s.Differ.SourceType == "s3" && s.Differ.DestinationType == "local" && s.preserves(PreserveTimes) {
+		s.Differ.SourceMtime = s.sourceMtime
+	}
+
+	if s.AsOf.IsZero() == false {
+		if s.Differ.SourceType != "s3" {
+			return fmt.Errorf("the AsOf option can only be used with an s3 Source")
+		}
+		s.Differ.AsOf = s.AsOf
+	}
+
+	if s.Dedup == true && s.Differ.SourceType == "s3" && s.Differ.DestinationType == "s3" {
+		return fmt.Errorf("the Dedup option only applies to uploads and downloads, s3 to s3 copies are already server side")
+	}
+
+	if s.Hardlink == true && s.Differ.DestinationType != "local" {
+		return fmt.Errorf("the Hardlink option can only be used with a local Destination")
+	}
+
+	if s.AllVersions == true && (s.Differ.SourceType != "s3" || s.Differ.DestinationType != "s3") {
+		return fmt.Errorf("the AllVersions option can only be used when both the Source and Destination are in s3")
+	}
+
+	if s.PreserveACL == true && (s.Differ.SourceType != "s3" || s.Differ.DestinationType != "s3") {
+		return fmt.Errorf("the PreserveACL option can only be used when both the Source and Destination are in s3")
+	}
+
+	// Another sync's lock is never copied or deleted, whether or not this one locks
+	s.Differ.SourceIgnore = []string{LockName}
+	s.Differ.DestinationIgnore = []string{LockName}
+
+	if s.backupEnabled() == true {
+		s.backupTime = time.Now().UTC()
+		s.Differ.DestinationIgnore = append(s.Differ.DestinationIgnore, s.backupIgnore()...)
+	}
 
 	return nil
 }
 
-func (s *Syncer) syncFiles() error {
+// syncFiles streams the diff into a pool of transfer workers. Deletes are made according to
+// DeleteMode and are skipped entirely if the diff could not be completed.
+func (s *Syncer) syncFiles() (SyncResult, error) {
 	var (
-		// name string
-		job       s3diff.SyncItem
-		jobs      chan s3diff.SyncItem
-		obj       s3diff.SyncItem
-		results   chan string
-		totalJobs int
+		before   transferSummary
+		diffErrs chan error
+		err      error
+		items    chan s3diff.SyncItem
 	)
 
-	if len(s.Differ.SyncList) > 0 {
-		actions := map[string][]s3diff.SyncItem{
-			"copy":     []s3diff.SyncItem{},
-			"download": []s3diff.SyncItem{},
-			"upload":   []s3diff.SyncItem{},
-			"delete":   []s3diff.SyncItem{},
+	if s.Delete == true && s.DeleteMode == DeleteBefore {
+		err = s.deleteBefore(&before)
+		if err != nil {
+			return before.result(), err
 		}
+	}
+
+	items = make(chan s3diff.SyncItem, s.MaxThreads)
+	diffErrs = make(chan error, 1)
+
+	go func() {
+		diffErrs <- s.Differ.Stream(items)
+	}()
 
-		for _, obj = range s.Differ.SyncList {
-			action := obj.Action
-			actions[action] = append(actions[action], obj)
+	return s.runSync(before, items, func() (int, int, error) {
+		err := <-diffErrs
+		if err != nil {
+			return 0, 0, fmt.Errorf("unable to build the file list, skipping deletes: %s", err)
 		}
+		return s.Differ.SourceCount, s.Differ.DestinationCount, nil
+	})
+}
 
-		if len(actions["copy"]) > 0 {
-			totalJobs = len(actions["copy"])
-			jobs = make(chan s3diff.SyncItem, totalJobs)
-			results = make(chan string, totalJobs)
+// runSync transfers items and makes the deletes they leave according to DeleteMode, adding to what
+// a DeleteBefore pass already did in before. Once items is closed, counts returns the source and
+// destination counts the delete safeguards measure against, or an error if the items are
+// incomplete and nothing may be deleted.
+func (s *Syncer) runSync(before transferSummary, items <-chan s3diff.SyncItem, counts func() (int, int, error)) (SyncResult, error) {
+	var (
+		destinationCount int
+		err              error
+		sourceCount      int
+		summary          transferSummary
+	)
 
-			for w := 1; w <= s.MaxThreads; w++ {
-				go s.s3ToS3(w, jobs, results)
-			}
+	summary = s.transfer(items)
+	summary.deleted += before.deleted
+	summary.deletedBytes += before.deletedBytes
+	summary.deleteFailed += before.deleteFailed
 
-			for _, job = range actions["copy"] {
-				jobs <- job
-			}
-			close(jobs)
+	sourceCount, destinationCount, err = counts()
+	if err != nil {
+		return summary.result(), err
+	}
 
-			for _, _ = range actions["copy"] {
-				<-results
-			}
-		}
+	err = s.finishDeletes(&summary, sourceCount, destinationCount)
+	if err != nil {
+		return summary.result(), err
+	}
 
-		if len(actions["download"]) > 0 {
-			totalJobs = len(actions["download"])
-			jobs = make(chan s3diff.SyncItem, totalJobs)
-			results = make(chan string, totalJobs)
+	if summary.total == 0 && summary.deleted == 0 && len(summary.deletes) == 0 && len(summary.skipped) == 0 && len(summary.restoring) == 0 {
+		fmt.Println("sync status: OK")
+	}
 
-			for w := 1; w <= s.MaxThreads; w++ {
-				go s.s3ToLocal(w, jobs, results)
-			}
+	if summary.failed > 0 {
+		return summary.result(), fmt.Errorf("%d of %d transfer(s) failed", summary.failed, summary.total)
+	}
+
+	return summary.result(), nil
+}
+
+// transferSummary is what transfer did along with the deletes it left for the caller
+type transferSummary struct {
+	deleted      int
+	deletedBytes int64
+	deleteErr    error
+	deleteFailed int
+	deletes      []s3diff.SyncItem
+	doneBytes    int64
+	duplicates   int
+	failed       int
+	failedBytes  int64
+	moved        int
+	restoring    []s3diff.SyncItem
+	skipped      []s3diff.SyncItem
+	synced       int64
+	total        int
+	transferred  int64
+}
+
+// count adds a job sent to the workers to the byte totals. Moves, duplicates and updates are
+// synced without their content being transferred.
+func (t *transferSummary) count(job s3diff.SyncItem) {
+	t.synced += job.Size
+
+	switch {
+	case job.Action == s3diff.ActionMove || job.Action == s3diff.ActionRename:
+		t.moved++
+	case job.DuplicateOf != "":
+		t.duplicates++
+	case job.Reason == s3diff.ReasonMetadataChanged || job.Reason == s3diff.ReasonStorageClassChanged:
+	default:
+		t.transferred += job.Size
+	}
+}
+
+// record counts the result of a job the workers finished
+func (t *transferSummary) record(result SyncOutput) {
+	if result.Status == StatusFailed {
+		t.failed++
+		t.failedBytes += result.Size
+		return
+	}
+	t.doneBytes += result.Size
+}
+
+// result returns the counts a caller of Sync sees
+func (t *transferSummary) result() SyncResult {
+	var result SyncResult
+
+	result = SyncResult{
+		Deleted:          t.deleted,
+		DeletedBytes:     t.deletedBytes,
+		DeleteFailed:     t.deleteFailed,
+		Failed:           t.failed,
+		FailedBytes:      t.failedBytes,
+		Transferred:      t.total - t.failed,
+		TransferredBytes: t.doneBytes,
+	}
+	for _, job := range append(t.skipped, t.restoring...) {
+		result.Skipped++
+		result.SkippedBytes += job.Size
+	}
+
+	return result
+}
+
+// report prints how much data moves and duplicates saved from being transferred
+func (t *transferSummary) report() {
+	if t.moved > 0 {
+		fmt.Printf("%d file(s) moved instead of transferred\n", t.moved)
+	}
+	if t.duplicates > 0 {
+		fmt.Printf("%d duplicate file(s) copied instead of transferred\n", t.duplicates)
+	}
+	if t.moved > 0 || t.duplicates > 0 {
+		fmt.Printf("synced %d bytes, transferred %d bytes\n", t.synced, t.transferred)
+	}
+}
+
+// transfer runs every copy, download or upload read from items on a pool of MaxThreads workers.
+// With DeleteDuring, deletes are made in batches as they are found once the source is known not
+// to be empty, otherwise they are returned in the summary for the caller. Archived objects are
+// skipped or restored once everything else has been sent to the workers.
+func (s *Syncer) transfer(items <-chan s3diff.SyncItem) transferSummary {
+	var (
+		archivedJobs  []s3diff.SyncItem
+		dispatch      func(s3diff.SyncItem)
+		duplicateJobs []s3diff.SyncItem
+		job           s3diff.SyncItem
+		jobs          chan s3diff.SyncItem
+		pending       int
+		result        SyncOutput
+		results       chan SyncOutput
+		summary       transferSummary
+		wait          func()
+		worker        func(int, <-chan s3diff.SyncItem, chan<- SyncOutput)
+	)
+
+	switch {
+	case s.Differ.SourceType == "s3" && s.Differ.DestinationType == "s3":
+		worker = s.s3ToS3
+	case s.Differ.SourceType == "s3" && s.Differ.DestinationType == "local":
+		worker = s.s3ToLocal
+	default:
+		worker = s.localToS3
+	}
+
+	jobs = make(chan s3diff.SyncItem, s.MaxThreads)
+	results = make(chan SyncOutput, s.MaxThreads)
 
-			for _, job = range actions["download"] {
-				jobs <- job
+	for w := 1; w <= s.MaxThreads; w++ {
+		go worker(w, jobs, results)
+	}
+
+	// dispatch hands a job to the workers, collecting results while it waits for one to be free
+	dispatch = func(job s3diff.SyncItem) {
+		summary.total++
+		summary.count(job)
+		pending++
+		for sent := false; sent == false; {
+			select {
+			case jobs <- job:
+				sent = true
+			case result = <-results:
+				pending--
+				summary.record(result)
 			}
-			close(jobs)
+		}
+	}
 
-			for _, _ = range actions["download"] {
-				<-results
+	for job = range items {
+		if job.Action == s3diff.ActionDelete {
+			if s.Delete == false || s.DeleteMode == DeleteBefore {
+				continue
 			}
+			summary.deletes = append(summary.deletes, job)
+			// A transfer proves the source listing is not empty, so deletes can go ahead
+			if s.DeleteMode == DeleteDuring && summary.total > 0 && len(summary.deletes) >= deleteBatchSize {
+				s.deleteDuring(&summary)
+			}
+			continue
 		}
 
-		if len(actions["upload"]) > 0 {
-			totalJobs = len(actions["upload"])
-			jobs = make(chan s3diff.SyncItem, totalJobs)
-			results = make(chan string, totalJobs)
+		if archived(job) && (s.SkipArchived == true || s.RestoreTier != "") {
+			archivedJobs = append(archivedJobs, job)
+			continue
+		}
 
-			for w := 1; w <= s.MaxThreads; w++ {
-				go s.localToS3(w, jobs, results)
-			}
+		// Duplicates are produced from the destination of an earlier transfer, which has to finish first
+		if job.DuplicateOf != "" {
+			duplicateJobs = append(duplicateJobs, job)
+			continue
+		}
 
-			for _, job = range actions["upload"] {
-				jobs <- job
-			}
-			close(jobs)
+		dispatch(job)
+	}
 
-			for _, _ = range actions["upload"] {
-				<-results
-			}
+	// wait collects the results of every job sent to the workers so far
+	wait = func() {
+		for ; pending > 0; pending-- {
+			summary.record(<-results)
 		}
+	}
 
-		if len(actions["delete"]) > 0 {
-			s.deleteFiles(actions["delete"])
+	if s.SkipArchived == true {
+		summary.skipped = archivedJobs
+	} else if len(archivedJobs) > 0 {
+		summary.restoring = s.restoreArchived(archivedJobs, &summary, dispatch)
+	}
+
+	if len(duplicateJobs) > 0 {
+		wait()
+		for _, job = range duplicateJobs {
+			dispatch(job)
 		}
+	}
+	close(jobs)
+	wait()
 
-	} else {
-		fmt.Println("sync status: OK")
+	summary.report()
+	reportPending("were skipped", summary.skipped)
+	reportPending("are still being restored, run the sync again once they are to finish", summary.restoring)
+
+	return summary
+}
+
+// deleteDuring deletes the batch collected so far while applying MaxDelete to the running total
+func (s *Syncer) deleteDuring(summary *transferSummary) {
+	if summary.deleteErr != nil {
+		summary.deletes = nil
+		return
+	}
+
+	if s.MaxDelete > 0 && summary.deleted+summary.deleteFailed+len(summary.deletes) > s.MaxDelete {
+		summary.deleteErr = fmt.Errorf("stopped deleting after %d file(s), more than --max-delete %d would be deleted", summary.deleted, s.MaxDelete)
+		summary.deletes = nil
+		return
+	}
+
+	s.deletePending(summary)
+}
+
+// deletePending deletes every delete in the summary and counts how many were and weren't deleted
+func (s *Syncer) deletePending(summary *transferSummary) {
+	failed := s.deleteFiles(summary.deletes)
+	summary.deleted += len(summary.deletes) - len(failed)
+	summary.deleteFailed += len(failed)
+	for _, job := range summary.deletes {
+		summary.deletedBytes += job.Size
 	}
+	for _, job := range failed {
+		summary.deletedBytes -= job.Size
+	}
+	summary.deletes = nil
+}
+
+// finishDeletes makes the deletes transfer left behind. With DeleteAfter they are skipped if any
+// transfer failed so the destination is never left missing files that could not be replaced. It
+// returns an error if any delete made during the run failed.
+func (s *Syncer) finishDeletes(summary *transferSummary, sourceCount int, destinationCount int) error {
+	if summary.deleteErr != nil {
+		return summary.deleteErr
+	}
+
+	switch {
+	case len(summary.deletes) == 0:
+	case s.DeleteMode == DeleteDuring:
+		if sourceCount == 0 {
+			return fmt.Errorf("refusing to delete %d file(s) because the source listing is empty", len(summary.deletes))
+		}
+		s.deleteDuring(summary)
+		if summary.deleteErr != nil {
+			return summary.deleteErr
+		}
+	case summary.failed > 0:
+		return fmt.Errorf("%d transfer(s) failed, skipping %d delete(s)", summary.failed, len(summary.deletes))
+	default:
+		err := s.checkDeletes(summary.deletes, sourceCount, destinationCount)
+		if err != nil {
+			return err
+		}
+		s.deletePending(summary)
+	}
+
+	if summary.deleteFailed > 0 {
+		return fmt.Errorf("%d of %d delete(s) failed", summary.deleteFailed, summary.deleted+summary.deleteFailed)
+	}
+
 	return nil
 }
 
-func (s *Syncer) s3ToS3(id int, jobs <-chan s3diff.SyncItem, results chan<- string) {
+// deleteBefore runs a full diff for its deletes alone and makes them before any file is
+// transferred, counting them in summary
+func (s *Syncer) deleteBefore(summary *transferSummary) error {
 	var (
-		destination       *url.URL
-		destinationBucket string
-		destinationKey    string
-		err               error
-		job               s3diff.SyncItem
-		mimeType          string
-		mt                *mimetype.MIME
-		source            *url.URL
-		sourceBucket      string
-		sourceFile        string
-		sourceKey         string
+		diffErrs chan error
+		item     s3diff.SyncItem
+		items    chan s3diff.SyncItem
 	)
 
-	for job = range jobs {
-		if s.Dryrun == true {
-			dryrun(job.Message)
-			results <- "Done!"
-		} else {
-			fmt.Println(job.Message)
-			source, err = url.Parse(job.Source)
-			if err != nil {
-				panic(err)
-			}
-			sourceBucket = source.Hostname()
-			sourceKey = strings.TrimLeft(source.Path, string(os.PathSeparator))
-			sourceFile = sourceBucket + string(os.PathSeparator) + sourceKey
-
-			destination, err = url.Parse(job.Destination)
-			if err != nil {
-				panic(err)
-			}
-			destinationBucket = destination.Hostname()
-			destinationKey = strings.TrimLeft(destination.Path, string(os.PathSeparator))
+	items = make(chan s3diff.SyncItem, s.MaxThreads)
+	diffErrs = make(chan error, 1)
 
-			mt, err = mimetype.DetectFile(job.Source)
-			if err != nil {
-				mimeType = "application/octet-stream"
-			} else {
-				mimeType = mt.String()
-			}
+	go func() {
+		diffErrs <- s.Differ.Stream(items)
+	}()
 
-			_, err = s.S3.CopyObject(&s3.CopyObjectInput{
-				ACL:         &s.ACL,
-				Bucket:      &destinationBucket,
-				ContentType: &mimeType,
-				CopySource:  &sourceFile,
-				Key:         &destinationKey,
-			})
+	for item = range items {
+		if item.Action == s3diff.ActionDelete {
+			summary.deletes = append(summary.deletes, item)
 		}
 	}
+
+	err := <-diffErrs
+	if err != nil {
+		return fmt.Errorf("unable to build the file list, skipping deletes: %s", err)
+	}
+
+	return s.deleteUpFront(summary, s.Differ.SourceCount, s.Differ.DestinationCount)
+}
+
+// deleteUpFront checks and makes the deletes in summary before anything is transferred
+func (s *Syncer) deleteUpFront(summary *transferSummary, sourceCount int, destinationCount int) error {
+	if len(summary.deletes) == 0 {
+		return nil
+	}
+
+	err := s.checkDeletes(summary.deletes, sourceCount, destinationCount)
+	if err != nil {
+		return err
+	}
+	s.deletePending(summary)
+
+	return nil
 }
 
-func (s *Syncer) s3ToLocal(id int, jobs <-chan s3diff.SyncItem, results chan<- string) {
+func (s *Syncer) s3ToS3(id int, jobs <-chan s3diff.SyncItem, results chan<- SyncOutput) {
 	var (
-		destinationDirectory string
-		err                  error
-		job                  s3diff.SyncItem
-		source               *url.URL
-		sourceBucket         string
-		sourceKey            string
+		err error
+		job s3diff.SyncItem
 	)
 	for job = range jobs {
 		if s.Dryrun == true {
-			dryrun(job.Message)
-			results <- "Done!"
-		} else {
-			fmt.Println(job.Message)
-			source, err = url.Parse(job.Source)
-			if err != nil {
-				panic(err)
-			}
-			sourceBucket = source.Hostname()
-			sourceKey = strings.TrimLeft(source.Path, string(os.PathSeparator))
-			destinationDirectory = filepath.Dir(job.Destination)
-
-			err = os.MkdirAll(destinationDirectory, 0755)
-			if err != nil {
-				panic(err) // We should log failed files and not kill the entire job
-			}
-
-			f, err := os.Create(job.Destination)
-			if err != nil {
-				panic(err) // We should log failed files and not kill the entire job
-			}
-
-			_, err = s.Downloader.Download(f, &s3.GetObjectInput{
-				Bucket: &sourceBucket,
-				Key:    &sourceKey,
-			})
+			dryrun(s.message(job))
+			results <- SyncOutput{Message: job.Message, Size: job.Size, Status: StatusOK}
+			continue
+		}
 
-			if err != nil {
-				fmt.Printf("failed to download %s to %s: %s\n", job.Source, job.Destination, err)
-			}
+		fmt.Println(s.message(job))
+		if job.Action == s3diff.ActionMove {
+			err = s.move(job)
+		} else if job.Reason == s3diff.ReasonStorageClassChanged || job.Reason == s3diff.ReasonMetadataChanged {
+			err = s.update(job)
+		} else {
+			err = s.copy(job)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to copy %s to %s: %s", job.Source, job.Destination, archivedHint(err))
+			fmt.Println(err)
+			results <- SyncOutput{Message: err.Error(), Size: job.Size, Status: StatusFailed}
+			continue
 		}
-		results <- "Done!"
+		results <- SyncOutput{Message: job.Message, Size: job.Size, Status: StatusOK}
 	}
 }
 
-func (s *Syncer) localToS3(id int, jobs <-chan s3diff.SyncItem, results chan<- string) {
+func (s *Syncer) s3ToLocal(id int, jobs <-chan s3diff.SyncItem, results chan<- SyncOutput) {
 	var (
-		body              io.Reader
-		destination       *url.URL
-		destinationBucket string
-		destinationKey    string
-		err               error
-		job               s3diff.SyncItem
-		mimeType          string
-		mt                *mimetype.MIME
-		// resp        *s3manager.UploadOutput
+		err error
+		job s3diff.SyncItem
 	)
 	for job = range jobs {
 		if s.Dryrun == true {
-			dryrun(job.Message)
-			results <- "Done!"
-		} else {
-			fmt.Println(job.Message)
-			destination, err = url.Parse(job.Destination)
-			if err != nil {
-				panic(err)
-			}
-			destinationBucket = destination.Hostname()
-			destinationKey = strings.TrimLeft(destination.Path, string(os.PathSeparator))
-
-			mt, err = mimetype.DetectFile(job.Source)
-			if err != nil {
-				mimeType = "application/octet-stream"
-			} else {
-				mimeType = mt.String()
-			}
-
-			body, err = os.Open(job.Source)
-			if err != nil {
-				panic(err)
-			}
-
-			_, err = s.Uploader.Upload(&s3manager.UploadInput{
-				ACL:         &s.ACL,
-				Body:        body,
-				Bucket:      &destinationBucket,
-				ContentType: &mimeType,
-				Key:         &destinationKey,
-			})
+			dryrun(s.message(job))
+			results <- SyncOutput{Message: job.Message, Size: job.Size, Status: StatusOK}
+			continue
+		}
 
+		fmt.Println(s.message(job))
+		if job.Action == s3diff.ActionRename {
+			err = s.rename(job)
+		} else if job.DuplicateOf != "" {
+			err = s.linkDuplicate(job)
 			if err != nil {
-				fmt.Printf("failed to copy %s to %s\n", job.Source, job.Destination)
+				fmt.Printf("unable to copy %s from %s, downloading it instead: %s\n", job.Destination, job.DuplicateOf, err)
+				err = s.download(job)
 			}
+		} else {
+			err = s.download(job)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to download %s to %s: %s", job.Source, job.Destination, archivedHint(err))
+			fmt.Println(err)
+			results <- SyncOutput{Message: err.Error(), Size: job.Size, Status: StatusFailed}
+			continue
 		}
-		results <- "Done!"
+		results <- SyncOutput{Message: job.Message, Size: job.Size, Status: StatusOK}
 	}
 }
 
-func (s *Syncer) deleteFiles(fileList []s3diff.SyncItem) {
+func (s *Syncer) localToS3(id int, jobs <-chan s3diff.SyncItem, results chan<- SyncOutput) {
 	var (
+		err error
 		job s3diff.SyncItem
 	)
-
-	for _, job = range fileList {
+	for job = range jobs {
 		if s.Dryrun == true {
-			dryrun(job.Message)
+			dryrun(s.message(job))
+			results <- SyncOutput{Message: job.Message, Size: job.Size, Status: StatusOK}
+			continue
+		}
+
+		fmt.Println(s.message(job))
+		if job.Action == s3diff.ActionMove {
+			err = s.move(job)
+		} else if job.Reason == s3diff.ReasonStorageClassChanged || job.Reason == s3diff.ReasonMetadataChanged {
+			err = s.update(job)
+		} else if job.DuplicateOf != "" {
+			err = s.copyDuplicate(job)
+			if err != nil {
+				fmt.Printf("unable to copy %s from %s, uploading it instead: %s\n", job.Destination, job.DuplicateOf, err)
+				err = s.upload(job)
+			}
 		} else {
-			fmt.Println(job.Message)
+			err = s.upload(job)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to copy %s to %s: %s", job.Source, job.Destination, err)
+			fmt.Println(err)
+			results <- SyncOutput{Message: err.Error(), Size: job.Size, Status: StatusFailed}
+			continue
 		}
+		results <- SyncOutput{Message: job.Message, Size: job.Size, Status: StatusOK}
 	}
 }
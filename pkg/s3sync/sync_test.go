@@ -0,0 +1,49 @@
+package s3sync
+
+import "testing"
+
+func TestSyncFilesResult(t *testing.T) {
+	f := newFakeS3()
+	defer f.close()
+	f.put("source/data/new", &fakeObject{body: []byte("hello")})
+	f.put("source/data/archived", &fakeObject{body: []byte("old"), headers: map[string]string{"X-Amz-Storage-Class": "GLACIER"}})
+	f.put("destination/data/extra", &fakeObject{body: []byte("goodbye")})
+
+	s := &Syncer{
+		Delete:       true,
+		Destination:  "s3://destination/data",
+		MaxThreads:   2,
+		Region:       "us-east-1",
+		S3:           f.client(),
+		SkipArchived: true,
+		Source:       "s3://source/data",
+	}
+	err := s.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.syncFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := SyncResult{
+		Deleted:          1,
+		DeletedBytes:     7,
+		Skipped:          1,
+		SkippedBytes:     3,
+		Transferred:      1,
+		TransferredBytes: 5,
+	}
+	if result != want {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+	if f.get("destination/data/new") == nil || f.get("destination/data/extra") != nil {
+		t.Errorf("the destination was not synced")
+	}
+}
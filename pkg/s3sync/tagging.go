@@ -0,0 +1,183 @@
+package s3sync
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// maxTags is the most tags s3 allows on a single object
+const maxTags = 10
+
+// validateTagging checks the Tags and Metadata options
+func (s *Syncer) validateTagging() error {
+	if len(s.Tags) > maxTags {
+		return fmt.Errorf("at most %d Tags can be set on an object", maxTags)
+	}
+
+	for key := range s.Metadata {
+		switch strings.ToLower(key) {
+		case metaGID, metaMode, metaMtime, metaUID:
+			return fmt.Errorf("the %q Metadata key is reserved for file attributes", key)
+		}
+	}
+
+	return nil
+}
+
+// objectTags returns the tags on an object. An empty versionID reads the latest version.
+func (s *Syncer) objectTags(bucket string, key string, versionID string) (map[string]string, error) {
+	var tags map[string]string
+
+	input := &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	resp, err := s.S3.GetObjectTagging(input)
+	if err != nil {
+		return nil, err
+	}
+
+	tags = make(map[string]string)
+	for _, tag := range resp.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	return tags, nil
+}
+
+// desiredTags returns the tags a destination object should have: those of the source object for
+// s3 to s3 syncs or its current tags otherwise, with Tags added
+func (s *Syncer) desiredTags(sourceBucket string, sourceKey string, sourceVersion string, current map[string]string) (map[string]string, error) {
+	var (
+		base map[string]string
+		err  error
+	)
+
+	base = current
+	if s.Differ.SourceType == "s3" {
+		base, err = s.objectTags(sourceBucket, sourceKey, sourceVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mergeMaps(base, s.Tags), nil
+}
+
+// desiredMetadata returns the user metadata a destination object should have: that of the source
+// object for s3 to s3 syncs or its current metadata otherwise, with Metadata added
+func (s *Syncer) desiredMetadata(sourceBucket string, sourceKey string, sourceVersion string, current map[string]string) (map[string]string, error) {
+	var base map[string]string
+
+	base = current
+	if s.Differ.SourceType == "s3" {
+		head, err := s.headObject(sourceBucket, sourceKey, sourceVersion, s.sseCustomerSourceKey)
+		if err != nil {
+			return nil, err
+		}
+		base = metadataMap(head.Metadata)
+	}
+
+	return mergeMaps(base, metadataMap(awsMetadata(s.Metadata))), nil
+}
+
+// tagsChanged reports whether a destination object's tags differ from desiredTags
+func (s *Syncer) tagsChanged(source *s3diff.FileInfo, destination *s3diff.FileInfo) bool {
+	current, err := s.objectTags(s.Differ.DestinationBucket, destination.Key, "")
+	if err != nil {
+		return true
+	}
+
+	desired, err := s.desiredTags(s.Differ.SourceBucket, source.Key, source.VersionID, current)
+	if err != nil {
+		return true
+	}
+
+	return equalMaps(current, desired) == false
+}
+
+// userMetadataChanged reports whether a destination object's user metadata differs from desiredMetadata
+func (s *Syncer) userMetadataChanged(source *s3diff.FileInfo, destination *s3diff.FileInfo) bool {
+	head, err := s.headObject(s.Differ.DestinationBucket, destination.Key, "", s.sseCustomerKey)
+	if err != nil {
+		return true
+	}
+	current := metadataMap(head.Metadata)
+
+	desired, err := s.desiredMetadata(s.Differ.SourceBucket, source.Key, source.VersionID, current)
+	if err != nil {
+		return true
+	}
+
+	return equalMaps(current, desired) == false
+}
+
+// metadataChanged is the Differ's MetadataChanged hook. It reports header drift and, when
+// CompareTags or CompareMetadata are set, tag and user metadata differences.
+func (s *Syncer) metadataChanged(source *s3diff.FileInfo, destination *s3diff.FileInfo) bool {
+	return s.headersChanged(destination) ||
+		(s.CompareTags == true && s.tagsChanged(source, destination)) ||
+		(s.CompareMetadata == true && s.userMetadataChanged(source, destination))
+}
+
+// encodeTags returns tags in the query string form expected by the Tagging fields
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// metadataMap converts SDK user metadata to a map with lower case keys, since HEAD responses
+// capitalize them
+func metadataMap(metadata map[string]*string) map[string]string {
+	result := make(map[string]string)
+	for key, value := range metadata {
+		result[strings.ToLower(key)] = aws.StringValue(value)
+	}
+	return result
+}
+
+// awsMetadata converts a map to SDK user metadata
+func awsMetadata(metadata map[string]string) map[string]*string {
+	result := make(map[string]*string)
+	for key, value := range metadata {
+		result[key] = aws.String(value)
+	}
+	return result
+}
+
+// mergeMaps returns a copy of base with every entry of extra added
+func mergeMaps(base map[string]string, extra map[string]string) map[string]string {
+	result := make(map[string]string)
+	for key, value := range base {
+		result[key] = value
+	}
+	for key, value := range extra {
+		result[key] = value
+	}
+	return result
+}
+
+// equalMaps reports whether two maps hold the same entries
+func equalMaps(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; ok == false || other != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package s3sync
+
+import (
+	"testing"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+func TestTagAndMetadataChangesUpdateInPlace(t *testing.T) {
+	tests := []struct {
+		name        string
+		syncer      Syncer
+		source      *fakeObject
+		destination *fakeObject
+		header      string
+		want        string
+	}{
+		{
+			name:        "tags differ",
+			syncer:      Syncer{CompareTags: true},
+			source:      &fakeObject{body: []byte("data"), headers: map[string]string{}, tags: map[string]string{"team": "storage"}},
+			destination: &fakeObject{body: []byte("data"), headers: map[string]string{}},
+			header:      "X-Amz-Tagging",
+			want:        "team=storage",
+		},
+		{
+			name:        "metadata differs",
+			syncer:      Syncer{CompareMetadata: true},
+			source:      &fakeObject{body: []byte("data"), headers: map[string]string{"X-Amz-Meta-Owner": "someone"}},
+			destination: &fakeObject{body: []byte("data"), headers: map[string]string{}},
+			header:      "X-Amz-Meta-Owner",
+			want:        "someone",
+		},
+		{
+			name:        "tags match",
+			syncer:      Syncer{CompareTags: true},
+			source:      &fakeObject{body: []byte("data"), headers: map[string]string{}, tags: map[string]string{"team": "storage"}},
+			destination: &fakeObject{body: []byte("data"), headers: map[string]string{}, tags: map[string]string{"team": "storage"}},
+		},
+		{
+			name:        "tags differ without CompareTags",
+			source:      &fakeObject{body: []byte("data"), headers: map[string]string{}, tags: map[string]string{"team": "storage"}},
+			destination: &fakeObject{body: []byte("data"), headers: map[string]string{}},
+		},
+	}
+
+	for _, test := range tests {
+		f := newFakeS3()
+		f.put("source/data/a", test.source)
+		f.put("destination/data/a", test.destination)
+
+		s := test.syncer
+		s.Source = "s3://source/data"
+		s.Destination = "s3://destination/data"
+		s.S3 = f.client()
+		items := diffItems(t, &s)
+
+		if test.want == "" {
+			if len(items) != 0 {
+				t.Errorf("%s: %d item(s) to sync, want none", test.name, len(items))
+			}
+			f.close()
+			continue
+		}
+
+		if len(items) != 1 || items[0].Reason != s3diff.ReasonMetadataChanged {
+			t.Errorf("%s: items to sync = %+v, want one with reason %q", test.name, items, s3diff.ReasonMetadataChanged)
+			f.close()
+			continue
+		}
+
+		err := s.update(items[0])
+		if err != nil {
+			t.Errorf("%s: update returned %s", test.name, err)
+		}
+
+		copies := f.received("PUT", "")
+		if len(copies) != 1 {
+			t.Errorf("%s: made %d copies, want 1", test.name, len(copies))
+		} else {
+			copied := copies[0]
+			if copied.path != "/destination/data/a" || copied.header.Get("X-Amz-Copy-Source") != "destination/data/a" {
+				t.Errorf("%s: copied %s to %s, want the destination copied onto itself", test.name, copied.header.Get("X-Amz-Copy-Source"), copied.path)
+			}
+			if got := copied.header.Get(test.header); got != test.want {
+				t.Errorf("%s: %s = %q, want %q", test.name, test.header, got, test.want)
+			}
+		}
+		f.close()
+	}
+}
@@ -0,0 +1,79 @@
+package s3sync
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// upload sends a single local file to job.Destination
+func (s *Syncer) upload(job s3diff.SyncItem) error {
+	var (
+		body              *os.File
+		destination       *url.URL
+		destinationBucket string
+		destinationKey    string
+		err               error
+		info              os.FileInfo
+		mimeType          string
+		name              string
+	)
+
+	destination, err = url.Parse(job.Destination)
+	if err != nil {
+		return err
+	}
+	destinationBucket = destination.Hostname()
+	destinationKey = strings.TrimLeft(destination.Path, string(os.PathSeparator))
+
+	name = s.relativeName(job)
+	mimeType = resolveContentType(s.contentTypes, name, job.Source)
+
+	body, err = os.Open(job.Source)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	info, err = body.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = s.backupOverwritten(job)
+	if err != nil {
+		return err
+	}
+
+	input := &s3manager.UploadInput{
+		Body:        body,
+		Bucket:      &destinationBucket,
+		ContentType: &mimeType,
+		Key:         &destinationKey,
+		Metadata:    fileMetadata(info),
+	}
+	for key, value := range s.Metadata {
+		input.Metadata[key] = aws.String(value)
+	}
+	if len(s.Tags) > 0 {
+		input.Tagging = aws.String(encodeTags(s.Tags))
+	}
+	s.uploadOptions(input)
+	s.uploadHeaders(input, name)
+
+	err = s.withACL(func() error {
+		s.uploadACL(input)
+		_, err := body.Seek(0, io.SeekStart)
+		if err == nil {
+			_, err = s.Uploader.Upload(input)
+		}
+		return err
+	})
+
+	return err
+}
@@ -0,0 +1,393 @@
+package s3sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// versionManifestFlush is how many replicated versions are recorded between manifest writes
+const versionManifestFlush = 1000
+
+// VersionManifest records every source version replicated by an AllVersions sync along with the
+// version it created in the destination, keyed by the key relative to the source. Versions that
+// are already in the manifest are skipped by later runs.
+type VersionManifest struct {
+	Source      string                         `json:"source"`
+	Destination string                         `json:"destination"`
+	Keys        map[string][]ReplicatedVersion `json:"keys"`
+}
+
+// ReplicatedVersion maps one source version or delete marker to its copy in the destination
+type ReplicatedVersion struct {
+	SourceVersion      string    `json:"source_version"`
+	DestinationVersion string    `json:"destination_version"`
+	DeleteMarker       bool      `json:"delete_marker,omitempty"`
+	LastModified       time.Time `json:"last_modified"`
+}
+
+// keyHistory is every version and delete marker of a single source key, oldest first
+type keyHistory struct {
+	key      string
+	name     string
+	versions []sourceVersion
+}
+
+// sourceVersion is a single entry from ListObjectVersions
+type sourceVersion struct {
+	deleteMarker bool
+	key          string
+	lastModified time.Time
+	size         int64
+	versionID    string
+}
+
+// versionReplicator holds the manifest while an AllVersions sync is running
+type versionReplicator struct {
+	lock     sync.Mutex
+	manifest *VersionManifest
+	unsaved  int
+}
+
+// validateVersions checks the AllVersions options
+func (s *Syncer) validateVersions() error {
+	if s.AllVersions == false {
+		if s.VersionManifest != "" {
+			return fmt.Errorf("the VersionManifest option requires AllVersions")
+		}
+		return nil
+	}
+
+	if s.VersionManifest == "" {
+		return fmt.Errorf("the AllVersions option requires a VersionManifest")
+	}
+	if s.AsOf.IsZero() == false {
+		return fmt.Errorf("the AllVersions and AsOf options cannot be used together")
+	}
+	if s.Delete == true {
+		return fmt.Errorf("the AllVersions option replicates delete markers and cannot be used with Delete")
+	}
+
+	return nil
+}
+
+// replicateVersions copies the whole history of every source key to the destination, oldest
+// version first, and recreates delete markers. The versions of a key are replicated in order by a
+// single worker, which stops at the first failure so the destination history never has gaps. The
+// result counts keys rather than versions.
+func (s *Syncer) replicateVersions() (SyncResult, error) {
+	var (
+		err        error
+		histories  chan keyHistory
+		listErr    error
+		replicator *versionReplicator
+		result     SyncResult
+		results    chan SyncOutput
+		versioning *s3.GetBucketVersioningOutput
+		wg         sync.WaitGroup
+	)
+
+	versioning, err = s.S3.GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(s.Differ.DestinationBucket),
+	})
+	if err != nil {
+		return result, fmt.Errorf("unable to check versioning on %s: %s", s.Differ.DestinationBucket, err)
+	}
+	if aws.StringValue(versioning.Status) != s3.BucketVersioningStatusEnabled {
+		return result, fmt.Errorf("the destination bucket %s must have versioning enabled to replicate all versions", s.Differ.DestinationBucket)
+	}
+
+	replicator = &versionReplicator{}
+	replicator.manifest, err = readVersionManifest(s.VersionManifest)
+	if err != nil {
+		return result, err
+	}
+	if replicator.manifest.Source == "" {
+		replicator.manifest.Source = s.Source
+		replicator.manifest.Destination = s.Destination
+	}
+	if replicator.manifest.Source != s.Source || replicator.manifest.Destination != s.Destination {
+		return result, fmt.Errorf("the version manifest %s belongs to %s -> %s", s.VersionManifest, replicator.manifest.Source, replicator.manifest.Destination)
+	}
+
+	histories = make(chan keyHistory, s.MaxThreads)
+	results = make(chan SyncOutput, s.MaxThreads)
+
+	for w := 1; w <= s.MaxThreads; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			s.versionWorker(w, replicator, histories, results)
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(histories)
+
+		var history *keyHistory
+
+		prefix := ""
+		if s.Differ.SourcePath != "" {
+			prefix = strings.TrimSuffix(s.Differ.SourcePath, "/") + "/"
+		}
+
+		listErr = s.S3.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+			Bucket: aws.String(s.Differ.SourceBucket),
+			Prefix: aws.String(prefix),
+		}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			var entries []sourceVersion
+			for _, v := range page.Versions {
+				entries = append(entries, sourceVersion{
+					key:          aws.StringValue(v.Key),
+					lastModified: aws.TimeValue(v.LastModified),
+					size:         aws.Int64Value(v.Size),
+					versionID:    aws.StringValue(v.VersionId),
+				})
+			}
+			for _, m := range page.DeleteMarkers {
+				entries = append(entries, sourceVersion{
+					deleteMarker: true,
+					key:          aws.StringValue(m.Key),
+					lastModified: aws.TimeValue(m.LastModified),
+					versionID:    aws.StringValue(m.VersionId),
+				})
+			}
+			sort.SliceStable(entries, func(i, j int) bool {
+				return entries[i].key < entries[j].key
+			})
+
+			for _, entry := range entries {
+				if history != nil && history.key != entry.key {
+					histories <- *history
+					history = nil
+				}
+				if history == nil {
+					history = &keyHistory{key: entry.key, name: strings.TrimPrefix(entry.key, prefix)}
+				}
+				history.versions = append(history.versions, entry)
+			}
+			return true
+		})
+
+		if history != nil && listErr == nil {
+			histories <- *history
+		}
+	}()
+
+	for output := range results {
+		if output.Status == StatusFailed {
+			result.Failed++
+			result.FailedBytes += output.Size
+			continue
+		}
+		result.Transferred++
+		result.TransferredBytes += output.Size
+	}
+
+	if s.Dryrun == false {
+		err = replicator.save(s.VersionManifest)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if listErr != nil {
+		return result, fmt.Errorf("unable to list the source versions: %s", listErr)
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%d of %d key(s) could not be fully replicated", result.Failed, result.Failed+result.Transferred)
+	}
+
+	return result, nil
+}
+
+// versionWorker replicates the versions of each key it receives that are not in the manifest yet.
+// The size of a result is the bytes copied, or the bytes left to copy when the key failed.
+func (s *Syncer) versionWorker(id int, replicator *versionReplicator, histories <-chan keyHistory, results chan<- SyncOutput) {
+	var (
+		copied         int64
+		destinationKey string
+		done           map[string]bool
+		err            error
+		remaining      int64
+		replicated     ReplicatedVersion
+	)
+
+	for history := range histories {
+		destinationKey = strings.TrimLeft(strings.TrimSuffix(s.Differ.DestinationPath, "/")+"/"+history.name, "/")
+		done = replicator.replicated(history.name)
+
+		// The listing returns the versions of a key newest first
+		sort.SliceStable(history.versions, func(i, j int) bool {
+			return history.versions[i].lastModified.Before(history.versions[j].lastModified)
+		})
+
+		copied = 0
+		err = nil
+		remaining = 0
+		for _, version := range history.versions {
+			if done[version.versionID] {
+				continue
+			}
+			if err != nil {
+				remaining += version.size
+				continue
+			}
+
+			replicated, err = s.replicateVersion(history.key, destinationKey, version)
+			if err != nil {
+				err = fmt.Errorf("failed to replicate version %s of s3://%s/%s: %s", version.versionID, s.Differ.SourceBucket, history.key, err)
+				fmt.Println(err)
+				remaining += version.size
+				continue
+			}
+			copied += version.size
+			if s.Dryrun == false {
+				replicator.record(history.name, replicated, s.VersionManifest)
+			}
+		}
+
+		if err != nil {
+			results <- SyncOutput{Message: err.Error(), Size: remaining, Status: StatusFailed}
+			continue
+		}
+		results <- SyncOutput{Message: history.key, Size: copied, Status: StatusOK}
+	}
+}
+
+// replicateVersion copies one source version to the destination key, or places a delete marker on
+// it, and returns the version created
+func (s *Syncer) replicateVersion(sourceKey string, destinationKey string, version sourceVersion) (ReplicatedVersion, error) {
+	var (
+		destination string
+		replicated  ReplicatedVersion
+	)
+
+	destination = fmt.Sprintf("s3://%s/%s", s.Differ.DestinationBucket, destinationKey)
+	replicated = ReplicatedVersion{
+		SourceVersion: version.versionID,
+		DeleteMarker:  version.deleteMarker,
+		LastModified:  version.lastModified,
+	}
+
+	if version.deleteMarker {
+		if s.Dryrun == true {
+			dryrun(fmt.Sprintf("delete marker: %s (version %s)", destination, version.versionID))
+			return replicated, nil
+		}
+		fmt.Printf("delete marker: %s (version %s)\n", destination, version.versionID)
+		resp, err := s.S3.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(s.Differ.DestinationBucket),
+			Key:    aws.String(destinationKey),
+		})
+		if err != nil {
+			return replicated, err
+		}
+		replicated.DestinationVersion = aws.StringValue(resp.VersionId)
+		return replicated, nil
+	}
+
+	message := fmt.Sprintf("copy: s3://%s/%s (version %s) to %s", s.Differ.SourceBucket, sourceKey, version.versionID, destination)
+	if s.Dryrun == true {
+		dryrun(message)
+		return replicated, nil
+	}
+	fmt.Println(message)
+
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(s.Differ.DestinationBucket),
+		CopySource: aws.String(copySource(s.Differ.SourceBucket, sourceKey) + "?versionId=" + url.QueryEscape(version.versionID)),
+		Key:        aws.String(destinationKey),
+	}
+	s.copyOptions(input, s.sseCustomerSourceKey)
+
+	err := s.withACL(func() error {
+		var err error
+		s.copyACL(input)
+		replicated.DestinationVersion, err = s.copyObject(input, version.size)
+		return err
+	})
+	if err != nil {
+		return replicated, err
+	}
+
+	return replicated, nil
+}
+
+// replicated returns the source versions of a key that are already in the manifest
+func (r *versionReplicator) replicated(name string) map[string]bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	done := make(map[string]bool)
+	for _, version := range r.manifest.Keys[name] {
+		done[version.SourceVersion] = true
+	}
+	return done
+}
+
+// record adds a replicated version to the manifest, writing it out every versionManifestFlush versions
+func (r *versionReplicator) record(name string, version ReplicatedVersion, path string) {
+	r.lock.Lock()
+	r.manifest.Keys[name] = append(r.manifest.Keys[name], version)
+	r.unsaved++
+	flush := r.unsaved >= versionManifestFlush
+	r.lock.Unlock()
+
+	if flush {
+		err := r.save(path)
+		if err != nil {
+			fmt.Printf("unable to write the version manifest: %s\n", err)
+		}
+	}
+}
+
+// save writes the manifest
+func (r *versionReplicator) save(path string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	err := WriteJSONFile(path, r.manifest)
+	if err != nil {
+		return fmt.Errorf("unable to write the version manifest: %s", err)
+	}
+
+	r.unsaved = 0
+	return nil
+}
+
+// readVersionManifest reads a manifest written by an earlier run, or returns an empty one
+func readVersionManifest(path string) (*VersionManifest, error) {
+	var manifest VersionManifest
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &VersionManifest{Keys: make(map[string][]ReplicatedVersion)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the version manifest %s: %s", path, err)
+	}
+	if manifest.Keys == nil {
+		manifest.Keys = make(map[string][]ReplicatedVersion)
+	}
+
+	return &manifest, nil
+}
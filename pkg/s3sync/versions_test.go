@@ -0,0 +1,38 @@
+package s3sync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+func TestReplicateLargeVersion(t *testing.T) {
+	f := newFakeS3()
+	defer f.close()
+	f.put("source/data/large", &fakeObject{headers: map[string]string{}, size: maxCopySize + 1})
+
+	s := &Syncer{
+		Differ: &s3diff.Differ{DestinationBucket: "destination", SourceBucket: "source"},
+		S3:     f.client(),
+	}
+	version := sourceVersion{key: "data/large", lastModified: time.Now(), size: maxCopySize + 1, versionID: "v1"}
+
+	_, err := s.replicateVersion("data/large", "data/large", version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := f.received("PUT", "partNumber")
+	if len(parts) != 11 {
+		t.Fatalf("copied %d parts, want 11", len(parts))
+	}
+	for _, part := range parts {
+		if source := part.header.Get("X-Amz-Copy-Source"); source != "source/data/large?versionId=v1" {
+			t.Errorf("part copied from %q, want version v1 of source/data/large", source)
+		}
+	}
+	if len(f.received("POST", "uploadId")) != 1 {
+		t.Errorf("the multipart upload was not completed")
+	}
+}
@@ -0,0 +1,263 @@
+package s3sync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/fsnotify/fsnotify"
+	"github.com/gdanko/golang-s3sync/pkg/s3diff"
+)
+
+// DefaultWatchDebounce is how long Watch waits for changes to stop before syncing them
+const DefaultWatchDebounce = 2 * time.Second
+
+// watchMaxDelay is how many debounce periods a batch can be held back by a steady stream of changes
+const watchMaxDelay = 10
+
+// Watch syncs a local Source to s3 and then keeps the destination up to date as files change,
+// until stop is closed. Changed paths are collected until none have arrived for debounce, or for
+// at most watchMaxDelay debounce periods, and only those paths are diffed and sent through the
+// workers. Deletes in later batches are always made after that batch's transfers.
+func (s *Syncer) Watch(debounce time.Duration, stop <-chan struct{}) error {
+	var err error
+
+	if s.Confirm == true {
+		return fmt.Errorf("the Confirm option can't be used when watching for changes")
+	}
+
+	err = s.connect()
+	if err != nil {
+		return err
+	}
+
+	err = s.init()
+	if err != nil {
+		return err
+	}
+
+	if s.Differ.SourceType != "local" || s.Differ.DestinationType != "s3" {
+		return fmt.Errorf("watching for changes needs a local Source and an s3 Destination")
+	}
+
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+
+	return s.withLock(func() error {
+		return s.watch(debounce, stop)
+	})
+}
+
+// watch runs the initial sync and then syncs the batches of changes until stop is closed
+func (s *Syncer) watch(debounce time.Duration, stop <-chan struct{}) error {
+	var (
+		batch            map[string]bool
+		destinationCount int
+		err              error
+		started          time.Time
+		timer            *time.Timer
+		wait             time.Duration
+		watcher          *fsnotify.Watcher
+	)
+
+	watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch %s: %s", s.Differ.SourcePath, err)
+	}
+	defer watcher.Close()
+
+	// The watches go in before the initial sync so nothing changed while it runs is missed
+	err = watchTree(watcher, s.Differ.SourcePath)
+	if err != nil {
+		return fmt.Errorf("unable to watch %s: %s", s.Differ.SourcePath, err)
+	}
+
+	_, err = s.syncFiles()
+	if err != nil {
+		fmt.Println(err)
+	}
+	// MaxDeletePercent keeps measuring against the size of the whole destination
+	destinationCount = s.Differ.DestinationCount
+	if s.DeleteMode == DeleteBefore {
+		s.DeleteMode = DeleteAfter
+	}
+
+	fmt.Printf("watching %s for changes\n", s.Differ.SourcePath)
+
+	batch = make(map[string]bool)
+	timer = time.NewTimer(debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if ok == false {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			name, err := filepath.Rel(s.Differ.SourcePath, event.Name)
+			if err != nil || name == "." {
+				continue
+			}
+
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					err = watchTree(watcher, event.Name)
+					if err != nil {
+						fmt.Printf("unable to watch %s: %s\n", event.Name, err)
+					}
+				}
+			}
+			// A directory moved within the tree is watched again under its new name when it is created there
+			if event.Op&fsnotify.Rename != 0 {
+				watcher.Remove(event.Name)
+			}
+
+			if len(batch) == 0 {
+				started = time.Now()
+			}
+			batch[filepath.ToSlash(name)] = true
+
+			wait = debounce
+			if remaining := time.Until(started.Add(watchMaxDelay * debounce)); remaining < wait {
+				wait = remaining
+			}
+			if timer.Stop() == false {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+
+		case err, ok := <-watcher.Errors:
+			if ok == false {
+				return nil
+			}
+			fmt.Printf("watch error: %s\n", err)
+
+		case <-timer.C:
+			names := make([]string, 0, len(batch))
+			for name := range batch {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			batch = make(map[string]bool)
+
+			err = s.syncPaths(names, destinationCount)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// syncPaths diffs and syncs the given names, relative to the Source, and everything under them.
+// destinationCount is the number of files in the whole destination.
+func (s *Syncer) syncPaths(names []string, destinationCount int) error {
+	var (
+		diffErrs    chan error
+		err         error
+		items       chan s3diff.SyncItem
+		sourceCount int
+		summary     transferSummary
+	)
+
+	fmt.Printf("syncing %d changed path(s)\n", len(names))
+
+	items = make(chan s3diff.SyncItem, s.MaxThreads)
+	diffErrs = make(chan error, 1)
+
+	go func() {
+		diffErrs <- s.Differ.StreamPaths(names, items)
+	}()
+
+	summary = s.transfer(items)
+
+	err = <-diffErrs
+	if err != nil {
+		return fmt.Errorf("unable to diff the changed paths, skipping deletes: %s", err)
+	}
+
+	// The deletion safeguards look at the whole Source, which is only empty when it has been
+	// unmounted or removed, not at the few paths that changed
+	if len(summary.deletes) > 0 {
+		sourceCount, err = s.sourceFiles()
+		if err != nil {
+			return fmt.Errorf("unable to check the source, skipping deletes: %s", err)
+		}
+	}
+
+	err = s.finishDeletes(&summary, sourceCount, destinationCount)
+	if err != nil {
+		return err
+	}
+
+	if summary.failed > 0 {
+		return fmt.Errorf("%d of %d transfer(s) failed", summary.failed, summary.total)
+	}
+
+	return nil
+}
+
+// sourceFiles returns 1 if the Source has anything in it and 0 if it is empty or missing
+func (s *Syncer) sourceFiles() (int, error) {
+	if s.Differ.SourceType == "s3" {
+		prefix := ""
+		if s.Differ.SourcePath != "" {
+			prefix = strings.TrimSuffix(s.Differ.SourcePath, "/") + "/"
+		}
+		resp, err := s.S3.ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:  aws.String(s.Differ.SourceBucket),
+			MaxKeys: aws.Int64(1),
+			Prefix:  aws.String(prefix),
+		})
+		if err != nil {
+			return 0, err
+		}
+		return len(resp.Contents), nil
+	}
+
+	entries, err := ioutil.ReadDir(s.Differ.SourcePath)
+	if err != nil && os.IsNotExist(err) == false {
+		return 0, err
+	}
+	if len(entries) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// watchTree watches a directory and every directory under it. Directories that disappear while
+// they are being added are skipped.
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() == false {
+			return nil
+		}
+		err = watcher.Add(path)
+		if err != nil && os.IsNotExist(err) == false {
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,107 @@
+package s3sync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// waitFor polls until done returns true, failing the test after a few seconds
+func waitFor(t *testing.T, what string, done func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if done() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// watchSyncer returns a Syncer from dir to s3://bucket/data on f that deletes extraneous objects
+func watchSyncer(t *testing.T, f *fakeS3, dir string) *Syncer {
+	s := &Syncer{Delete: true, Destination: "s3://bucket/data", MaxThreads: 2, S3: f.client(), Source: dir}
+	s.Uploader = s3manager.NewUploaderWithClient(s.S3)
+	diffItems(t, s)
+	return s
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"keep", "old"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := newFakeS3()
+	defer f.close()
+	f.put("bucket/data/extra", &fakeObject{body: []byte("extra")})
+
+	s := watchSyncer(t, f, dir)
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.watch(20*time.Millisecond, stop)
+	}()
+
+	waitFor(t, "the initial sync", func() bool {
+		return f.get("bucket/data/old") != nil && f.get("bucket/data/extra") == nil
+	})
+
+	// Files in a directory created after the watch started are picked up too
+	err = os.MkdirAll(filepath.Join(dir, "sub", "dir"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "sub", "dir", "new"), []byte("new"), 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "keep"), []byte("changed"), 0644)
+	}
+	if err == nil {
+		err = os.Remove(filepath.Join(dir, "old"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the changes to be synced", func() bool {
+		keep := f.get("bucket/data/keep")
+		return f.get("bucket/data/sub/dir/new") != nil && f.get("bucket/data/old") == nil && keep != nil && string(keep.body) == "changed"
+	})
+
+	close(stop)
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncPathsKeepsDestinationOfEmptySource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := newFakeS3()
+	defer f.close()
+	f.put("bucket/data/a", &fakeObject{body: []byte("a")})
+	f.put("bucket/data/b", &fakeObject{body: []byte("b")})
+
+	// The source was emptied, e.g. by an unmount, after the watch started
+	s := watchSyncer(t, f, dir)
+	err = s.syncPaths([]string{"a"}, 2)
+	if err == nil {
+		t.Errorf("syncPaths succeeded deleting from an empty source")
+	}
+	if f.get("bucket/data/a") == nil {
+		t.Errorf("a was deleted while the source was empty")
+	}
+}